@@ -0,0 +1,305 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bisect defines methods for finding the snapshot that introduced a change.
+package bisect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/recursive-version-control-system/log"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// Result is the outcome of testing a single snapshot.
+type Result int
+
+const (
+	// Good means the snapshot does not have the change being searched for.
+	Good Result = iota
+
+	// Bad means the snapshot has the change being searched for.
+	Bad
+
+	// Skip means the snapshot could not be tested.
+	Skip
+)
+
+// String returns a human readable description of the result.
+func (r Result) String() string {
+	switch r {
+	case Good:
+		return "good"
+	case Bad:
+		return "bad"
+	case Skip:
+		return "skip"
+	}
+	return fmt.Sprintf("unknown result %d", int(r))
+}
+
+// Tester tests a single snapshot.
+type Tester func(context.Context, *snapshot.Hash) (Result, error)
+
+// Step records the testing of a single snapshot.
+type Step struct {
+	Hash   *snapshot.Hash
+	Result Result
+}
+
+// Outcome is the result of a bisection.
+type Outcome struct {
+	// FirstBad is the first bad snapshot, or nil if that could not be
+	// determined because some snapshots were skipped.
+	FirstBad *snapshot.Hash
+
+	// Candidates holds the snapshots that might be the first bad snapshot
+	// when `FirstBad` is nil. This is the latest known bad snapshot along
+	// with every skipped snapshot that could still be the first bad one.
+	Candidates []*snapshot.Hash
+
+	// Steps holds each snapshot that was tested, in order.
+	Steps []*Step
+}
+
+// history holds the ancestry of the bad snapshot.
+type history struct {
+	// order holds every ancestor of the bad snapshot, including itself,
+	// in breadth first order starting from the bad snapshot.
+	order []*snapshot.Hash
+
+	parents map[snapshot.Hash][]*snapshot.Hash
+}
+
+func readHistory(ctx context.Context, s *storage.LocalFiles, bad *snapshot.Hash) (*history, error) {
+	order, err := log.ReadAncestors(ctx, s, bad, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the history of %q: %v", bad, err)
+	}
+	result := &history{
+		order:   order,
+		parents: make(map[snapshot.Hash][]*snapshot.Hash),
+	}
+	for _, h := range order {
+		e, err := s.Ancestry(ctx, h)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading the ancestry of %q: %v", h, err)
+		}
+		result.parents[*h] = e.Parents
+	}
+	return result, nil
+}
+
+// reachable returns the snapshots in `within` that are reachable from
+// `h` (including `h` itself), only passing through snapshots in `within`.
+func (hist *history) reachable(h *snapshot.Hash, within map[snapshot.Hash]struct{}) map[snapshot.Hash]struct{} {
+	result := make(map[snapshot.Hash]struct{})
+	if _, ok := within[*h]; !ok {
+		return result
+	}
+	result[*h] = struct{}{}
+	stack := []*snapshot.Hash{h}
+	for len(stack) > 0 {
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, p := range hist.parents[*next] {
+			if _, ok := within[*p]; !ok {
+				continue
+			}
+			if _, ok := result[*p]; ok {
+				continue
+			}
+			result[*p] = struct{}{}
+			stack = append(stack, p)
+		}
+	}
+	return result
+}
+
+// ancestorCounts returns the number of snapshots in `candidates` that are
+// reachable from each of them (including itself), only passing through
+// snapshots in `candidates`.
+//
+// The candidates are visited with their parents first, so that the count
+// for a candidate with at most one parent in `candidates` is computed from
+// the count for that parent. Only merges need their ancestors walked, since
+// the ancestors of their parents may overlap.
+func (hist *history) ancestorCounts(candidates map[snapshot.Hash]struct{}) map[snapshot.Hash]int {
+	counts := make(map[snapshot.Hash]int)
+	visited := make(map[snapshot.Hash]struct{})
+	for _, root := range hist.order {
+		if _, ok := candidates[*root]; !ok {
+			continue
+		}
+		if _, ok := visited[*root]; ok {
+			continue
+		}
+		visited[*root] = struct{}{}
+		// Each stack entry is a snapshot along with the number of its
+		// parents that have already been pushed.
+		type frame struct {
+			h    *snapshot.Hash
+			next int
+		}
+		stack := []*frame{{h: root}}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			parents := hist.parents[*top.h]
+			if top.next < len(parents) {
+				p := parents[top.next]
+				top.next++
+				if _, ok := candidates[*p]; !ok {
+					continue
+				}
+				if _, ok := visited[*p]; !ok {
+					visited[*p] = struct{}{}
+					stack = append(stack, &frame{h: p})
+				}
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			var inCandidates []*snapshot.Hash
+			for _, p := range parents {
+				if _, ok := candidates[*p]; ok {
+					inCandidates = append(inCandidates, p)
+				}
+			}
+			switch len(inCandidates) {
+			case 0:
+				counts[*top.h] = 1
+			case 1:
+				counts[*top.h] = counts[*inCandidates[0]] + 1
+			default:
+				counts[*top.h] = len(hist.reachable(top.h, candidates))
+			}
+		}
+	}
+	return counts
+}
+
+// pick selects the untested candidate that splits the remaining candidates
+// most evenly, or nil if every candidate other than `bad` has been tested
+// or skipped.
+//
+// Testing a candidate either eliminates it and its ancestors (if it is
+// good), or everything except it and its ancestors (if it is bad), so the
+// best candidate is the one whose ancestors are closest to half of the
+// remaining candidates.
+func (hist *history) pick(candidates, skipped map[snapshot.Hash]struct{}, bad *snapshot.Hash) *snapshot.Hash {
+	var best *snapshot.Hash
+	bestScore := -1
+	counts := hist.ancestorCounts(candidates)
+	for _, h := range hist.order {
+		if _, ok := candidates[*h]; !ok || h.Equal(bad) {
+			continue
+		}
+		if _, ok := skipped[*h]; ok {
+			continue
+		}
+		ancestors := counts[*h]
+		score := ancestors
+		if others := len(candidates) - ancestors; others < score {
+			score = others
+		}
+		if score > bestScore {
+			best = h
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// Bisect finds the first bad snapshot in the history of `bad`.
+//
+// Every ancestor of any snapshot in `good` is assumed to be good, and the
+// remaining ancestors of `bad` are tested with `test` until the snapshot
+// that introduced the change is found. That is the bad snapshot whose
+// parents are all good.
+//
+// Merge snapshots are handled by considering every parent, so a change
+// introduced on any branch of a merge is found. Snapshots for which `test`
+// returns `Skip` are never used to narrow down the search, so if one of
+// them might be the first bad snapshot then the outcome lists every
+// remaining candidate instead.
+func Bisect(ctx context.Context, s *storage.LocalFiles, good []*snapshot.Hash, bad *snapshot.Hash, test Tester) (*Outcome, error) {
+	if bad == nil {
+		return nil, errors.New("a bad snapshot is required")
+	}
+	hist, err := readHistory(ctx, s, bad)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make(map[snapshot.Hash]struct{})
+	for _, h := range hist.order {
+		candidates[*h] = struct{}{}
+	}
+	for _, g := range good {
+		if g.Equal(bad) {
+			return nil, fmt.Errorf("the snapshot %q cannot be both good and bad", g)
+		}
+		goodAncestors, err := log.ReadAncestors(ctx, s, g, -1)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading the history of the good snapshot %q: %v", g, err)
+		}
+		for _, h := range goodAncestors {
+			delete(candidates, *h)
+		}
+	}
+	if _, ok := candidates[*bad]; !ok {
+		return nil, fmt.Errorf("the bad snapshot %q is an ancestor of a good snapshot", bad)
+	}
+	outcome := &Outcome{}
+	skipped := make(map[snapshot.Hash]struct{})
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		next := hist.pick(candidates, skipped, bad)
+		if next == nil {
+			break
+		}
+		result, err := test(ctx, next)
+		if err != nil {
+			return nil, fmt.Errorf("failure testing the snapshot %q: %v", next, err)
+		}
+		outcome.Steps = append(outcome.Steps, &Step{Hash: next, Result: result})
+		switch result {
+		case Good:
+			for h := range hist.reachable(next, candidates) {
+				delete(candidates, h)
+			}
+		case Bad:
+			candidates = hist.reachable(next, candidates)
+			bad = next
+		case Skip:
+			skipped[*next] = struct{}{}
+		default:
+			return nil, fmt.Errorf("unexpected result testing the snapshot %q: %v", next, result)
+		}
+	}
+	if len(candidates) == 1 {
+		outcome.FirstBad = bad
+		return outcome, nil
+	}
+	outcome.Candidates = []*snapshot.Hash{bad}
+	for _, h := range hist.order {
+		if _, ok := candidates[*h]; ok && !h.Equal(bad) {
+			outcome.Candidates = append(outcome.Candidates, h)
+		}
+	}
+	return outcome, nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bisect
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func storeFile(ctx context.Context, t *testing.T, s *storage.LocalFiles, contents string, parents ...*snapshot.Hash) *snapshot.Hash {
+	contentsHash, err := s.StoreObject(ctx, int64(len(contents)), strings.NewReader(contents))
+	if err != nil {
+		t.Fatalf("failure storing the contents %q: %v", contents, err)
+	}
+	f := &snapshot.File{Mode: "-rw-------", Contents: contentsHash, Parents: parents}
+	h, err := s.StoreSnapshot(ctx, snapshot.Path(filepath.Join(s.ArchiveDir, "..", "file.txt")), f)
+	if err != nil {
+		t.Fatalf("failure storing the file snapshot: %v", err)
+	}
+	return h
+}
+
+// testerFor returns a tester that reports every snapshot in `skip` as
+// skipped, every other snapshot in `bad` as bad, and everything else as good.
+func testerFor(bad, skip []*snapshot.Hash) Tester {
+	return func(ctx context.Context, h *snapshot.Hash) (Result, error) {
+		for _, s := range skip {
+			if h.Equal(s) {
+				return Skip, nil
+			}
+		}
+		for _, b := range bad {
+			if h.Equal(b) {
+				return Bad, nil
+			}
+		}
+		return Good, nil
+	}
+}
+
+func TestBisectLinear(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive")}
+	var history []*snapshot.Hash
+	var prev *snapshot.Hash
+	for i := 0; i < 64; i++ {
+		var parents []*snapshot.Hash
+		if prev != nil {
+			parents = append(parents, prev)
+		}
+		prev = storeFile(ctx, t, s, fmt.Sprintf("version %d", i), parents...)
+		history = append(history, prev)
+	}
+	outcome, err := Bisect(ctx, s, history[:1], history[63], testerFor(history[37:], nil))
+	if err != nil {
+		t.Fatalf("failure bisecting: %v", err)
+	}
+	if !outcome.FirstBad.Equal(history[37]) {
+		t.Errorf("unexpected first bad snapshot: got %q, want %q", outcome.FirstBad, history[37])
+	}
+	if len(outcome.Steps) > 6 {
+		t.Errorf("unexpected number of steps: got %d, want at most 6", len(outcome.Steps))
+	}
+	if _, err := Bisect(ctx, s, history[63:], history[0], testerFor(nil, nil)); err == nil {
+		t.Error("unexpected success bisecting with a bad snapshot older than the good one")
+	}
+}
+
+func TestBisectMerge(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive")}
+
+	// The history is:
+	//
+	//      R
+	//     / \
+	//    A   C
+	//    |   |
+	//    B   |
+	//     \ /
+	//      M
+	//      |
+	//      D
+	//
+	// ... and the change was introduced on the branch with C.
+	r := storeFile(ctx, t, s, "r")
+	a := storeFile(ctx, t, s, "a", r)
+	b := storeFile(ctx, t, s, "b", a)
+	c := storeFile(ctx, t, s, "c", r)
+	m := storeFile(ctx, t, s, "m", b, c)
+	d := storeFile(ctx, t, s, "d", m)
+
+	bad := []*snapshot.Hash{c, m, d}
+	outcome, err := Bisect(ctx, s, []*snapshot.Hash{r}, d, testerFor(bad, nil))
+	if err != nil {
+		t.Fatalf("failure bisecting: %v", err)
+	}
+	if !outcome.FirstBad.Equal(c) {
+		t.Errorf("unexpected first bad snapshot: got %q, want %q", outcome.FirstBad, c)
+	}
+
+	// The change was introduced by the merge itself.
+	outcome, err = Bisect(ctx, s, []*snapshot.Hash{r}, d, testerFor([]*snapshot.Hash{m, d}, nil))
+	if err != nil {
+		t.Fatalf("failure bisecting: %v", err)
+	}
+	if !outcome.FirstBad.Equal(m) {
+		t.Errorf("unexpected first bad snapshot: got %q, want %q", outcome.FirstBad, m)
+	}
+
+	// When the first bad snapshot is skipped, every remaining candidate is reported.
+	outcome, err = Bisect(ctx, s, []*snapshot.Hash{r}, d, testerFor(bad, []*snapshot.Hash{c}))
+	if err != nil {
+		t.Fatalf("failure bisecting: %v", err)
+	}
+	if outcome.FirstBad != nil {
+		t.Errorf("unexpected first bad snapshot with a skipped candidate: %q", outcome.FirstBad)
+	}
+	if len(outcome.Candidates) != 2 || !outcome.Candidates[0].Equal(m) || !outcome.Candidates[1].Equal(c) {
+		t.Errorf("unexpected candidates: got %v, want [%q %q]", outcome.Candidates, m, c)
+	}
+	for _, step := range outcome.Steps {
+		if step.Hash.Equal(d) || step.Hash.Equal(r) {
+			t.Errorf("unexpected test of a snapshot with a known result: %q", step.Hash)
+		}
+	}
+}
+
+func TestAncestorCounts(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive")}
+
+	// The history has criss-cross merges, so the ancestors of the
+	// parents of each merge overlap.
+	r := storeFile(ctx, t, s, "r")
+	a := storeFile(ctx, t, s, "a", r)
+	b := storeFile(ctx, t, s, "b", r)
+	m1 := storeFile(ctx, t, s, "m1", a, b)
+	m2 := storeFile(ctx, t, s, "m2", b, a)
+	c := storeFile(ctx, t, s, "c", m1)
+	d := storeFile(ctx, t, s, "d", c, m2)
+
+	hist, err := readHistory(ctx, s, d)
+	if err != nil {
+		t.Fatalf("failure reading the history: %v", err)
+	}
+	candidates := make(map[snapshot.Hash]struct{})
+	for _, h := range hist.order {
+		if !h.Equal(r) {
+			candidates[*h] = struct{}{}
+		}
+	}
+	counts := hist.ancestorCounts(candidates)
+	if len(counts) != len(candidates) {
+		t.Errorf("unexpected number of counts: got %d, want %d", len(counts), len(candidates))
+	}
+	for h := range candidates {
+		if got, want := counts[h], len(hist.reachable(&h, candidates)); got != want {
+			t.Errorf("unexpected ancestor count for %q: got %d, want %d", &h, got, want)
+		}
+	}
+}
@@ -22,9 +22,11 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 
+	"github.com/google/recursive-version-control-system/progress"
 	"github.com/google/recursive-version-control-system/snapshot"
 	"github.com/google/recursive-version-control-system/storage"
 )
@@ -39,11 +41,21 @@ func bundleEntryPath(h *snapshot.Hash) string {
 	return path.Join("objects", h.Function(), h.HexContents())
 }
 
+// annotationEntryPath returns the bundle path of the entry that links the
+// given snapshot to its annotation.
+func annotationEntryPath(h *snapshot.Hash) string {
+	return path.Join("annotations", strings.TrimPrefix(bundleEntryPath(h), "objects/"))
+}
+
 func bundlePathHash(path string) (*snapshot.Hash, error) {
-	if !strings.HasPrefix(path, "objects/") {
-		return nil, fmt.Errorf("Path %q is not an object path", path)
+	return entryPathHash("objects/", path)
+}
+
+func entryPathHash(prefix, path string) (*snapshot.Hash, error) {
+	if !strings.HasPrefix(path, prefix) {
+		return nil, fmt.Errorf("Path %q is not under %q", path, prefix)
 	}
-	p := strings.TrimPrefix(path, "objects/")
+	p := strings.TrimPrefix(path, prefix)
 	parts := strings.Split(p, "/")
 	if len(parts) < 2 {
 		return nil, fmt.Errorf("Path %q does not correspond to a valid hash", path)
@@ -114,17 +126,53 @@ func (w *ZipWriter) AddObject(ctx context.Context, s *storage.LocalFiles, h *sna
 	if err != nil {
 		return fmt.Errorf("failure creating the zip file entry for %q: %v", h, err)
 	}
-	if _, err := io.Copy(fw, r); err != nil {
+	n, err := io.Copy(fw, progress.Reader(ctx, r))
+	if err != nil {
 		return fmt.Errorf("failure writing the zip file entry for %q: %v", h, err)
 	}
 	w.included = append(w.included, h)
+	progress.Add(ctx, 1, n)
+	return nil
+}
+
+// addAnnotation adds the annotation for the given snapshot (if any) to the bundle.
+//
+// Both the annotation object and the link from the snapshot to it are
+// added, so that importing the bundle restores the annotation.
+func (w *ZipWriter) addAnnotation(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash) error {
+	annotationHash, a, err := s.FindAnnotation(ctx, h)
+	if err != nil {
+		return fmt.Errorf("failure looking up the annotation for %q: %v", h, err)
+	}
+	if a == nil {
+		return nil
+	}
+	if err := w.AddObject(ctx, s, annotationHash); err != nil {
+		return fmt.Errorf("failure adding the annotation %q to the bundle: %v", annotationHash, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fw, err := w.nested.Create(annotationEntryPath(h))
+	if err != nil {
+		return fmt.Errorf("failure creating the zip file entry for the annotation of %q: %v", h, err)
+	}
+	if _, err := io.WriteString(fw, annotationHash.String()); err != nil {
+		return fmt.Errorf("failure writing the zip file entry for the annotation of %q: %v", h, err)
+	}
 	return nil
 }
 
 func (w *ZipWriter) AddFile(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, f *snapshot.File) (err error) {
+	if _, ok := w.visited[*h]; ok {
+		// We already added this snapshot, and its annotation, to the zip writer.
+		return nil
+	}
 	if err := w.AddObject(ctx, s, h); err != nil {
 		return fmt.Errorf("failure adding the snapshot %q to the bundle: %v", h, err)
 	}
+	if err := w.addAnnotation(ctx, s, h); err != nil {
+		return err
+	}
 	defer func() {
 		if err != nil {
 			return
@@ -154,11 +202,25 @@ func (w *ZipWriter) AddFile(ctx context.Context, s *storage.LocalFiles, h *snaps
 	if !f.IsDir() {
 		return nil
 	}
+	// Large directory trees are split across multiple shard objects,
+	// all of which must be included for the tree to be readable.
+	shards, err := snapshot.TreeObjects(ctx, s, f.Contents)
+	if err != nil {
+		return fmt.Errorf("failure listing the tree objects of the directory snapshot %q: %v", h, err)
+	}
+	for _, shard := range shards {
+		if err := w.AddObject(ctx, s, shard); err != nil {
+			return fmt.Errorf("failure adding the tree object %q to the bundle: %v", shard, err)
+		}
+	}
 	tree, err := s.ListDirectorySnapshotContents(ctx, h, f)
 	if err != nil {
 		return fmt.Errorf("failure reading the contents of the directory snapshot %q: %v", h, err)
 	}
 	for _, childHash := range tree {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if _, ok := w.exclude[*childHash]; ok {
 			continue
 		}
@@ -189,12 +251,29 @@ func (w *ZipWriter) AddFile(ctx context.Context, s *storage.LocalFiles, h *snaps
 //
 // The `metadata` argument specifies an additional map of key/value pairs
 // to include in the bundle in a separate subpath from the bundled objects.
+//
+// The bundle is first written to a temporary file alongside `path`, and
+// only moved into place once it is complete. If the export fails or is
+// cancelled, then the temporary file is removed and `path` is untouched.
 func Export(ctx context.Context, s *storage.LocalFiles, path string, snapshots []*snapshot.Hash, exclude []*snapshot.Hash, metadata map[string]io.ReadCloser, recurseParents bool) (included []*snapshot.Hash, err error) {
-	w, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0700)
+	w, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
 	if err != nil {
-		return nil, fmt.Errorf("failure opening the file %q: %v", path, err)
+		return nil, fmt.Errorf("failure creating a temporary file for %q: %v", path, err)
 	}
-	defer w.Close()
+	defer func() {
+		if ce := w.Close(); err == nil && ce != nil {
+			err = fmt.Errorf("failure closing the temporary file for %q: %v", path, ce)
+		}
+		if err == nil {
+			if re := os.Rename(w.Name(), path); re != nil {
+				err = fmt.Errorf("failure moving the bundle into place at %q: %v", path, re)
+			}
+		}
+		if err != nil {
+			os.Remove(w.Name())
+			included = nil
+		}
+	}()
 	zw, err := NewZipWriter(w, exclude, metadata, recurseParents)
 	if err != nil {
 		return nil, fmt.Errorf("failure creating the zip writer for the bundle: %v", err)
@@ -207,6 +286,9 @@ func Export(ctx context.Context, s *storage.LocalFiles, path string, snapshots [
 	}()
 
 	for _, h := range snapshots {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		f, err := s.ReadSnapshot(ctx, h)
 		if err != nil {
 			return nil, fmt.Errorf("failure reading the snapshot %q: %v", h, err)
@@ -245,30 +327,106 @@ func Import(ctx context.Context, s *storage.LocalFiles, path string, exclude []*
 	}
 	defer r.Close()
 	// We first validate that the bundle only includes valid object contents...
+	var objectCount, objectBytes int64
 	for _, f := range r.File {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		if err := validateZipEntry(ctx, f); err != nil {
 			return nil, fmt.Errorf("failure validating the zip entry %q: %v", f.Name, err)
 		}
+		if _, err := bundlePathHash(f.Name); err == nil {
+			objectCount++
+			objectBytes += int64(f.UncompressedSize64)
+		}
 	}
+	progress.SetTotal(ctx, objectCount, objectBytes)
 	for _, f := range r.File {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		h, err := bundlePathHash(f.Name)
 		if err != nil {
 			// We allow additional/non-object files in bundles
 			continue
 		}
-		if _, err := s.ReadObject(ctx, h); err == nil {
+		size := int64(f.FileInfo().Size())
+		if existing, err := s.ReadObject(ctx, h); err == nil {
 			// We already have this object and can skip importing it.
+			existing.Close()
+			progress.Add(ctx, 1, size)
 			continue
 		}
-		r, err := f.Open()
-		if err != nil {
-			return nil, fmt.Errorf("failure reading entry %q: %v", f.Name, err)
-		}
-		if h, err := s.StoreObject(ctx, int64(f.FileInfo().Size()), r); err != nil {
+		if h, err := importObject(ctx, s, f, size); err != nil {
 			return nil, fmt.Errorf("failure importing the zip entry %q: %v", f.Name, err)
 		} else {
 			included = append(included, h)
 		}
+		progress.Add(ctx, 1, size)
+	}
+	for _, f := range r.File {
+		if err := importAnnotation(ctx, s, f); err != nil {
+			return nil, fmt.Errorf("failure importing the annotation entry %q: %v", f.Name, err)
+		}
 	}
 	return included, nil
 }
+
+func importObject(ctx context.Context, s *storage.LocalFiles, f *zip.File, size int64) (*snapshot.Hash, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failure reading entry %q: %v", f.Name, err)
+	}
+	defer r.Close()
+	return s.StoreObject(ctx, size, r)
+}
+
+// importAnnotation links an imported snapshot to its imported annotation.
+//
+// Annotations that already exist locally take precedence over imported ones.
+func importAnnotation(ctx context.Context, s *storage.LocalFiles, f *zip.File) error {
+	h, err := entryPathHash("annotations/", f.Name)
+	if err != nil {
+		// This is not an annotation entry.
+		return nil
+	}
+	if existing, _, err := s.FindAnnotation(ctx, h); err != nil {
+		return fmt.Errorf("failure looking up the existing annotation for %q: %v", h, err)
+	} else if existing != nil {
+		return nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failure reading entry %q: %v", f.Name, err)
+	}
+	defer r.Close()
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failure reading entry %q: %v", f.Name, err)
+	}
+	annotationHash, err := snapshot.ParseHash(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return fmt.Errorf("failure parsing the annotation hash %q: %v", contents, err)
+	}
+	ar, err := s.ReadObject(ctx, annotationHash)
+	if err != nil {
+		// The annotation was excluded from the bundle and is not known locally.
+		return nil
+	}
+	defer ar.Close()
+	encoded, err := io.ReadAll(ar)
+	if err != nil {
+		return fmt.Errorf("failure reading the annotation %q: %v", annotationHash, err)
+	}
+	a, err := snapshot.ParseAnnotation(string(encoded))
+	if err != nil {
+		return fmt.Errorf("failure parsing the annotation %q: %v", annotationHash, err)
+	}
+	if !a.Snapshot.Equal(h) {
+		return fmt.Errorf("the annotation %q is for %q rather than %q", annotationHash, a.Snapshot, h)
+	}
+	if _, err := s.StoreAnnotation(ctx, a); err != nil {
+		return fmt.Errorf("failure storing the annotation for %q: %v", h, err)
+	}
+	return nil
+}
@@ -17,17 +17,19 @@ package bundle
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/google/recursive-version-control-system/progress"
 	"github.com/google/recursive-version-control-system/snapshot"
 	"github.com/google/recursive-version-control-system/storage"
 )
 
 func TestRoundtrip(t *testing.T) {
 	archiveDir := filepath.Join(t.TempDir(), "archive")
-	s := &storage.LocalFiles{archiveDir}
+	s := &storage.LocalFiles{ArchiveDir: archiveDir}
 
 	workDir := filepath.Join(t.TempDir(), "workDir")
 	if err := os.MkdirAll(workDir, os.FileMode(0700)); err != nil {
@@ -70,7 +72,7 @@ func TestRoundtrip(t *testing.T) {
 	}
 
 	archive2Dir := filepath.Join(t.TempDir(), "archive2")
-	s2 := &storage.LocalFiles{archive2Dir}
+	s2 := &storage.LocalFiles{ArchiveDir: archive2Dir}
 	imported, err := Import(context.Background(), s2, bundleFile, nil)
 	if err != nil {
 		t.Fatalf("failure importing the bundle %q: %v", bundleFile, err)
@@ -96,3 +98,139 @@ func TestRoundtrip(t *testing.T) {
 		t.Errorf("unexpected contents for snapshot %q: got %q, want %q", h1, got, want)
 	}
 }
+
+func TestRoundtripAnnotations(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive")}
+
+	file := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(file, []byte("Hello, World!"), 0700); err != nil {
+		t.Fatalf("failure creating the example file to snapshot: %v", err)
+	}
+	h, _, err := snapshot.Current(ctx, s, snapshot.Path(file))
+	if err != nil {
+		t.Fatalf("failure creating the snapshot for the file: %v", err)
+	}
+	want := &snapshot.Annotation{
+		Snapshot: h,
+		Author:   "someone",
+		Message:  "initial version",
+	}
+	if _, err := s.StoreAnnotation(ctx, want); err != nil {
+		t.Fatalf("failure annotating the snapshot: %v", err)
+	}
+
+	bundleFile := filepath.Join(t.TempDir(), "bundle.zip")
+	if _, err := Export(ctx, s, bundleFile, []*snapshot.Hash{h}, nil, nil, true); err != nil {
+		t.Fatalf("failure creating the bundle %q: %v", bundleFile, err)
+	}
+
+	s2 := &storage.LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive2")}
+	if _, err := Import(ctx, s2, bundleFile, nil); err != nil {
+		t.Fatalf("failure importing the bundle %q: %v", bundleFile, err)
+	}
+	if _, got, err := s2.FindAnnotation(ctx, h); err != nil {
+		t.Errorf("failure looking up the imported annotation: %v", err)
+	} else if got == nil {
+		t.Errorf("missing imported annotation for %q", h)
+	} else if got, want := got.String(), want.String(); got != want {
+		t.Errorf("unexpected imported annotation: got %q, want %q", got, want)
+	}
+}
+
+func TestRoundtripLargeDirectory(t *testing.T) {
+	ctx := context.Background()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive")}
+
+	dir := filepath.Join(t.TempDir(), "dir")
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		t.Fatalf("failure creating the directory to snapshot: %v", err)
+	}
+	for i := 0; i < 1100; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%d.txt", i)), []byte(fmt.Sprintf("file %d", i)), 0700); err != nil {
+			t.Fatalf("failure creating an example file to snapshot: %v", err)
+		}
+	}
+	h, f, err := snapshot.Current(ctx, s, snapshot.Path(dir))
+	if err != nil {
+		t.Fatalf("failure creating the snapshot for the directory: %v", err)
+	}
+
+	bundleFile := filepath.Join(t.TempDir(), "bundle.zip")
+	if _, err := Export(ctx, s, bundleFile, []*snapshot.Hash{h}, nil, nil, true); err != nil {
+		t.Fatalf("failure creating the bundle %q: %v", bundleFile, err)
+	}
+	s2 := &storage.LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive2")}
+	if _, err := Import(ctx, s2, bundleFile, nil); err != nil {
+		t.Fatalf("failure importing the bundle %q: %v", bundleFile, err)
+	}
+	want, err := s.ListDirectorySnapshotContents(ctx, h, f)
+	if err != nil {
+		t.Fatalf("failure listing the contents of the directory snapshot: %v", err)
+	}
+	if got, err := s2.ListDirectorySnapshotContents(ctx, h, f); err != nil {
+		t.Errorf("failure listing the contents of the imported directory snapshot: %v", err)
+	} else if got.String() != want.String() {
+		t.Errorf("unexpected contents for the imported directory snapshot: got %d entries, want %d", len(got), len(want))
+	}
+}
+
+type progressForTest struct {
+	files, bytes int64
+	totalFiles   int64
+}
+
+func (p *progressForTest) SetTotal(files, bytes int64) {
+	p.totalFiles = files
+}
+
+func (p *progressForTest) Add(files, bytes int64) {
+	p.files += files
+	p.bytes += bytes
+}
+
+func TestProgressAndCancellation(t *testing.T) {
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive")}
+	file := filepath.Join(t.TempDir(), "hello.txt")
+	if err := os.WriteFile(file, []byte("Hello, World!"), 0700); err != nil {
+		t.Fatalf("failure creating the example file to snapshot: %v", err)
+	}
+	h, _, err := snapshot.Current(context.Background(), s, snapshot.Path(file))
+	if err != nil {
+		t.Fatalf("failure creating the snapshot for the file: %v", err)
+	}
+
+	bundleDir := t.TempDir()
+	bundleFile := filepath.Join(bundleDir, "bundle.zip")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Export(cancelled, s, bundleFile, []*snapshot.Hash{h}, nil, nil, true); err == nil {
+		t.Errorf("unexpected success exporting with a cancelled context")
+	}
+	if entries, err := os.ReadDir(bundleDir); err != nil {
+		t.Fatalf("failure reading the bundle dir: %v", err)
+	} else if len(entries) > 0 {
+		t.Errorf("cancelled export left files behind: %v", entries)
+	}
+
+	exported := &progressForTest{}
+	included, err := Export(progress.WithReporter(context.Background(), exported), s, bundleFile, []*snapshot.Hash{h}, nil, nil, true)
+	if err != nil {
+		t.Fatalf("failure creating the bundle %q: %v", bundleFile, err)
+	}
+	if got, want := exported.files, int64(len(included)); got != want {
+		t.Errorf("unexpected number of exported objects reported: got %d, want %d", got, want)
+	}
+
+	s2 := &storage.LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive2")}
+	if _, err := Import(cancelled, s2, bundleFile, nil); err == nil {
+		t.Errorf("unexpected success importing with a cancelled context")
+	}
+	imported := &progressForTest{}
+	if _, err := Import(progress.WithReporter(context.Background(), imported), s2, bundleFile, nil); err != nil {
+		t.Fatalf("failure importing the bundle %q: %v", bundleFile, err)
+	}
+	if imported.files != imported.totalFiles || imported.bytes != exported.bytes {
+		t.Errorf("unexpected import progress: got %+v, want %d files and %d bytes", imported, imported.totalFiles, exported.bytes)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 
 	"github.com/google/recursive-version-control-system/command"
@@ -29,9 +30,11 @@ func main() {
 	if err != nil {
 		log.Fatalf("failure resolving the user's home dir: %v\n", err)
 	}
-	s := &storage.LocalFiles{filepath.Join(home, ".rvcs/archive")}
-	ctx := context.Background()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(home, ".rvcs/archive")}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
 	ret := command.Run(ctx, s, os.Args)
+	stop()
 	os.Exit(ret)
 }
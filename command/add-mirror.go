@@ -82,5 +82,14 @@ func addMirrorCommand(ctx context.Context, s *storage.LocalFiles, cmd string, ar
 	if err := settings.Write(); err != nil {
 		return 1, fmt.Errorf("failure writing the updated config settings: %v", err)
 	}
+	if jsonOutput() {
+		out := &mirrorJSON{URL: mirrorURL.String(), ReadOnly: *addMirrorReadOnlyFlag}
+		if id != nil {
+			out.Identity = id.String()
+		}
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+	}
 	return 0, nil
 }
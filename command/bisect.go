@@ -0,0 +1,205 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command defines the command line interface for rvcs
+package command
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/google/recursive-version-control-system/bisect"
+	"github.com/google/recursive-version-control-system/merge"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const bisectUsage = `Usage: %s bisect [<FLAGS>]* <BAD> <GOOD>+ -- <COMMAND> [<ARG>]*
+
+Where <BAD> and each <GOOD> are one of:
+
+	The hash of a known snapshot.
+	A local file path which has previously been snapshotted.
+	An identity for which a snapshot has already been published.
+	Any of the above followed by revision suffixes such as ^1, ~2, @{1}, or :<SUBPATH>.
+
+Finds the first snapshot in the history of <BAD> that is bad, assuming that
+every <GOOD> snapshot and all of their ancestors are good.
+
+Each candidate snapshot is checked out into a scratch location, and then
+<COMMAND> is run inside of it (or inside of its parent directory if the
+snapshot is not a directory). The environment variables RVCS_BISECT_SNAPSHOT
+and RVCS_BISECT_PATH are set to the hash of the candidate snapshot and the
+path it was checked out to. The exit code of <COMMAND> determines the result:
+
+	0 means the snapshot is good.
+	125 means the snapshot cannot be tested, so it is skipped.
+	Any other code from 1 to 127 means the snapshot is bad.
+	Anything else stops the bisection.
+
+`
+
+var (
+	bisectFlags = flag.NewFlagSet("bisect", flag.ContinueOnError)
+
+	bisectScratchFlag = bisectFlags.String(
+		"scratch", "",
+		"path to check out each candidate snapshot to. If empty, then a new temporary directory is used and removed afterwards.")
+)
+
+// bisectSkipCode is the exit code that a test command uses to report that
+// a snapshot cannot be tested.
+const bisectSkipCode = 125
+
+// runBisectTest checks out the given snapshot to `dest` and then runs the
+// test command against it.
+//
+// If `temporary` is true, then `dest` is not mapped to the snapshot.
+func runBisectTest(ctx context.Context, s *storage.LocalFiles, dest snapshot.Path, temporary bool, testCmd []string, h *snapshot.Hash) (bisect.Result, error) {
+	checkout := merge.Checkout
+	if temporary {
+		checkout = merge.Extract
+	}
+	if err := checkout(ctx, s, h, dest); err != nil {
+		return bisect.Skip, fmt.Errorf("failure checking out %q to %q: %v", h, dest, err)
+	}
+	f, err := s.ReadSnapshot(ctx, h)
+	if err != nil {
+		return bisect.Skip, fmt.Errorf("failure reading the snapshot %q: %v", h, err)
+	}
+	cmd := exec.CommandContext(ctx, testCmd[0], testCmd[1:]...)
+	cmd.Dir = string(dest)
+	if !f.IsDir() {
+		cmd.Dir = filepath.Dir(string(dest))
+	}
+	cmd.Env = append(os.Environ(),
+		"RVCS_BISECT_SNAPSHOT="+h.String(),
+		"RVCS_BISECT_PATH="+string(dest))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	if jsonOutput() {
+		// Keep standard output reserved for the JSON document.
+		cmd.Stdout = os.Stderr
+	}
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err == nil {
+		return bisect.Good, nil
+	} else if !errors.As(err, &exitErr) {
+		return bisect.Skip, fmt.Errorf("failure running %q: %v", testCmd[0], err)
+	}
+	switch code := exitErr.ExitCode(); {
+	case code == bisectSkipCode:
+		return bisect.Skip, nil
+	case code > 0 && code < 128:
+		return bisect.Bad, nil
+	default:
+		return bisect.Skip, fmt.Errorf("the command %q was aborted: %v", testCmd[0], err)
+	}
+}
+
+func bisectCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
+	bisectFlags.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), bisectUsage, cmd)
+		bisectFlags.PrintDefaults()
+	}
+	if err := bisectFlags.Parse(args); err != nil {
+		return 1, nil
+	}
+	args = bisectFlags.Args()
+	var revisions, testCmd []string
+	for i, arg := range args {
+		if arg == "--" {
+			revisions, testCmd = args[:i], args[i+1:]
+			break
+		}
+	}
+	if len(revisions) < 2 || len(testCmd) == 0 {
+		bisectFlags.Usage()
+		return 1, nil
+	}
+	bad, err := resolveSnapshot(ctx, s, revisions[0])
+	if err != nil {
+		return 1, fmt.Errorf("failure resolving the snapshot hash for %q: %v", revisions[0], err)
+	}
+	var good []*snapshot.Hash
+	for _, revision := range revisions[1:] {
+		h, err := resolveSnapshot(ctx, s, revision)
+		if err != nil {
+			return 1, fmt.Errorf("failure resolving the snapshot hash for %q: %v", revision, err)
+		}
+		good = append(good, h)
+	}
+
+	scratch := *bisectScratchFlag
+	if len(scratch) == 0 {
+		tmpDir, err := os.MkdirTemp("", "rvcs-bisect-")
+		if err != nil {
+			return 1, fmt.Errorf("failure creating a scratch directory: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		scratch = filepath.Join(tmpDir, "checkout")
+	}
+	abs, err := filepath.Abs(scratch)
+	if err != nil {
+		return 1, fmt.Errorf("failure resolving the absolute path of %q: %v", scratch, err)
+	}
+	dest := snapshot.Path(abs)
+	// The temporary checkouts should not be remembered once they are removed.
+	temporary := len(*bisectScratchFlag) == 0
+
+	test := func(ctx context.Context, h *snapshot.Hash) (bisect.Result, error) {
+		result, err := runBisectTest(ctx, s, dest, temporary, testCmd, h)
+		if err == nil && !jsonOutput() {
+			fmt.Printf("%s: %s\n", h, result)
+		}
+		return result, err
+	}
+	outcome, err := bisect.Bisect(ctx, s, good, bad, test)
+	if err != nil {
+		return 1, fmt.Errorf("failure bisecting the history of %q: %v", revisions[0], err)
+	}
+	if jsonOutput() {
+		out := &bisectJSON{
+			FirstBad:   hashString(outcome.FirstBad),
+			Candidates: hashStrings(outcome.Candidates),
+			Steps:      []*bisectStepJSON{},
+		}
+		for _, step := range outcome.Steps {
+			out.Steps = append(out.Steps, &bisectStepJSON{
+				Hash:   step.Hash.String(),
+				Result: step.Result.String(),
+			})
+		}
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	if outcome.FirstBad != nil {
+		fmt.Printf("%s is the first bad snapshot\n", outcome.FirstBad)
+		return 0, nil
+	}
+	fmt.Println("The first bad snapshot could not be determined because some snapshots were skipped. It is one of:")
+	for _, h := range outcome.Candidates {
+		fmt.Printf("\t%s\n", h)
+	}
+	return 0, nil
+}
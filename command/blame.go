@@ -0,0 +1,209 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command defines the command line interface for rvcs
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/google/recursive-version-control-system/log"
+	"github.com/google/recursive-version-control-system/merge"
+	"github.com/google/recursive-version-control-system/publish"
+	"github.com/google/recursive-version-control-system/revision"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const blameUsage = `Usage: %s blame [<FLAGS>]* <SOURCE>[:<SUBPATH>]
+
+Where <SUBPATH> is an optional path relative to the root of <SOURCE>, and <SOURCE> is one of:
+
+	The hash of a known snapshot.
+	A local file path which has previously been snapshotted.
+	An identity for which a snapshot has already been published.
+	Any of the above followed by revision suffixes such as ^1, ~2, @{1}, or :<SUBPATH>.
+
+The referenced snapshot must be of a regular text file. Each line of it is
+printed along with the snapshot in its history that introduced that line.
+
+When an identity is given, each line also includes the oldest recorded
+signature by that identity covering the snapshot which introduced the line.
+If the source has a <SUBPATH>, then the nested file at that same subpath of
+each signed snapshot is checked.
+
+`
+
+var (
+	blameFlags = flag.NewFlagSet("blame", flag.ContinueOnError)
+
+	blameIdentityFlag = blameFlags.String(
+		"identity", "",
+		"identity whose signatures should be reported for each line")
+	blameDepthFlag = blameFlags.Int(
+		"depth", -1,
+		"maximum depth of the history to traverse. If less than 0, then there is no limit.")
+)
+
+// signatureCoverage records the signed snapshots of an identity, oldest first.
+type signatureCoverage struct {
+	signatures []*snapshot.Hash
+	signed     []*snapshot.Hash
+}
+
+// readSignatureCoverage reads every recorded signature for the given identity,
+// along with the nested file at `subpath` of each signed snapshot.
+func readSignatureCoverage(ctx context.Context, s *storage.LocalFiles, id *snapshot.Identity, subpath snapshot.Path) (*signatureCoverage, error) {
+	history, err := s.IdentityHistory(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the signature history of %q: %v", id, err)
+	}
+	if len(history) == 0 {
+		// The identity may have been signed before history was recorded.
+		latest, err := s.LatestSignatureForIdentity(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading the latest signature of %q: %v", id, err)
+		}
+		if latest != nil {
+			history = append(history, &storage.HistoryEntry{Hash: latest})
+		}
+	}
+	result := &signatureCoverage{}
+	for i := len(history) - 1; i >= 0; i-- {
+		signature := history[i].Hash
+		signed, err := publish.Verify(ctx, s, id, signature)
+		if err != nil {
+			return nil, fmt.Errorf("failure verifying the signature %q for %q: %v", signature, id, err)
+		}
+		if signed != nil && len(subpath) > 0 {
+			signed, _, err = s.ReadSubpath(ctx, signed, subpath)
+			if err != nil {
+				return nil, fmt.Errorf("failure reading the subpath %q of the signed snapshot %q: %v", subpath, signature, err)
+			}
+		}
+		if signed == nil {
+			// Either nothing was signed, or the subpath does not
+			// exist in the signed snapshot.
+			continue
+		}
+		result.signatures = append(result.signatures, signature)
+		result.signed = append(result.signed, signed)
+	}
+	return result, nil
+}
+
+// firstSignature returns the oldest signature that covers the given snapshot,
+// or nil if there is none.
+func (c *signatureCoverage) firstSignature(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash) (*snapshot.Hash, error) {
+	for i, signed := range c.signed {
+		covered, err := merge.IsAncestor(ctx, s, h, signed)
+		if err != nil {
+			return nil, fmt.Errorf("failure checking if %q is covered by the signature %q: %v", h, c.signatures[i], err)
+		}
+		if covered {
+			return c.signatures[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func blameCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
+	blameFlags.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), blameUsage, cmd)
+		blameFlags.PrintDefaults()
+	}
+	if err := blameFlags.Parse(args); err != nil {
+		return 1, nil
+	}
+	args = blameFlags.Args()
+	if len(args) != 1 {
+		blameFlags.Usage()
+		return 1, nil
+	}
+	h, f, err := resolveSnapshotFile(ctx, s, args[0])
+	if err != nil {
+		return 1, fmt.Errorf("failure resolving the snapshot for %q: %v", args[0], err)
+	}
+	if f.IsDir() {
+		return 1, fmt.Errorf("%q is the snapshot of a directory; use `log` to see its history", h)
+	}
+	lines, err := log.Blame(ctx, s, h, *blameDepthFlag)
+	if err != nil {
+		return 1, fmt.Errorf("failure blaming %q: %v", args[0], err)
+	}
+	signatures := make(map[snapshot.Hash]*snapshot.Hash)
+	var id *snapshot.Identity
+	if len(*blameIdentityFlag) > 0 {
+		id, err = snapshot.ParseIdentity(*blameIdentityFlag)
+		if err != nil {
+			return 1, fmt.Errorf("failure parsing the identity %q: %v", *blameIdentityFlag, err)
+		}
+		var subpath snapshot.Path
+		if expr, err := revision.Parse(args[0]); err == nil && expr.HasSubpath {
+			subpath = expr.Subpath
+		}
+		coverage, err := readSignatureCoverage(ctx, s, id, subpath)
+		if err != nil {
+			return 1, err
+		}
+		for _, line := range lines {
+			introduced := line.Entry.Hash
+			if _, ok := signatures[*introduced]; ok {
+				continue
+			}
+			signature, err := coverage.firstSignature(ctx, s, introduced)
+			if err != nil {
+				return 1, err
+			}
+			signatures[*introduced] = signature
+		}
+	}
+	if jsonOutput() {
+		out := &blameJSON{Hash: h.String(), Lines: []*blameLineJSON{}}
+		for i, line := range lines {
+			lineJSON := &blameLineJSON{
+				Line:         i + 1,
+				Snapshot:     line.Entry.Hash.String(),
+				OriginalLine: line.OriginalLine,
+				Annotation:   newAnnotationJSON(line.Entry.Annotation),
+				Text:         line.Text,
+			}
+			if signature := signatures[*line.Entry.Hash]; signature != nil {
+				lineJSON.Identity = id.String()
+				lineJSON.Signature = signature.String()
+			}
+			out.Lines = append(out.Lines, lineJSON)
+		}
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	width := len(fmt.Sprint(len(lines)))
+	for i, line := range lines {
+		prefix := line.Entry.Hash.String()
+		if id != nil {
+			if signature := signatures[*line.Entry.Hash]; signature != nil {
+				prefix = fmt.Sprintf("%s (%s %s)", prefix, id, signature)
+			} else {
+				prefix = fmt.Sprintf("%s (unsigned)", prefix)
+			}
+		}
+		fmt.Printf("%s %*d) %s\n", prefix, width, i+1, strings.TrimSuffix(line.Text, "\n"))
+	}
+	return 0, nil
+}
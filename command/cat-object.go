@@ -0,0 +1,54 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command defines the command line interface for rvcs
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const catObjectUsage = `Usage: %s cat-object <HASH>
+
+Where <HASH> is the hash of a stored object, such as a snapshot or the contents of a file.
+
+The raw contents of the object are written to standard output, regardless of the output format.
+`
+
+func catObjectCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
+	if len(args) != 1 {
+		fmt.Fprintf(flag.CommandLine.Output(), catObjectUsage, cmd)
+		return 1, nil
+	}
+	h, err := snapshot.ParseHash(args[0])
+	if err != nil || h == nil {
+		return 1, fmt.Errorf("failure parsing the hash %q: %v", args[0], err)
+	}
+	r, err := s.ReadObject(ctx, h)
+	if err != nil {
+		return 1, fmt.Errorf("failure opening the object %q: %v", h, err)
+	}
+	defer r.Close()
+	if _, err := io.Copy(os.Stdout, r); err != nil {
+		return 1, fmt.Errorf("failure writing the contents of the object %q: %v", h, err)
+	}
+	return 0, nil
+}
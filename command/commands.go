@@ -22,9 +22,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/recursive-version-control-system/config"
 	"github.com/google/recursive-version-control-system/publish"
+	"github.com/google/recursive-version-control-system/revision"
 	"github.com/google/recursive-version-control-system/snapshot"
 	"github.com/google/recursive-version-control-system/storage"
 )
@@ -34,26 +36,44 @@ type command func(context.Context, *storage.LocalFiles, string, []string) (int,
 var (
 	commandMap = map[string]command{
 		"add-mirror":    addMirrorCommand,
+		"bisect":        bisectCommand,
+		"blame":         blameCommand,
+		"cat":           catCommand,
+		"cat-object":    catObjectCommand,
+		"conflicts":     conflictsCommand,
+		"diff":          diffCommand,
 		"export":        exportCommand,
+		"grep":          grepCommand,
 		"import":        importCommand,
 		"log":           logCommand,
+		"ls-tree":       lsTreeCommand,
 		"merge":         mergeCommand,
 		"publish":       publishCommand,
 		"remove-mirror": removeMirrorCommand,
+		"show":          showCommand,
 		"snapshot":      snapshotCommand,
 	}
 
-	usage = `Usage: %s <SUBCOMMAND>
+	usage = `Usage: %s [--format=<FORMAT>] <SUBCOMMAND>
 
-Where <SUBCOMMAND> is one of:
+Where <FORMAT> is either "text" (the default) or "json", and <SUBCOMMAND> is one of:
 
 	add-mirror
+	bisect
+	blame
+	cat
+	cat-object
+	conflicts
+	diff
 	export
+	grep
 	import
 	log
+	ls-tree
 	merge
 	publish
 	remove-mirror
+	show
 	snapshot
 `
 )
@@ -70,27 +90,121 @@ func resolveIdentitySnapshot(ctx context.Context, s *storage.LocalFiles, id *sna
 	return signature, signed, nil
 }
 
-func resolveSnapshot(ctx context.Context, s *storage.LocalFiles, name string) (*snapshot.Hash, error) {
+// referenceResolver implements the `revision.Resolver` interface for
+// hashes, identities, and local paths.
+type referenceResolver struct {
+	s *storage.LocalFiles
+}
+
+func (r *referenceResolver) Current(ctx context.Context, name string) (*snapshot.Hash, error) {
 	h, err := snapshot.ParseHash(name)
 	if err == nil {
 		return h, nil
 	}
 	id, err := snapshot.ParseIdentity(name)
 	if err == nil {
-		_, signed, err := resolveIdentitySnapshot(ctx, s, id)
+		_, signed, err := resolveIdentitySnapshot(ctx, r.s, id)
 		return signed, err
 	}
 	abs, err := filepath.Abs(name)
 	if err != nil {
 		return nil, fmt.Errorf("failure resolving the absolute path of %q: %v", name, err)
 	}
-	h, _, err = s.FindSnapshot(ctx, snapshot.Path(abs))
+	h, _, err = r.s.FindSnapshot(ctx, snapshot.Path(abs))
 	if err == nil {
 		return h, nil
 	}
 	return nil, fmt.Errorf("unable to resolve the hash corresponding to %q", name)
 }
 
+func (r *referenceResolver) fromHistory(ctx context.Context, name string, n int, t time.Time) (*snapshot.Hash, error) {
+	if _, err := snapshot.ParseHash(name); err == nil {
+		return nil, fmt.Errorf("history is only available for paths and identities, not the hash %q", name)
+	}
+	if id, err := snapshot.ParseIdentity(name); err == nil {
+		history, err := r.s.IdentityHistory(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		signature, err := revision.FromHistory(history, n, t)
+		if err != nil {
+			return nil, fmt.Errorf("failure looking up the history of %q: %v", id, err)
+		}
+		signed, err := publish.Verify(ctx, r.s, id, signature)
+		if err != nil {
+			return nil, fmt.Errorf("failure verifying the signature %q for %q: %v", signature, id, err)
+		}
+		return signed, nil
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, fmt.Errorf("failure resolving the absolute path of %q: %v", name, err)
+	}
+	history, err := r.s.PathHistory(ctx, snapshot.Path(abs))
+	if err != nil {
+		return nil, err
+	}
+	h, err := revision.FromHistory(history, n, t)
+	if err != nil {
+		return nil, fmt.Errorf("failure looking up the history of %q: %v", abs, err)
+	}
+	return h, nil
+}
+
+func (r *referenceResolver) Previous(ctx context.Context, name string, n int) (*snapshot.Hash, error) {
+	if n == 0 {
+		return r.Current(ctx, name)
+	}
+	return r.fromHistory(ctx, name, n, time.Time{})
+}
+
+func (r *referenceResolver) AsOf(ctx context.Context, name string, t time.Time) (*snapshot.Hash, error) {
+	return r.fromHistory(ctx, name, -1, t)
+}
+
+// resolveSnapshot resolves the given revision expression to the hash of a snapshot.
+//
+// See the `revision` package for a description of the supported expressions.
+func resolveSnapshot(ctx context.Context, s *storage.LocalFiles, name string) (*snapshot.Hash, error) {
+	if len(name) == 0 {
+		return nil, nil
+	}
+	r := &referenceResolver{s: s}
+	expr, err := revision.Parse(name)
+	if err != nil || expr.IsSimple() {
+		return r.Current(ctx, name)
+	}
+	// The name might be a path that merely looks like an expression, in
+	// which case the path takes precedence.
+	if abs, err := filepath.Abs(name); err == nil {
+		if literal, _, err := s.FindSnapshot(ctx, snapshot.Path(abs)); err == nil {
+			return literal, nil
+		}
+	}
+	h, err := expr.Resolve(ctx, s, r)
+	if err != nil {
+		return nil, fmt.Errorf("failure resolving the expression %q: %v", name, err)
+	}
+	return h, nil
+}
+
+// resolveSnapshotFile resolves the given revision expression to a snapshot
+// and reads the corresponding `snapshot.File`.
+func resolveSnapshotFile(ctx context.Context, s *storage.LocalFiles, name string) (*snapshot.Hash, *snapshot.File, error) {
+	h, err := resolveSnapshot(ctx, s, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h == nil {
+		return nil, nil, fmt.Errorf("unable to resolve the hash corresponding to %q", name)
+	}
+	f, err := s.ReadSnapshot(ctx, h)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure reading the snapshot %q: %v", h, err)
+	}
+	return h, f, nil
+}
+
 func readHashesFromFile(ctx context.Context, path string) ([]*snapshot.Hash, error) {
 	if path == "" {
 		return nil, nil
@@ -144,19 +258,40 @@ func hashesFromFileAndFlag(ctx context.Context, fromFile, fromFlag string) ([]*s
 // The returned value is the exit code of the command; 0 for success
 // and non-zero for any form of failure.
 func Run(ctx context.Context, s *storage.LocalFiles, args []string) (exitCode int) {
-	if len(args) < 2 {
+	globalFlags.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, args[0])
+	}
+	if err := globalFlags.Parse(args[1:]); err != nil {
+		return 1
+	}
+	if *formatFlag != textFormat && *formatFlag != jsonFormat {
+		fmt.Fprintf(flag.CommandLine.Output(), "Unsupported output format %q\n", *formatFlag)
 		fmt.Fprintf(flag.CommandLine.Output(), usage, args[0])
 		return 1
 	}
-	subcommand, ok := commandMap[args[1]]
+	cmdArgs := globalFlags.Args()
+	if len(cmdArgs) < 1 {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, args[0])
+		return 1
+	}
+	subcommand, ok := commandMap[cmdArgs[0]]
 	if !ok {
-		fmt.Fprintf(flag.CommandLine.Output(), "Unknown subcommand %q\n", args[1])
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown subcommand %q\n", cmdArgs[0])
 		fmt.Fprintf(flag.CommandLine.Output(), usage, args[0])
 		return 1
 	}
-	retcode, err := subcommand(ctx, s, args[0], args[2:])
+	retcode, err := subcommand(ctx, s, args[0], cmdArgs[1:])
 	if err != nil {
-		fmt.Fprintf(flag.CommandLine.Output(), "Failure running the %q subcommand: %v\n", args[1], err)
+		fmt.Fprintf(flag.CommandLine.Output(), "Failure running the %q subcommand: %v\n", cmdArgs[0], err)
+	}
+	if retcode != 0 && jsonOutput() && !wroteJSON {
+		// Scripts only need to parse stdout, so failures are reported
+		// there too, including usage errors that have no underlying error.
+		msg := fmt.Sprintf("invalid usage of the %q subcommand", cmdArgs[0])
+		if err != nil {
+			msg = err.Error()
+		}
+		writeJSON(&errorJSON{Command: cmdArgs[0], Error: msg})
 	}
 	return retcode
 }
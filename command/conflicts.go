@@ -0,0 +1,79 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command defines the command line interface for rvcs
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/google/recursive-version-control-system/merge"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const conflictsUsage = `Usage: %s conflicts <DESTINATION>
+
+Where <DESTINATION> is a local file path with an in-progress merge.
+
+Lists the conflicts of the in-progress merge that have not been resolved yet.
+`
+
+func conflictsCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
+	if len(args) != 1 {
+		fmt.Fprintf(flag.CommandLine.Output(), conflictsUsage, cmd)
+		return 1, nil
+	}
+	abs, err := filepath.Abs(args[0])
+	if err != nil {
+		return 1, fmt.Errorf("failure determining the absolute path of %q: %v", args[0], err)
+	}
+	dest := snapshot.Path(abs)
+	state, err := s.ReadMergeState(ctx, dest)
+	if err != nil {
+		return 1, err
+	}
+	if state == nil {
+		return 1, fmt.Errorf("there is no merge in progress for %q", abs)
+	}
+	out := &conflictsJSON{
+		Path:        abs,
+		Base:        hashString(state.Base),
+		Source:      state.Source.String(),
+		Destination: state.Destination.String(),
+		Conflicts:   []*conflictJSON{},
+	}
+	for _, c := range state.Conflicts {
+		resolved, err := merge.IsResolved(dest, c)
+		if err != nil {
+			return 1, fmt.Errorf("failure checking if the conflict for %q is resolved: %v", c.Path, err)
+		}
+		if jsonOutput() {
+			conflict := newConflictJSON(c)
+			conflict.Resolved = resolved
+			out.Conflicts = append(out.Conflicts, conflict)
+		} else if !resolved {
+			fmt.Println(describeConflict(c))
+		}
+	}
+	if jsonOutput() {
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
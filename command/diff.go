@@ -0,0 +1,160 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command defines the command line interface for rvcs
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/google/recursive-version-control-system/diff"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+	"golang.org/x/term"
+)
+
+const diffUsage = `Usage: %s diff [<FLAGS>]* <SOURCE> [<DESTINATION>]
+
+Where <SOURCE> and <DESTINATION> are each one of:
+
+	The hash of a known snapshot.
+	A local file path which has previously been snapshotted.
+	An identity for which a snapshot has been published.
+	Any of the above followed by revision suffixes such as ^1, ~2, @{1}, or :<SUBPATH>.
+
+If <DESTINATION> is omitted, then <SOURCE> must be a local file path, and
+the latest snapshot of that path is compared against the current contents
+of the working tree at that path. The current contents are not recorded as
+a new snapshot of the path.
+
+<FLAGS> are one of:
+
+`
+
+var (
+	diffFlags = flag.NewFlagSet("diff", flag.ContinueOnError)
+
+	diffStatFlag = diffFlags.Bool(
+		"stat", false,
+		"print a summary of the number of lines changed per file instead of the full diff")
+	diffContextFlag = diffFlags.Int(
+		"U", 3,
+		"number of lines of unchanged context to show around each change")
+	diffColorFlag = diffFlags.String(
+		"color", "auto",
+		"whether or not to color the output; one of `auto`, `always`, or `never`")
+	diffNoRenamesFlag = diffFlags.Bool(
+		"no-renames", false,
+		"do not detect renamed files; report them as a deletion and an addition instead")
+)
+
+func diffWorkingTree(ctx context.Context, s *storage.LocalFiles, path string) (old, new *snapshot.Hash, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure resolving the absolute path of %q: %v", path, err)
+	}
+	old, _, err = s.FindSnapshot(ctx, snapshot.Path(abs))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failure looking up the previous snapshot of %q: %v", abs, err)
+	}
+	new, _, err = snapshot.Peek(ctx, s, snapshot.Path(abs))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure snapshotting the current contents of %q: %v", abs, err)
+	}
+	return old, new, nil
+}
+
+func diffCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
+	diffFlags.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), diffUsage, cmd)
+		diffFlags.PrintDefaults()
+	}
+	if err := diffFlags.Parse(args); err != nil {
+		return 1, nil
+	}
+	args = diffFlags.Args()
+	if len(args) < 1 || len(args) > 2 {
+		diffFlags.Usage()
+		return 1, nil
+	}
+	opts := &diff.Options{Context: *diffContextFlag}
+	switch *diffColorFlag {
+	case "always":
+		opts.Color = true
+	case "never":
+		opts.Color = false
+	case "auto":
+		opts.Color = term.IsTerminal(syscall.Stdout)
+	default:
+		return 1, fmt.Errorf("unsupported value for the color flag: %q", *diffColorFlag)
+	}
+
+	var old, new *snapshot.Hash
+	var err error
+	if len(args) == 1 {
+		old, new, err = diffWorkingTree(ctx, s, args[0])
+		if err != nil {
+			return 1, err
+		}
+	} else {
+		old, err = resolveSnapshot(ctx, s, args[0])
+		if err != nil {
+			return 1, fmt.Errorf("failure resolving the snapshot hash for %q: %v", args[0], err)
+		}
+		new, err = resolveSnapshot(ctx, s, args[1])
+		if err != nil {
+			return 1, fmt.Errorf("failure resolving the snapshot hash for %q: %v", args[1], err)
+		}
+	}
+	changes, err := diff.Snapshots(ctx, s, old, new, !*diffNoRenamesFlag)
+	if err != nil {
+		return 1, fmt.Errorf("failure comparing %q and %q: %v", old, new, err)
+	}
+	if jsonOutput() {
+		return writeDiffJSON(ctx, s, old, new, changes)
+	}
+	if *diffStatFlag {
+		err = diff.WriteStat(ctx, s, os.Stdout, changes, opts)
+	} else {
+		err = diff.Write(ctx, s, os.Stdout, changes, opts)
+	}
+	if err != nil {
+		return 1, fmt.Errorf("failure writing the diff of %q and %q: %v", old, new, err)
+	}
+	return 0, nil
+}
+
+func writeDiffJSON(ctx context.Context, s *storage.LocalFiles, old, new *snapshot.Hash, changes []*diff.Change) (int, error) {
+	out := &diffJSON{
+		Old:     hashString(old),
+		New:     hashString(new),
+		Changes: []*diffChangeJSON{},
+	}
+	for _, c := range changes {
+		_, stat, err := diff.Compare(ctx, s, c)
+		if err != nil {
+			return 1, fmt.Errorf("failure comparing %q and %q: %v", c.OldPath, c.NewPath, err)
+		}
+		out.Changes = append(out.Changes, newDiffChangeJSON(c, stat))
+	}
+	if err := writeJSON(out); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
@@ -130,10 +130,18 @@ func exportCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args
 		return 1, fmt.Errorf("failure resolving the absolute path of %q: %v", args[0], err)
 	}
 
-	included, err := bundle.Export(ctx, s, path, snapshots, exclude, metadata, *exportIncludeParentsFlag)
+	progressCtx, done := withProgress(ctx, "Exporting")
+	included, err := bundle.Export(progressCtx, s, path, snapshots, exclude, metadata, *exportIncludeParentsFlag)
+	done()
 	if err != nil {
 		return 1, fmt.Errorf("failure creating the bundle: %v\n", err)
 	}
+	if jsonOutput() {
+		if err := writeJSON(&bundleJSON{Bundle: path, Included: hashStrings(included)}); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
 	if *exportVerboseFlag {
 		for _, h := range included {
 			fmt.Println(h.String())
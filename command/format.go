@@ -0,0 +1,357 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command defines the command line interface for rvcs
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/recursive-version-control-system/diff"
+	"github.com/google/recursive-version-control-system/log"
+	"github.com/google/recursive-version-control-system/snapshot"
+)
+
+const (
+	textFormat = "text"
+	jsonFormat = "json"
+)
+
+var (
+	globalFlags = flag.NewFlagSet("rvcs", flag.ContinueOnError)
+
+	formatFlag = globalFlags.String(
+		"format", textFormat,
+		"output format; either `text` for human readable output, or `json` for machine readable output")
+
+	// wroteJSON records whether or not the command has already written
+	// its JSON output, which is then the only document written even if
+	// the command fails (e.g. a merge that had conflicts).
+	wroteJSON bool
+)
+
+// jsonOutput reports whether or not commands should write their output as JSON.
+func jsonOutput() bool {
+	return *formatFlag == jsonFormat
+}
+
+// writeJSON writes the given value to stdout as a single, indented JSON document.
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("failure writing the JSON output: %v", err)
+	}
+	wroteJSON = true
+	return nil
+}
+
+func hashString(h *snapshot.Hash) string {
+	if h == nil {
+		return ""
+	}
+	return h.String()
+}
+
+func hashStrings(hashes []*snapshot.Hash) []string {
+	result := []string{}
+	for _, h := range hashes {
+		result = append(result, h.String())
+	}
+	return result
+}
+
+// The types below define the JSON output of each command.
+//
+// These are part of the documented interface of the CLI, so fields
+// may be added to them but existing fields must not be renamed or removed.
+
+type errorJSON struct {
+	Command string `json:"command"`
+	Error   string `json:"error"`
+}
+
+type snapshotJSON struct {
+	Hash      string          `json:"hash,omitempty"`
+	Path      string          `json:"path"`
+	Ancestors []*snapshotJSON `json:"ancestors,omitempty"`
+}
+
+type annotationJSON struct {
+	Author  string `json:"author,omitempty"`
+	Time    string `json:"time,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func newAnnotationJSON(a *snapshot.Annotation) *annotationJSON {
+	if a == nil {
+		return nil
+	}
+	result := &annotationJSON{
+		Author:  a.Author,
+		Message: a.Message,
+	}
+	if !a.Time.IsZero() {
+		result.Time = a.Time.UTC().Format(time.RFC3339)
+	}
+	return result
+}
+
+type pathChangeJSON struct {
+	Path string `json:"path"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+type fileJSON struct {
+	Hash     string   `json:"hash"`
+	Mode     string   `json:"mode"`
+	Contents string   `json:"contents,omitempty"`
+	Parents  []string `json:"parents"`
+}
+
+func newFileJSON(h *snapshot.Hash, f *snapshot.File) *fileJSON {
+	return &fileJSON{
+		Hash:     h.String(),
+		Mode:     f.Mode,
+		Contents: hashString(f.Contents),
+		Parents:  hashStrings(f.Parents),
+	}
+}
+
+type treeEntryJSON struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Hash string `json:"hash"`
+}
+
+type treeJSON struct {
+	Hash    string           `json:"hash"`
+	Entries []*treeEntryJSON `json:"entries"`
+}
+
+type logEntryJSON struct {
+	*fileJSON
+	Annotation *annotationJSON   `json:"annotation,omitempty"`
+	Changes    []*pathChangeJSON `json:"changes,omitempty"`
+	Labels     []string          `json:"labels,omitempty"`
+	Stats      []*parentStatJSON `json:"stats,omitempty"`
+}
+
+type fileStatJSON struct {
+	Path       string `json:"path"`
+	Insertions int    `json:"insertions"`
+	Deletions  int    `json:"deletions"`
+	Binary     bool   `json:"binary"`
+	Bytes      int64  `json:"bytes"`
+}
+
+type parentStatJSON struct {
+	Parent     string          `json:"parent,omitempty"`
+	Insertions int             `json:"insertions"`
+	Deletions  int             `json:"deletions"`
+	Bytes      int64           `json:"bytes"`
+	Files      []*fileStatJSON `json:"files"`
+}
+
+func newParentStatJSON(p *log.ParentStat) *parentStatJSON {
+	result := &parentStatJSON{
+		Parent:     hashString(p.Parent),
+		Insertions: p.Insertions,
+		Deletions:  p.Deletions,
+		Bytes:      p.ByteDelta,
+		Files:      []*fileStatJSON{},
+	}
+	for _, f := range p.Files {
+		result.Files = append(result.Files, &fileStatJSON{
+			Path:       f.Path,
+			Insertions: f.Insertions,
+			Deletions:  f.Deletions,
+			Binary:     f.Binary,
+			Bytes:      f.ByteDelta,
+		})
+	}
+	return result
+}
+
+func newLogEntryJSON(e *log.LogEntry, changes []*log.PathChange) *logEntryJSON {
+	result := &logEntryJSON{
+		fileJSON:   newFileJSON(e.Hash, e.File),
+		Annotation: newAnnotationJSON(e.Annotation),
+	}
+	for _, c := range changes {
+		result.Changes = append(result.Changes, &pathChangeJSON{
+			Path: c.Path,
+			Old:  hashString(c.Old),
+			New:  hashString(c.New),
+		})
+	}
+	return result
+}
+
+type logJSON struct {
+	Entries []*logEntryJSON `json:"entries"`
+}
+
+type bisectStepJSON struct {
+	Hash   string `json:"hash"`
+	Result string `json:"result"`
+}
+
+type bisectJSON struct {
+	FirstBad   string            `json:"firstBad,omitempty"`
+	Candidates []string          `json:"candidates,omitempty"`
+	Steps      []*bisectStepJSON `json:"steps"`
+}
+
+type blameLineJSON struct {
+	Line         int             `json:"line"`
+	Snapshot     string          `json:"snapshot"`
+	OriginalLine int             `json:"originalLine"`
+	Annotation   *annotationJSON `json:"annotation,omitempty"`
+	Identity     string          `json:"identity,omitempty"`
+	Signature    string          `json:"signature,omitempty"`
+	Text         string          `json:"text"`
+}
+
+type blameJSON struct {
+	Hash  string           `json:"hash"`
+	Lines []*blameLineJSON `json:"lines"`
+}
+
+type grepMatchJSON struct {
+	Snapshot string `json:"snapshot"`
+	Path     string `json:"path"`
+	Line     int    `json:"line,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Binary   bool   `json:"binary,omitempty"`
+}
+
+type grepJSON struct {
+	Matches []*grepMatchJSON `json:"matches"`
+}
+
+type pickaxeChangeJSON struct {
+	Snapshot string `json:"snapshot"`
+	Parent   string `json:"parent,omitempty"`
+	Path     string `json:"path"`
+	Before   int    `json:"before"`
+	After    int    `json:"after"`
+}
+
+type pickaxeJSON struct {
+	Changes []*pickaxeChangeJSON `json:"changes"`
+}
+
+type publishJSON struct {
+	Signature string `json:"signature"`
+	Identity  string `json:"identity"`
+}
+
+type bundleJSON struct {
+	Bundle   string   `json:"bundle"`
+	Included []string `json:"included"`
+}
+
+type conflictJSON struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Sidecar  string `json:"sidecar,omitempty"`
+	Reason   string `json:"reason"`
+	Resolved bool   `json:"resolved"`
+}
+
+type resolutionJSON struct {
+	Path        string `json:"path"`
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+}
+
+type mergeJSON struct {
+	Source      string            `json:"source"`
+	Path        string            `json:"path"`
+	Merged      string            `json:"merged,omitempty"`
+	Conflicts   []*conflictJSON   `json:"conflicts,omitempty"`
+	Resolutions []*resolutionJSON `json:"resolutions,omitempty"`
+}
+
+type mergePreviewChangeJSON struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+type mergePreviewJSON struct {
+	Base        string                    `json:"base,omitempty"`
+	Source      string                    `json:"source"`
+	Destination string                    `json:"destination,omitempty"`
+	Merged      string                    `json:"merged"`
+	Changes     []*mergePreviewChangeJSON `json:"changes"`
+	Conflicts   []*conflictJSON           `json:"conflicts"`
+	Resolutions []*resolutionJSON         `json:"resolutions"`
+}
+
+type conflictsJSON struct {
+	Path        string          `json:"path"`
+	Base        string          `json:"base,omitempty"`
+	Source      string          `json:"source"`
+	Destination string          `json:"destination"`
+	Conflicts   []*conflictJSON `json:"conflicts"`
+}
+
+type mirrorJSON struct {
+	Identity string `json:"identity,omitempty"`
+	URL      string `json:"url"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+}
+
+type diffChangeJSON struct {
+	OldPath    string `json:"oldPath"`
+	NewPath    string `json:"newPath"`
+	Old        string `json:"old,omitempty"`
+	New        string `json:"new,omitempty"`
+	OldMode    string `json:"oldMode,omitempty"`
+	NewMode    string `json:"newMode,omitempty"`
+	Insertions int    `json:"insertions"`
+	Deletions  int    `json:"deletions"`
+	Binary     bool   `json:"binary,omitempty"`
+}
+
+func newDiffChangeJSON(c *diff.Change, stat *diff.Stat) *diffChangeJSON {
+	result := &diffChangeJSON{
+		OldPath:    string(c.OldPath),
+		NewPath:    string(c.NewPath),
+		Old:        hashString(c.Old),
+		New:        hashString(c.New),
+		Insertions: stat.Insertions,
+		Deletions:  stat.Deletions,
+		Binary:     stat.Binary,
+	}
+	if c.OldFile != nil {
+		result.OldMode = c.OldFile.Mode
+	}
+	if c.NewFile != nil {
+		result.NewMode = c.NewFile.Mode
+	}
+	return result
+}
+
+type diffJSON struct {
+	Old     string            `json:"old,omitempty"`
+	New     string            `json:"new,omitempty"`
+	Changes []*diffChangeJSON `json:"changes"`
+}
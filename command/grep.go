@@ -0,0 +1,190 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command defines the command line interface for rvcs
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"regexp"
+
+	"github.com/google/recursive-version-control-system/log"
+	"github.com/google/recursive-version-control-system/search"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const grepUsage = `Usage: %s grep [<FLAGS>]* <PATTERN> <SOURCE>
+
+Where <PATTERN> is a regular expression, and <SOURCE> is one of:
+
+	The hash of a known snapshot.
+	A local file path which has previously been snapshotted.
+	An identity for which a snapshot has already been published.
+	Any of the above followed by revision suffixes such as ^1, ~2, @{1}, or :<SUBPATH>.
+
+Every line matching <PATTERN> in the files of <SOURCE> is printed. With
+--history, the same is done for every snapshot in the history of <SOURCE>.
+
+With --pickaxe, only the snapshots in the history of <SOURCE> in which the
+number of occurrences of <PATTERN> changed are printed, along with the files
+in which it changed.
+
+`
+
+var (
+	grepFlags = flag.NewFlagSet("grep", flag.ContinueOnError)
+
+	grepIgnoreCase bool
+	grepFixed      bool
+	grepHistory    = grepFlags.Bool(
+		"history", false,
+		"search every snapshot in the history of <SOURCE> rather than just <SOURCE> itself")
+	grepPickaxe = grepFlags.Bool(
+		"pickaxe", false,
+		"list the snapshots in the history of <SOURCE> that changed the number of occurrences of <PATTERN>. Merge snapshots are only listed if they differ from all of their parents.")
+	grepDepthFlag = grepFlags.Int(
+		"depth", -1,
+		"maximum depth of the history to search. If less than 0, then there is no limit.")
+)
+
+func init() {
+	grepFlags.BoolVar(&grepIgnoreCase, "ignore-case", false,
+		"match the pattern without regard to case")
+	grepFlags.BoolVar(&grepIgnoreCase, "i", false,
+		"match the pattern without regard to case")
+	grepFlags.BoolVar(&grepFixed, "fixed-strings", false,
+		"treat the pattern as a literal string rather than a regular expression")
+	grepFlags.BoolVar(&grepFixed, "F", false,
+		"treat the pattern as a literal string rather than a regular expression")
+}
+
+func grepCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
+	grepFlags.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), grepUsage, cmd)
+		grepFlags.PrintDefaults()
+	}
+	if err := grepFlags.Parse(args); err != nil {
+		return 1, nil
+	}
+	args = grepFlags.Args()
+	if len(args) != 2 {
+		grepFlags.Usage()
+		return 1, nil
+	}
+	expr := args[0]
+	if grepFixed {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if grepIgnoreCase {
+		expr = "(?i)" + expr
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return 1, fmt.Errorf("failure parsing the pattern %q: %v", args[0], err)
+	}
+	h, err := resolveSnapshot(ctx, s, args[1])
+	if err != nil {
+		return 1, fmt.Errorf("failure resolving the snapshot hash for %q: %v", args[1], err)
+	}
+	if h == nil {
+		return 1, fmt.Errorf("unable to resolve the hash corresponding to %q", args[1])
+	}
+	sr := search.NewSearcher(s, pattern)
+	if *grepPickaxe {
+		return grepPickaxeHistory(ctx, s, sr, h)
+	}
+	snapshots := []*snapshot.Hash{h}
+	if *grepHistory {
+		snapshots, err = log.ReadAncestors(ctx, s, h, *grepDepthFlag)
+		if err != nil {
+			return 1, fmt.Errorf("failure reading the history of %q: %v", args[1], err)
+		}
+	}
+	out := &grepJSON{Matches: []*grepMatchJSON{}}
+	for _, snapshotHash := range snapshots {
+		matches, err := sr.Search(ctx, snapshotHash)
+		if err != nil {
+			return 1, fmt.Errorf("failure searching %q: %v", snapshotHash, err)
+		}
+		for _, m := range matches {
+			if jsonOutput() {
+				out.Matches = append(out.Matches, &grepMatchJSON{
+					Snapshot: snapshotHash.String(),
+					Path:     m.Path,
+					Line:     m.Line,
+					Text:     m.Text,
+					Binary:   m.Binary,
+				})
+				continue
+			}
+			prefix := m.Path
+			if *grepHistory {
+				prefix = fmt.Sprintf("%s:%s", snapshotHash, m.Path)
+			}
+			if m.Binary {
+				fmt.Printf("Binary file %s matches\n", prefix)
+			} else {
+				fmt.Printf("%s:%d:%s\n", prefix, m.Line, m.Text)
+			}
+		}
+	}
+	if jsonOutput() {
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+func grepPickaxeHistory(ctx context.Context, s *storage.LocalFiles, sr *search.Searcher, h *snapshot.Hash) (int, error) {
+	entries, err := log.ReadLog(ctx, s, h, *grepDepthFlag)
+	if err != nil {
+		return 1, fmt.Errorf("failure reading the log for %q: %v", h, err)
+	}
+	pickaxe, err := sr.Pickaxe(ctx, entries)
+	if err != nil {
+		return 1, fmt.Errorf("failure searching the history of %q: %v", h, err)
+	}
+	if jsonOutput() {
+		out := &pickaxeJSON{Changes: []*pickaxeChangeJSON{}}
+		for _, e := range pickaxe {
+			for _, c := range e.Changes {
+				out.Changes = append(out.Changes, &pickaxeChangeJSON{
+					Snapshot: e.Hash.String(),
+					Parent:   hashString(e.Parent),
+					Path:     c.Path,
+					Before:   c.Before,
+					After:    c.After,
+				})
+			}
+		}
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	for i, e := range pickaxe {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(e.Hash)
+		for _, c := range e.Changes {
+			fmt.Printf("    %s | %d -> %d\n", c.Path, c.Before, c.After)
+		}
+	}
+	return 0, nil
+}
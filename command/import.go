@@ -70,10 +70,18 @@ func importCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args
 		return 1, fmt.Errorf("failure resolving the absolute path of %q: %v", args[0], err)
 	}
 
-	included, err := bundle.Import(ctx, s, path, exclude)
+	progressCtx, done := withProgress(ctx, "Importing")
+	included, err := bundle.Import(progressCtx, s, path, exclude)
+	done()
 	if err != nil {
 		return 1, fmt.Errorf("failure importing the bundle: %v\n", err)
 	}
+	if jsonOutput() {
+		if err := writeJSON(&bundleJSON{Bundle: path, Included: hashStrings(included)}); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
 	if *importVerboseFlag {
 		for _, h := range included {
 			fmt.Println(h.String())
@@ -19,17 +19,28 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"path/filepath"
 
+	"github.com/google/recursive-version-control-system/config"
 	"github.com/google/recursive-version-control-system/log"
+	"github.com/google/recursive-version-control-system/publish"
+	"github.com/google/recursive-version-control-system/snapshot"
 	"github.com/google/recursive-version-control-system/storage"
 )
 
 const logUsage = `Usage: %s log [<FLAGS>]* <SOURCE>
+	%s log --graph [<FLAGS>]* <SOURCE>+
+	%s log [--follow] [<FLAGS>]* <SOURCE> -- <SUBPATH>
 
 Where <SOURCE> is one of:
 
 	The hash of a known snapshot.
 	A local file path which has previously been snapshotted.
+	Any of the above followed by revision suffixes such as ^1, ~2, @{1}, or :<SUBPATH>.
+
+When a <SUBPATH> is given, <SOURCE> must be a directory snapshot, and the
+log only includes the snapshots of that directory in which the file nested
+at <SUBPATH> changed.
 
 `
 
@@ -37,6 +48,15 @@ var (
 	logFlags = flag.NewFlagSet("log", flag.ContinueOnError)
 
 	logShort     bool
+	logGraphFlag = logFlags.Bool(
+		"graph", false,
+		"draw the history as a graph of parent links, labelling each snapshot with the sources and identities that point at it. Multiple sources may be given, in which case their histories are drawn together.")
+	logStatFlag = logFlags.Bool(
+		"stat", false,
+		"instead of listing the changed files, list the number of lines added and removed for each changed text file, the change in size for each changed binary file, and the totals of those. Merge snapshots are compared against each of their parents.")
+	logFollowFlag = logFlags.Bool(
+		"follow", false,
+		"when logging a <SUBPATH>, continue the history of the nested file across renames, detected by identical contents.")
 	logDepthFlag = logFlags.Int(
 		"depth", -1,
 		"maximum depth of the history to traverse. If less than 0, then there is no limit.")
@@ -51,16 +71,28 @@ func init() {
 
 func logCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
 	logFlags.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), logUsage, cmd)
+		fmt.Fprintf(flag.CommandLine.Output(), logUsage, cmd, cmd, cmd)
 		logFlags.PrintDefaults()
 	}
 	if err := logFlags.Parse(args); err != nil {
 		return 1, nil
 	}
 	args = logFlags.Args()
+	if *logGraphFlag {
+		if len(args) < 1 {
+			logFlags.Usage()
+			return 1, nil
+		}
+		return logGraph(ctx, s, args)
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 3 && args[1] == "--" {
+		return logSubpath(ctx, s, args[0], snapshot.Path(args[2]))
+	}
 	if len(args) != 1 {
-		fmt.Fprintf(flag.CommandLine.Output(), logUsage, cmd)
-		logFlags.PrintDefaults()
+		logFlags.Usage()
 		return 1, nil
 	}
 	h, err := resolveSnapshot(ctx, s, args[0])
@@ -71,13 +103,16 @@ func logCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []s
 	if err != nil {
 		return 1, fmt.Errorf("failure reading the log for %q: %v", args[0], err)
 	}
+	if jsonOutput() {
+		return writeLogJSON(ctx, s, entries)
+	}
 	if logShort {
 		for _, e := range entries {
 			fmt.Println(e.Hash)
 		}
 		return 0, nil
 	}
-	summaries, err := log.SummarizeLog(ctx, s, entries)
+	summaries, err := log.SummarizeLog(ctx, s, entries, &log.SummaryOptions{Stats: *logStatFlag})
 	if err != nil {
 		return 1, fmt.Errorf("failure summarizing log entries for %q: %v", args[0], err)
 	}
@@ -96,3 +131,178 @@ func logCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []s
 	}
 	return 0, nil
 }
+
+func writeLogJSON(ctx context.Context, s *storage.LocalFiles, entries []*log.LogEntry) (int, error) {
+	var changes map[snapshot.Hash][]*log.PathChange
+	if !logShort {
+		var err error
+		changes, err = log.LogChanges(ctx, s, entries)
+		if err != nil {
+			return 1, fmt.Errorf("failure computing the changes in the log entries: %v", err)
+		}
+	}
+	var stats map[snapshot.Hash][]*log.ParentStat
+	if *logStatFlag && !logShort {
+		var err error
+		stats, err = log.LogStats(ctx, s, entries)
+		if err != nil {
+			return 1, fmt.Errorf("failure computing the change stats for the log entries: %v", err)
+		}
+	}
+	out := &logJSON{Entries: []*logEntryJSON{}}
+	for _, e := range entries {
+		entry := newLogEntryJSON(e, changes[*e.Hash])
+		for _, p := range stats[*e.Hash] {
+			entry.Stats = append(entry.Stats, newParentStatJSON(p))
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	if err := writeJSON(out); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func logGraph(ctx context.Context, s *storage.LocalFiles, sources []string) (int, error) {
+	var entries []*log.LogEntry
+	labels := make(map[snapshot.Hash][]string)
+	for _, source := range sources {
+		h, err := resolveSnapshot(ctx, s, source)
+		if err != nil {
+			return 1, fmt.Errorf("failure resolving the snapshot hash for %q: %v", source, err)
+		}
+		sourceEntries, err := log.ReadLog(ctx, s, h, *logDepthFlag)
+		if err != nil {
+			return 1, fmt.Errorf("failure reading the log for %q: %v", source, err)
+		}
+		entries = append(entries, sourceEntries...)
+		labels[*h] = append(labels[*h], source)
+	}
+	included := make(map[snapshot.Hash]struct{})
+	for _, e := range entries {
+		included[*e.Hash] = struct{}{}
+	}
+	addGraphLabels(ctx, s, sources, included, labels)
+	if jsonOutput() {
+		out := &logJSON{Entries: []*logEntryJSON{}}
+		for _, line := range log.Graph(entries) {
+			if line.Entry == nil {
+				continue
+			}
+			entry := newLogEntryJSON(line.Entry, nil)
+			entry.Labels = labels[*line.Entry.Hash]
+			out.Entries = append(out.Entries, entry)
+		}
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	for _, line := range log.RenderGraph(entries, labels) {
+		fmt.Println(line)
+	}
+	return 0, nil
+}
+
+// addGraphLabels adds labels for the previous snapshots of any paths in
+// `sources`, and for the latest snapshots signed by any configured identities.
+//
+// Only snapshots in `included` are labelled. This is best-effort, so any
+// errors looking up the labels are ignored.
+func addGraphLabels(ctx context.Context, s *storage.LocalFiles, sources []string, included map[snapshot.Hash]struct{}, labels map[snapshot.Hash][]string) {
+	addLabel := func(h *snapshot.Hash, label string) {
+		if h == nil {
+			return
+		}
+		if _, ok := included[*h]; !ok {
+			return
+		}
+		for _, existing := range labels[*h] {
+			if existing == label {
+				return
+			}
+		}
+		labels[*h] = append(labels[*h], label)
+	}
+	for _, source := range sources {
+		if _, err := snapshot.ParseHash(source); err == nil {
+			continue
+		}
+		if _, err := snapshot.ParseIdentity(source); err == nil {
+			continue
+		}
+		abs, err := filepath.Abs(source)
+		if err != nil {
+			continue
+		}
+		history, err := s.PathHistory(ctx, snapshot.Path(abs))
+		if err != nil {
+			continue
+		}
+		for i, entry := range history {
+			if i > 0 {
+				addLabel(entry.Hash, fmt.Sprintf("%s@{%d}", source, i))
+			}
+		}
+	}
+	settings, err := config.Read()
+	if err != nil {
+		return
+	}
+	for _, idSettings := range settings.Identities {
+		id, err := snapshot.ParseIdentity(idSettings.Name)
+		if err != nil {
+			continue
+		}
+		signature, err := s.LatestSignatureForIdentity(ctx, id)
+		if err != nil || signature == nil {
+			continue
+		}
+		signed, err := publish.Verify(ctx, s, id, signature)
+		if err != nil {
+			continue
+		}
+		addLabel(signed, id.String())
+	}
+}
+
+func logSubpath(ctx context.Context, s *storage.LocalFiles, source string, subpath snapshot.Path) (int, error) {
+	h, f, err := resolveSnapshotFile(ctx, s, source)
+	if err != nil {
+		return 1, fmt.Errorf("failure resolving the snapshot for %q: %v", source, err)
+	}
+	if !f.IsDir() {
+		return 1, fmt.Errorf("%q is not a directory snapshot", source)
+	}
+	entries, err := log.ReadSubpathLog(ctx, s, h, subpath, *logDepthFlag, *logFollowFlag)
+	if err != nil {
+		return 1, fmt.Errorf("failure reading the log for %q within %q: %v", subpath, source, err)
+	}
+	if jsonOutput() {
+		out := &logJSON{Entries: []*logEntryJSON{}}
+		for _, e := range entries {
+			var changes []*log.PathChange
+			if !logShort {
+				changes = e.Changes()
+			}
+			out.Entries = append(out.Entries, newLogEntryJSON(e.LogEntry, changes))
+		}
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	for i, e := range entries {
+		if logShort {
+			fmt.Println(e.Hash)
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		for _, line := range e.Summary() {
+			fmt.Println(line)
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command defines the command line interface for rvcs
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const lsTreeUsage = `Usage: %s ls-tree [<FLAGS>]* <SOURCE>[:<SUBPATH>]
+
+Where <SUBPATH> is an optional path relative to the root of <SOURCE>, <SOURCE> is one of:
+
+	The hash of a known snapshot.
+	A local file path which has previously been snapshotted.
+	An identity for which a snapshot has already been published.
+	Any of the above followed by revision suffixes such as ^1, ~2, @{1}, or :<SUBPATH>.
+
+... and <FLAGS> are one of:
+
+`
+
+var (
+	lsTreeFlags = flag.NewFlagSet("ls-tree", flag.ContinueOnError)
+
+	lsTreeRecursiveFlag = lsTreeFlags.Bool(
+		"r", false,
+		"recurse into nested directories, listing every nested file by its path relative to <SUBPATH>")
+)
+
+type treeEntry struct {
+	path string
+	hash *snapshot.Hash
+	file *snapshot.File
+}
+
+func listTree(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, f *snapshot.File, prefix string, recursive bool, entries []*treeEntry) ([]*treeEntry, error) {
+	tree, err := s.ListDirectorySnapshotContents(ctx, h, f)
+	if err != nil {
+		return nil, fmt.Errorf("failure listing the contents of %q: %v", h, err)
+	}
+	var children []string
+	for child := range tree {
+		children = append(children, string(child))
+	}
+	sort.Strings(children)
+	for _, child := range children {
+		childHash := tree[snapshot.Path(child)]
+		childFile, err := s.ReadSnapshot(ctx, childHash)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading the snapshot of %q: %v", child, err)
+		}
+		childPath := path.Join(prefix, child)
+		entries = append(entries, &treeEntry{path: childPath, hash: childHash, file: childFile})
+		if recursive && childFile.IsDir() {
+			entries, err = listTree(ctx, s, childHash, childFile, childPath, recursive, entries)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+func lsTreeCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
+	lsTreeFlags.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), lsTreeUsage, cmd)
+		lsTreeFlags.PrintDefaults()
+	}
+	if err := lsTreeFlags.Parse(args); err != nil {
+		return 1, nil
+	}
+	args = lsTreeFlags.Args()
+	if len(args) != 1 {
+		lsTreeFlags.Usage()
+		return 1, nil
+	}
+	h, f, err := resolveSnapshotFile(ctx, s, args[0])
+	if err != nil {
+		return 1, fmt.Errorf("failure resolving the snapshot for %q: %v", args[0], err)
+	}
+	if !f.IsDir() {
+		return 1, fmt.Errorf("%q is not the snapshot of a directory", h)
+	}
+	entries, err := listTree(ctx, s, h, f, "", *lsTreeRecursiveFlag, nil)
+	if err != nil {
+		return 1, err
+	}
+	if jsonOutput() {
+		out := &treeJSON{Hash: h.String(), Entries: []*treeEntryJSON{}}
+		for _, e := range entries {
+			out.Entries = append(out.Entries, &treeEntryJSON{Path: e.path, Mode: e.file.Mode, Hash: e.hash.String()})
+		}
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	for _, e := range entries {
+		fmt.Printf("%s %s\t%s\n", e.file.Mode, e.hash, e.path)
+	}
+	return 0, nil
+}
@@ -17,26 +17,196 @@ package command
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/google/recursive-version-control-system/config"
 	"github.com/google/recursive-version-control-system/merge"
 	"github.com/google/recursive-version-control-system/snapshot"
 	"github.com/google/recursive-version-control-system/storage"
 )
 
-const mergeUsage = `Usage: %s merge <SOURCE> <DESTINATION>
+const mergeUsage = `Usage: %s merge [<FLAGS>]* <SOURCE> <DESTINATION>
+	%s merge --continue <DESTINATION>
+	%s merge --abort <DESTINATION>
 
 Where <DESTINATION> is a local file path, and <SOURCE> is one of:
 
 	The hash of a known snapshot.
 	A local file path which has previously been snapshotted.
+	Any of the above followed by revision suffixes such as ^1, ~2, @{1}, or :<SUBPATH>.
+
+If some files have conflicting changes, then the merge is still written to
+<DESTINATION> with the conflicts marked in those files, or with the source
+version of each conflicting file written alongside it. The conflicts can be
+listed with the "conflicts" subcommand. Once they are resolved, "--continue"
+snapshots the result as a merge of both sides, while "--abort" restores
+<DESTINATION> to how it was before the merge.
+
+Files changed on both sides are merged line by line (or using the merge
+helper command, if one is configured in the environment), unless a
+different strategy is chosen for them with "--strategy" or "-X", or in the
+"mergeStrategies" config setting. The built-in strategies are "helper" (the
+default), "ours" (keep the destination version), "theirs" (take the source version),
+"union" (keep the lines from both sides), and "newest" (take the more recent
+version). Custom drivers defined in the "mergeDrivers" config setting can
+also be used as strategies.
+
+With "--dry-run", <DESTINATION> is left untouched, and instead the hash of
+the merged snapshot is printed along with the nested paths that the merge
+would add (A), remove (D), modify (M), or leave conflicted (C).
+
+<FLAGS> are one of:
+
 `
 
+var (
+	mergeFlags = flag.NewFlagSet("merge", flag.ContinueOnError)
+
+	mergeContinueFlag = mergeFlags.Bool(
+		"continue", false,
+		"complete an in-progress merge whose conflicts have all been resolved")
+	mergeAbortFlag = mergeFlags.Bool(
+		"abort", false,
+		"cancel an in-progress merge, restoring the destination to its prior snapshot")
+	mergeDryRunFlag = mergeFlags.Bool(
+		"dry-run", false,
+		"compute and describe the merge without modifying the destination")
+	mergeStrategyFlag = mergeFlags.String(
+		"strategy", "",
+		"merge strategy for files changed on both sides that do not match any more specific rule")
+	mergeKeepSourceModeFlag = mergeFlags.Bool(
+		"keep-source-mode", false,
+		"use the source permissions for directories whose permissions differ, rather than reporting a conflict")
+
+	mergeRules strategyRules
+)
+
+func init() {
+	mergeFlags.Var(&mergeRules, "X",
+		"`<PATTERN>=<STRATEGY>` pair assigning a merge strategy to the files matching the pattern. May be repeated, and takes precedence over the config")
+}
+
+// strategyRules implements the `flag.Value` interface for a repeated
+// flag of merge strategy rules.
+type strategyRules []*merge.Rule
+
+func (r *strategyRules) String() string {
+	if r == nil {
+		return ""
+	}
+	var rules []string
+	for _, rule := range *r {
+		rules = append(rules, rule.Pattern+"="+rule.Strategy)
+	}
+	return strings.Join(rules, ",")
+}
+
+func (r *strategyRules) Set(value string) error {
+	pattern, strategy, ok := strings.Cut(value, "=")
+	if !ok || len(pattern) == 0 || len(strategy) == 0 {
+		return fmt.Errorf("malformed merge strategy rule %q; it must be of the form <PATTERN>=<STRATEGY>", value)
+	}
+	*r = append(*r, &merge.Rule{Pattern: pattern, Strategy: strategy})
+	return nil
+}
+
+// mergeOptions returns the merge options from the config settings and
+// the command line flags.
+func mergeOptions() (*merge.Options, error) {
+	settings, err := config.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the config settings: %v", err)
+	}
+	opts := &merge.Options{
+		Strategy:       *mergeStrategyFlag,
+		Drivers:        make(map[string]*merge.Driver),
+		KeepSourceMode: *mergeKeepSourceModeFlag,
+	}
+	for _, d := range settings.MergeDrivers {
+		opts.Drivers[d.Name] = &merge.Driver{Command: d.Command, Args: d.Args}
+	}
+	for _, r := range settings.MergeStrategies {
+		opts.Rules = append(opts.Rules, &merge.Rule{Pattern: r.Pattern, Strategy: r.Strategy})
+	}
+	opts.Rules = append(opts.Rules, mergeRules...)
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+const (
+	mergeAdded      = "added"
+	mergeRemoved    = "removed"
+	mergeModified   = "modified"
+	mergeConflicted = "conflicted"
+)
+
+var mergeStatusLetters = map[string]string{
+	mergeAdded:      "A",
+	mergeRemoved:    "D",
+	mergeModified:   "M",
+	mergeConflicted: "C",
+}
+
+func newConflictJSON(c *storage.MergeConflict) *conflictJSON {
+	return &conflictJSON{
+		Path:    string(c.Path),
+		Kind:    c.Kind,
+		Sidecar: string(c.Sidecar),
+		Reason:  c.Reason,
+	}
+}
+
+func newResolutionJSONs(resolutions []*merge.Resolution) []*resolutionJSON {
+	result := []*resolutionJSON{}
+	for _, r := range resolutions {
+		result = append(result, &resolutionJSON{
+			Path:        string(r.Path),
+			Kind:        r.Kind,
+			Description: r.Description,
+		})
+	}
+	return result
+}
+
+func describeConflict(c *storage.MergeConflict) string {
+	switch c.Kind {
+	case merge.ConflictMarkers:
+		return fmt.Sprintf("%s: the conflicting changes are marked in the file", c.Path)
+	case merge.ConflictVersions:
+		return fmt.Sprintf("%s: the other version was written to %s", c.Path, c.Sidecar)
+	case merge.ConflictType:
+		return fmt.Sprintf("%s: the two versions are different types of files, so the other version was written to %s", c.Path, c.Sidecar)
+	case merge.ConflictMode:
+		return fmt.Sprintf("%s: the permissions differed and the destination permissions were kept", c.Path)
+	}
+	return fmt.Sprintf("%s: %s", c.Path, c.Reason)
+}
+
 func mergeCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
+	mergeFlags.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), mergeUsage, cmd, cmd, cmd)
+		mergeFlags.PrintDefaults()
+	}
+	if err := mergeFlags.Parse(args); err != nil {
+		return 1, nil
+	}
+	args = mergeFlags.Args()
+	if *mergeContinueFlag || *mergeAbortFlag {
+		if len(args) != 1 || (*mergeContinueFlag && *mergeAbortFlag) || *mergeDryRunFlag {
+			mergeFlags.Usage()
+			return 1, nil
+		}
+		return finishMerge(ctx, s, args[0])
+	}
 	if len(args) != 2 {
-		fmt.Fprintf(flag.CommandLine.Output(), mergeUsage, cmd)
+		mergeFlags.Usage()
 		return 1, nil
 	}
 	h, err := resolveSnapshot(ctx, s, args[0])
@@ -47,8 +217,155 @@ func mergeCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args [
 	if err != nil {
 		return 1, fmt.Errorf("failure determining the absolute path of %q: %v", args[1], err)
 	}
-	if err := merge.Merge(ctx, s, h, snapshot.Path(abs)); err != nil {
+	opts, err := mergeOptions()
+	if err != nil {
+		return 1, err
+	}
+	if *mergeDryRunFlag {
+		return previewMerge(ctx, s, h, abs, opts)
+	}
+	progressCtx, done := withProgress(ctx, "Snapshotting")
+	_, _, err = snapshot.Current(progressCtx, s, snapshot.Path(abs))
+	done()
+	if err != nil {
+		return 1, fmt.Errorf("failure snapshotting the destination %q: %v", abs, err)
+	}
+	progressCtx, done = withProgress(ctx, "Checking out")
+	p, err := merge.Merge(progressCtx, s, h, snapshot.Path(abs), opts)
+	done()
+	var conflictErr *merge.ConflictError
+	if err != nil && !errors.As(err, &conflictErr) {
 		return 1, fmt.Errorf("failure merging %q into %q: %v", h, abs, err)
 	}
+	if jsonOutput() {
+		out := &mergeJSON{Source: h.String(), Path: abs}
+		if len(p.Resolutions) > 0 {
+			out.Resolutions = newResolutionJSONs(p.Resolutions)
+		}
+		if conflictErr != nil {
+			out.Conflicts = []*conflictJSON{}
+			for _, c := range conflictErr.Conflicts {
+				out.Conflicts = append(out.Conflicts, newConflictJSON(c))
+			}
+		}
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+	} else {
+		if len(p.Resolutions) > 0 {
+			fmt.Printf("Merging %q into %q automatically resolved:\n", h, abs)
+		}
+		for _, r := range p.Resolutions {
+			fmt.Printf("\t%s: %s\n", r.Path, r.Description)
+		}
+		if conflictErr != nil {
+			fmt.Printf("Merging %q into %q had conflicts:\n", h, abs)
+			for _, c := range conflictErr.Conflicts {
+				fmt.Printf("\t%s\n", describeConflict(c))
+			}
+			fmt.Printf("Resolve them and then run `%s merge --continue %s`, or undo the merge with `%s merge --abort %s`\n", cmd, args[1], cmd, args[1])
+		}
+	}
+	if conflictErr != nil {
+		return 1, nil
+	}
 	return 0, nil
 }
+
+func finishMerge(ctx context.Context, s *storage.LocalFiles, dest string) (int, error) {
+	abs, err := filepath.Abs(dest)
+	if err != nil {
+		return 1, fmt.Errorf("failure determining the absolute path of %q: %v", dest, err)
+	}
+	state, err := s.ReadMergeState(ctx, snapshot.Path(abs))
+	if err != nil {
+		return 1, err
+	}
+	if state == nil {
+		return 1, fmt.Errorf("there is no merge in progress for %q", abs)
+	}
+	if *mergeAbortFlag {
+		if err := merge.Abort(ctx, s, snapshot.Path(abs)); err != nil {
+			return 1, fmt.Errorf("failure aborting the merge into %q: %v", abs, err)
+		}
+		if jsonOutput() {
+			if err := writeJSON(&mergeJSON{Source: state.Source.String(), Path: abs}); err != nil {
+				return 1, err
+			}
+		}
+		return 0, nil
+	}
+	h, err := merge.Continue(ctx, s, snapshot.Path(abs))
+	if err != nil {
+		return 1, fmt.Errorf("failure continuing the merge into %q: %v", abs, err)
+	}
+	if jsonOutput() {
+		if err := writeJSON(&mergeJSON{Source: state.Source.String(), Path: abs, Merged: h.String()}); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	fmt.Println(h)
+	return 0, nil
+}
+
+func previewMerge(ctx context.Context, s *storage.LocalFiles, src *snapshot.Hash, dest string, opts *merge.Options) (int, error) {
+	p, err := merge.DryRun(ctx, s, src, snapshot.Path(dest), opts)
+	if err != nil {
+		return 1, fmt.Errorf("failure previewing the merge of %q into %q: %v", src, dest, err)
+	}
+	statuses := make(map[snapshot.Path]string)
+	for _, c := range p.Changes {
+		switch {
+		case c.IsAdded():
+			statuses[c.NewPath] = mergeAdded
+		case c.IsDeleted():
+			statuses[c.NewPath] = mergeRemoved
+		default:
+			statuses[c.NewPath] = mergeModified
+		}
+	}
+	for _, c := range p.Conflicts {
+		statuses[c.Path] = mergeConflicted
+	}
+	var paths []snapshot.Path
+	for path, _ := range statuses {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i] < paths[j]
+	})
+	retcode := 0
+	if len(p.Conflicts) > 0 {
+		retcode = 1
+	}
+	if jsonOutput() {
+		out := &mergePreviewJSON{
+			Base:        hashString(p.Base),
+			Source:      p.Source.String(),
+			Destination: hashString(p.Destination),
+			Merged:      p.Merged.String(),
+			Changes:     []*mergePreviewChangeJSON{},
+			Conflicts:   []*conflictJSON{},
+			Resolutions: newResolutionJSONs(p.Resolutions),
+		}
+		for _, path := range paths {
+			out.Changes = append(out.Changes, &mergePreviewChangeJSON{
+				Path:   string(path),
+				Status: statuses[path],
+			})
+		}
+		for _, c := range p.Conflicts {
+			out.Conflicts = append(out.Conflicts, newConflictJSON(c))
+		}
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+		return retcode, nil
+	}
+	fmt.Println(p.Merged)
+	for _, path := range paths {
+		fmt.Printf("%s\t%s\n", mergeStatusLetters[statuses[path]], path)
+	}
+	return retcode, nil
+}
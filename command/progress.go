@@ -0,0 +1,37 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command defines the command line interface for rvcs
+package command
+
+import (
+	"context"
+	"os"
+	"syscall"
+
+	"github.com/google/recursive-version-control-system/progress"
+	"golang.org/x/term"
+)
+
+// withProgress attaches a progress bar to the given context if stdout is a terminal.
+//
+// The returned function clears the progress bar, and must be called before
+// the command writes any output of its own.
+func withProgress(ctx context.Context, label string) (context.Context, func()) {
+	if jsonOutput() || !term.IsTerminal(syscall.Stdout) {
+		return ctx, func() {}
+	}
+	bar := progress.NewBar(os.Stdout, label)
+	return progress.WithReporter(ctx, bar), bar.Done
+}
@@ -33,6 +33,7 @@ Where <DESTINATION> is an identity, and <SOURCE> is one of:
 	The hash of a known snapshot.
 	A local file path which has previously been snapshotted.
 	A different identity for which a snapshot has already been published.
+	Any of the above followed by revision suffixes such as ^1, ~2, @{1}, or :<SUBPATH>.
 `
 
 func publishCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
@@ -68,6 +69,12 @@ func publishCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args
 	if err != nil {
 		return 1, fmt.Errorf("failure pushing the latest signature for %q: %v", id, err)
 	}
+	if jsonOutput() {
+		if err := writeJSON(&publishJSON{Signature: signature.String(), Identity: id.String()}); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
 	fmt.Printf("%s  %s\n", signature, id)
 	return 0, nil
 }
@@ -61,5 +61,14 @@ func removeMirrorCommand(ctx context.Context, s *storage.LocalFiles, cmd string,
 	if err := settings.Write(); err != nil {
 		return 1, fmt.Errorf("failure writing the updated config settings: %v", err)
 	}
+	if jsonOutput() {
+		out := &mirrorJSON{URL: mirrorURL.String()}
+		if id != nil {
+			out.Identity = id.String()
+		}
+		if err := writeJSON(out); err != nil {
+			return 1, err
+		}
+	}
 	return 0, nil
 }
@@ -0,0 +1,60 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package command defines the command line interface for rvcs
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const showUsage = `Usage: %s show <SOURCE>[:<SUBPATH>]
+
+Where <SUBPATH> is an optional path relative to the root of <SOURCE>, and <SOURCE> is one of:
+
+	The hash of a known snapshot.
+	A local file path which has previously been snapshotted.
+	An identity for which a snapshot has already been published.
+	Any of the above followed by revision suffixes such as ^1, ~2, @{1}, or :<SUBPATH>.
+`
+
+func showCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
+	if len(args) != 1 {
+		fmt.Fprintf(flag.CommandLine.Output(), showUsage, cmd)
+		return 1, nil
+	}
+	h, f, err := resolveSnapshotFile(ctx, s, args[0])
+	if err != nil {
+		return 1, fmt.Errorf("failure resolving the snapshot for %q: %v", args[0], err)
+	}
+	if jsonOutput() {
+		if err := writeJSON(newFileJSON(h, f)); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	fmt.Printf("snapshot %s\n", h)
+	fmt.Printf("mode     %s\n", f.Mode)
+	if f.Contents != nil {
+		fmt.Printf("contents %s\n", f.Contents)
+	}
+	for _, parent := range f.Parents {
+		fmt.Printf("parent   %s\n", parent)
+	}
+	return 0, nil
+}
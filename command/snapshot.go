@@ -20,8 +20,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/recursive-version-control-system/snapshot"
 	"github.com/google/recursive-version-control-system/storage"
@@ -39,8 +41,57 @@ var (
 	snapshotAdditionalParentsFlag = snapshotFlags.String(
 		"additional-parents", "",
 		"comma separated list of additional parents for the generated snapshot")
+	snapshotMessageFlag = snapshotFlags.String(
+		"m", "",
+		"message describing the generated snapshot. If provided, the snapshot is annotated with the message, author, and current time")
+	snapshotAuthorFlag = snapshotFlags.String(
+		"author", "",
+		"author recorded in the snapshot annotation. Defaults to the current user")
+	snapshotUpdateAncestorsFlag = snapshotFlags.Bool(
+		"update-ancestors", false,
+		"if true, then the new snapshot is also propagated up into the existing snapshots of the path's ancestor directories, without rescanning any of their other contents")
 )
 
+func updateAncestors(ctx context.Context, s *storage.LocalFiles, p snapshot.Path, h *snapshot.Hash) ([]*snapshotJSON, error) {
+	updated, err := snapshot.UpdateAncestors(ctx, s, p, h)
+	if err != nil {
+		return nil, fmt.Errorf("failure updating the ancestors of %q: %v", p, err)
+	}
+	var result []*snapshotJSON
+	for _, ancestor := range updated {
+		ancestorHash, _, err := s.FindSnapshot(ctx, ancestor)
+		if err != nil {
+			return nil, fmt.Errorf("failure looking up the updated snapshot of %q: %v", ancestor, err)
+		}
+		result = append(result, &snapshotJSON{Hash: ancestorHash.String(), Path: string(ancestor)})
+	}
+	return result, nil
+}
+
+func printSnapshots(out *snapshotJSON) error {
+	if jsonOutput() {
+		return writeJSON(out)
+	}
+	if len(out.Hash) > 0 {
+		fmt.Printf("%s  %s\n", out.Hash, out.Path)
+	}
+	for _, ancestor := range out.Ancestors {
+		fmt.Printf("%s  %s\n", ancestor.Hash, ancestor.Path)
+	}
+	return nil
+}
+
+func defaultAuthor() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	if len(u.Name) > 0 {
+		return u.Name
+	}
+	return u.Username
+}
+
 func snapshotCommand(ctx context.Context, s *storage.LocalFiles, cmd string, args []string) (int, error) {
 	snapshotFlags.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), snapshotUsage, cmd)
@@ -50,6 +101,9 @@ func snapshotCommand(ctx context.Context, s *storage.LocalFiles, cmd string, arg
 		return 1, nil
 	}
 	args = snapshotFlags.Args()
+	if err := (&snapshot.Annotation{Author: *snapshotAuthorFlag}).Validate(); err != nil {
+		return 1, err
+	}
 
 	var additionalParents []*snapshot.Hash
 	for _, parent := range strings.Split(*snapshotAdditionalParentsFlag, ",") {
@@ -78,10 +132,31 @@ func snapshotCommand(ctx context.Context, s *storage.LocalFiles, cmd string, arg
 	}
 	path = abs
 
-	h, f, err := snapshot.Current(ctx, s, snapshot.Path(path))
+	prevHash, _, err := s.FindSnapshot(ctx, snapshot.Path(path))
+	if err != nil && !os.IsNotExist(err) {
+		return 1, fmt.Errorf("failure looking up the previous snapshot of %q: %v", path, err)
+	}
+
+	progressCtx, done := withProgress(ctx, "Snapshotting")
+	h, f, err := snapshot.Current(progressCtx, s, snapshot.Path(path))
+	done()
 	if err != nil {
 		return 1, fmt.Errorf("failure snapshotting the directory %q: %v\n", path, err)
 	} else if h == nil || f == nil {
+		if *snapshotUpdateAncestorsFlag {
+			// The path was removed, so remove it from its ancestors.
+			ancestors, err := updateAncestors(ctx, s, snapshot.Path(path), nil)
+			if err != nil {
+				return 1, err
+			}
+			if err := printSnapshots(&snapshotJSON{Path: path, Ancestors: ancestors}); err != nil {
+				return 1, err
+			}
+			return 0, nil
+		}
+		if jsonOutput() {
+			return 1, fmt.Errorf("did not generate a snapshot as %q does not exist", path)
+		}
 		fmt.Printf("Did not generate a snapshot as %q does not exist\n", path)
 		return 1, nil
 	}
@@ -93,6 +168,44 @@ func snapshotCommand(ctx context.Context, s *storage.LocalFiles, cmd string, arg
 		}
 	}
 
-	fmt.Printf("%s  %s\n", h, path)
+	if len(*snapshotMessageFlag) > 0 || len(*snapshotAuthorFlag) > 0 {
+		author := *snapshotAuthorFlag
+		if len(author) == 0 {
+			author = defaultAuthor()
+		}
+		if h.Equal(prevHash) {
+			// Nothing changed, so annotating the snapshot would
+			// replace the annotation of the earlier snapshot.
+			if _, prev, err := s.FindAnnotation(ctx, h); err != nil {
+				return 1, fmt.Errorf("failure reading the annotation for %q: %v", h, err)
+			} else if prev != nil {
+				return 1, fmt.Errorf("%q is unchanged since the snapshot %q, which is already annotated", path, h)
+			}
+		}
+		a := &snapshot.Annotation{
+			Snapshot: h,
+			Author:   author,
+			Time:     time.Now(),
+			Message:  *snapshotMessageFlag,
+		}
+		if _, err := s.StoreAnnotation(ctx, a); err != nil {
+			return 1, fmt.Errorf("failure annotating the snapshot %q: %v", h, err)
+		}
+	}
+
+	out := &snapshotJSON{Hash: h.String(), Path: path}
+	if *snapshotUpdateAncestorsFlag {
+		out.Ancestors, err = updateAncestors(ctx, s, snapshot.Path(path), h)
+		if err != nil {
+			if !jsonOutput() {
+				// Report the snapshot that was generated before failing.
+				printSnapshots(&snapshotJSON{Hash: out.Hash, Path: path})
+			}
+			return 1, err
+		}
+	}
+	if err := printSnapshots(out); err != nil {
+		return 1, err
+	}
 	return 0, nil
 }
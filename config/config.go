@@ -97,6 +97,36 @@ type Identity struct {
 	Mirrors []*Mirror `json:"mirrors,omitempty"`
 }
 
+// MergeStrategy assigns a merge strategy to the nested paths matching a pattern.
+type MergeStrategy struct {
+	// Pattern is a glob pattern, as supported by `path.Match`, for the
+	// paths the strategy applies to.
+	//
+	// Patterns containing a "/" are matched against the whole path
+	// relative to the merge destination, while other patterns are
+	// matched against just the last element of the path.
+	Pattern string `json:"pattern"`
+
+	// Strategy is the name of either a built-in merge strategy, or of
+	// a merge driver defined in the `mergeDrivers` field.
+	Strategy string `json:"strategy"`
+}
+
+// MergeDriver defines a custom command for merging files.
+//
+// The command is invoked in the same way as the default merge helper.
+type MergeDriver struct {
+	// Name is the name used to refer to the driver as a merge strategy.
+	Name string `json:"name"`
+
+	// Command is the command to run.
+	Command string `json:"command"`
+
+	// Args are extra arguments passed to the command before the paths
+	// of the files to merge.
+	Args []string `json:"args,omitempty"`
+}
+
 // Settings defines configuration settings for the rvcs tool.
 type Settings struct {
 	// Identities is a list of configurations for each of the identities we keep track of.
@@ -106,6 +136,15 @@ type Settings struct {
 	// any identities that do not have a matching entry in the
 	// `identities` field.
 	AdditionalMirrors []*Mirror `json:"additionalMirrors,omitempty"`
+
+	// MergeStrategies is a list of merge strategies for nested paths.
+	//
+	// If more than one entry matches a path, then the last one is used.
+	MergeStrategies []*MergeStrategy `json:"mergeStrategies,omitempty"`
+
+	// MergeDrivers is a list of custom merge drivers that can be named
+	// as merge strategies.
+	MergeDrivers []*MergeDriver `json:"mergeDrivers,omitempty"`
 }
 
 // Read reads in the configuration saved in the user's config directory.
@@ -162,6 +201,8 @@ func (s *Settings) WithAdditionalMirror(m *Mirror) *Settings {
 	return &Settings{
 		Identities:        s.Identities,
 		AdditionalMirrors: addOrOverwriteMirror(s.AdditionalMirrors, m),
+		MergeStrategies:   s.MergeStrategies,
+		MergeDrivers:      s.MergeDrivers,
 	}
 }
 
@@ -175,6 +216,8 @@ func (s *Settings) WithAdditionalMirror(m *Mirror) *Settings {
 func (s *Settings) WithMirrorForIdentity(idName string, m *Mirror) *Settings {
 	res := &Settings{
 		AdditionalMirrors: s.AdditionalMirrors,
+		MergeStrategies:   s.MergeStrategies,
+		MergeDrivers:      s.MergeDrivers,
 	}
 	for i, existingID := range s.Identities {
 		if existingID.Name != idName {
@@ -202,6 +245,8 @@ func (s *Settings) WithoutAdditionalMirror(u *url.URL) *Settings {
 	return &Settings{
 		Identities:        s.Identities,
 		AdditionalMirrors: removeMirror(s.AdditionalMirrors, u),
+		MergeStrategies:   s.MergeStrategies,
+		MergeDrivers:      s.MergeDrivers,
 	}
 }
 
@@ -212,6 +257,8 @@ func (s *Settings) WithoutAdditionalMirror(u *url.URL) *Settings {
 func (s *Settings) WithoutMirrorForIdentity(idName string, u *url.URL) *Settings {
 	res := &Settings{
 		AdditionalMirrors: s.AdditionalMirrors,
+		MergeStrategies:   s.MergeStrategies,
+		MergeDrivers:      s.MergeDrivers,
 	}
 	for i, existingID := range s.Identities {
 		if existingID.Name != idName {
@@ -62,6 +62,23 @@ func TestParseSettings(t *testing.T) {
 				},
 			},
 		},
+		{
+			Description: "Merge strategies and drivers",
+			Serialized:  "{\"mergeStrategies\": [{\"pattern\": \"*.lock\", \"strategy\": \"theirs\"}, {\"pattern\": \"docs/CHANGELOG\", \"strategy\": \"changelog\"}], \"mergeDrivers\": [{\"name\": \"changelog\", \"command\": \"merge-changelog\", \"args\": [\"--sort\"]}]}",
+			Want: &Settings{
+				MergeStrategies: []*MergeStrategy{
+					&MergeStrategy{Pattern: "*.lock", Strategy: "theirs"},
+					&MergeStrategy{Pattern: "docs/CHANGELOG", Strategy: "changelog"},
+				},
+				MergeDrivers: []*MergeDriver{
+					&MergeDriver{
+						Name:    "changelog",
+						Command: "merge-changelog",
+						Args:    []string{"--sort"},
+					},
+				},
+			},
+		},
 	}
 	for _, testCase := range testCases {
 		var s Settings
@@ -0,0 +1,300 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diff defines methods for comparing the contents of two snapshots.
+package diff
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// Change describes a single nested file that differs between two snapshots.
+type Change struct {
+	// OldPath is the path of the file in the old snapshot, relative to
+	// the root of that snapshot.
+	//
+	// This is the empty string when the file is the root of the snapshot.
+	OldPath snapshot.Path
+
+	// NewPath is the path of the file in the new snapshot, relative to
+	// the root of that snapshot.
+	//
+	// This only differs from `OldPath` when the file was renamed.
+	NewPath snapshot.Path
+
+	// Old is the hash of the old file snapshot, or nil if the file was added.
+	Old *snapshot.Hash
+
+	// OldFile is the old file snapshot, or nil if the file was added.
+	OldFile *snapshot.File
+
+	// New is the hash of the new file snapshot, or nil if the file was deleted.
+	New *snapshot.Hash
+
+	// NewFile is the new file snapshot, or nil if the file was deleted.
+	NewFile *snapshot.File
+}
+
+// IsAdded reports whether or not the file only exists in the new snapshot.
+func (c *Change) IsAdded() bool {
+	return c.OldFile == nil
+}
+
+// IsDeleted reports whether or not the file only exists in the old snapshot.
+func (c *Change) IsDeleted() bool {
+	return c.NewFile == nil
+}
+
+// IsRenamed reports whether or not the file was moved to a different path.
+func (c *Change) IsRenamed() bool {
+	return c.OldFile != nil && c.NewFile != nil && c.OldPath != c.NewPath
+}
+
+// ModeChanged reports whether or not the file mode differs between the
+// old and new snapshots of the file.
+func (c *Change) ModeChanged() bool {
+	return c.OldFile != nil && c.NewFile != nil && c.OldFile.Mode != c.NewFile.Mode
+}
+
+// ContentsChanged reports whether or not the contents differ between the
+// old and new snapshots of the file.
+func (c *Change) ContentsChanged() bool {
+	if c.OldFile == nil || c.NewFile == nil {
+		return true
+	}
+	return !c.OldFile.Contents.Equal(c.NewFile.Contents)
+}
+
+func readFile(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash) (*snapshot.File, error) {
+	if h == nil {
+		return nil, nil
+	}
+	f, err := s.ReadSnapshot(ctx, h)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the file snapshot for %q: %v", h, err)
+	}
+	return f, nil
+}
+
+func readTree(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, f *snapshot.File) (snapshot.Tree, error) {
+	if !f.IsDir() {
+		return make(snapshot.Tree), nil
+	}
+	tree, err := s.ListDirectorySnapshotContents(ctx, h, f)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the tree for the snapshot %q: %v", h, err)
+	}
+	return tree, nil
+}
+
+// changedTrees returns the children that differ between the old and new
+// snapshots, read from their respective trees.
+//
+// When both sides are directories, this only reads the parts of each
+// tree that differ.
+func changedTrees(ctx context.Context, s *storage.LocalFiles, old *snapshot.Hash, oldFile *snapshot.File, new *snapshot.Hash, newFile *snapshot.File) (oldTree, newTree snapshot.Tree, err error) {
+	if oldFile.IsDir() && newFile.IsDir() {
+		oldTree, newTree, err = snapshot.DiffTrees(ctx, s, oldFile.Contents, newFile.Contents)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failure comparing the trees for the snapshots %q and %q: %v", old, new, err)
+		}
+		return oldTree, newTree, nil
+	}
+	oldTree, err = readTree(ctx, s, old, oldFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	newTree, err = readTree(ctx, s, new, newFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return oldTree, newTree, nil
+}
+
+func walk(ctx context.Context, s *storage.LocalFiles, p snapshot.Path, old, new *snapshot.Hash, changes []*Change) ([]*Change, error) {
+	if old.Equal(new) {
+		return changes, nil
+	}
+	oldFile, err := readFile(ctx, s, old)
+	if err != nil {
+		return nil, err
+	}
+	newFile, err := readFile(ctx, s, new)
+	if err != nil {
+		return nil, err
+	}
+	if !oldFile.IsDir() && !newFile.IsDir() {
+		return append(changes, &Change{
+			OldPath: p,
+			NewPath: p,
+			Old:     old,
+			OldFile: oldFile,
+			New:     new,
+			NewFile: newFile,
+		}), nil
+	}
+
+	// At least one side is a directory, so we compare the nested
+	// contents of both sides. If only one side is a directory, then
+	// the other side is reported as a separate addition or deletion.
+	if oldFile != nil && !oldFile.IsDir() {
+		changes = append(changes, &Change{OldPath: p, NewPath: p, Old: old, OldFile: oldFile})
+	}
+	if newFile != nil && !newFile.IsDir() {
+		changes = append(changes, &Change{OldPath: p, NewPath: p, New: new, NewFile: newFile})
+	}
+	if oldFile.IsDir() && newFile.IsDir() && oldFile.Mode != newFile.Mode {
+		changes = append(changes, &Change{OldPath: p, NewPath: p, Old: old, OldFile: oldFile, New: new, NewFile: newFile})
+	}
+	oldTree, newTree, err := changedTrees(ctx, s, old, oldFile, new, newFile)
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[snapshot.Path]struct{})
+	for child, _ := range oldTree {
+		children[child] = struct{}{}
+	}
+	for child, _ := range newTree {
+		children[child] = struct{}{}
+	}
+	var sorted []string
+	for child, _ := range children {
+		sorted = append(sorted, string(child))
+	}
+	sort.Strings(sorted)
+	for _, child := range sorted {
+		childPath := snapshot.Path(child)
+		changes, err = walk(ctx, s, p.Join(childPath), oldTree[childPath], newTree[childPath], changes)
+		if err != nil {
+			return nil, fmt.Errorf("failure comparing the nested path %q: %v", childPath, err)
+		}
+	}
+	return changes, nil
+}
+
+// detectRenames pairs up deleted and added files that have identical contents.
+func detectRenames(changes []*Change) []*Change {
+	deletedByContents := make(map[snapshot.Hash][]*Change)
+	for _, c := range changes {
+		if c.IsDeleted() && !c.IsAdded() && c.OldFile.Contents != nil {
+			deletedByContents[*c.OldFile.Contents] = append(deletedByContents[*c.OldFile.Contents], c)
+		}
+	}
+	renamed := make(map[*Change]struct{})
+	for _, c := range changes {
+		if !c.IsAdded() || c.IsDeleted() || c.NewFile.Contents == nil {
+			continue
+		}
+		candidates := deletedByContents[*c.NewFile.Contents]
+		for i, deleted := range candidates {
+			if deleted.OldFile.IsLink() != c.NewFile.IsLink() {
+				continue
+			}
+			c.OldPath = deleted.OldPath
+			c.Old = deleted.Old
+			c.OldFile = deleted.OldFile
+			renamed[deleted] = struct{}{}
+			deletedByContents[*c.NewFile.Contents] = append(candidates[:i:i], candidates[i+1:]...)
+			break
+		}
+	}
+	var result []*Change
+	for _, c := range changes {
+		if _, ok := renamed[c]; !ok {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// Snapshots compares the two given snapshots and returns the list of
+// nested files that differ between them.
+//
+// Either of the two hashes may be nil, in which case every nested file
+// in the other snapshot is reported as either added or deleted.
+//
+// Directories are never reported as changes on their own, except when
+// the mode of a directory changed. Instead, the changes to the files
+// nested within them are reported individually.
+//
+// If `renames` is true, then any deleted file whose contents
+// exactly match an added file is reported as a rename of that file.
+func Snapshots(ctx context.Context, s *storage.LocalFiles, old, new *snapshot.Hash, renames bool) ([]*Change, error) {
+	changes, err := walk(ctx, s, snapshot.Path(""), old, new, nil)
+	if err != nil {
+		return nil, err
+	}
+	if renames {
+		changes = detectRenames(changes)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].NewPath < changes[j].NewPath
+	})
+	return changes, nil
+}
+
+// binaryCheckLimit is the number of leading bytes inspected when deciding
+// whether or not the contents of a file are binary.
+const binaryCheckLimit = 8000
+
+func isBinary(contents []byte) bool {
+	prefix := contents
+	if len(prefix) > binaryCheckLimit {
+		prefix = prefix[:binaryCheckLimit]
+	}
+	if bytes.IndexByte(prefix, 0) >= 0 {
+		return true
+	}
+	for len(prefix) > 0 {
+		r, size := utf8.DecodeRune(prefix)
+		if r == utf8.RuneError && size == 1 && len(prefix) >= utf8.UTFMax {
+			// We allow invalid runes at the very end of the
+			// prefix since we might have split a valid rune.
+			return true
+		}
+		prefix = prefix[size:]
+	}
+	return false
+}
+
+// ReadContents reads the contents of the given (non-directory) file snapshot.
+//
+// The returned boolean reports whether or not the contents appear to be
+// binary rather than text.
+func ReadContents(ctx context.Context, s *storage.LocalFiles, f *snapshot.File) ([]byte, bool, error) {
+	if f == nil || f.Contents == nil {
+		return nil, false, nil
+	}
+	if f.IsDir() {
+		return nil, false, fmt.Errorf("the contents %q are for a directory", f.Contents)
+	}
+	r, err := s.ReadObject(ctx, f.Contents)
+	if err != nil {
+		return nil, false, fmt.Errorf("failure opening the contents %q: %v", f.Contents, err)
+	}
+	defer r.Close()
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false, fmt.Errorf("failure reading the contents %q: %v", f.Contents, err)
+	}
+	return contents, isBinary(contents), nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func TestSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	s := &storage.LocalFiles{ArchiveDir: archive}
+	ctx := context.Background()
+
+	workingDir := filepath.Join(dir, "working-dir")
+	if err := os.MkdirAll(filepath.Join(workingDir, "sub"), 0700); err != nil {
+		t.Fatalf("failure creating the working directory for the test: %v", err)
+	}
+	files := map[string]string{
+		"modified.txt":  "A\nB\nC\n",
+		"deleted.txt":   "Goodbye\n",
+		"sub/moved.txt": "Moving\n",
+		"binary.bin":    "\x00\x01\x02",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(workingDir, name), []byte(contents), 0600); err != nil {
+			t.Fatalf("failure writing the example file %q: %v", name, err)
+		}
+	}
+	h1, _, err := snapshot.Current(ctx, s, snapshot.Path(workingDir))
+	if err != nil {
+		t.Fatalf("failure creating the initial snapshot: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(workingDir, "modified.txt"), []byte("A\nX\nC\n"), 0700); err != nil {
+		t.Fatalf("failure updating the modified file: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(workingDir, "modified.txt"), 0700); err != nil {
+		t.Fatalf("failure updating the permissions of the modified file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(workingDir, "deleted.txt")); err != nil {
+		t.Fatalf("failure removing the deleted file: %v", err)
+	}
+	if err := os.Rename(filepath.Join(workingDir, "sub", "moved.txt"), filepath.Join(workingDir, "moved.txt")); err != nil {
+		t.Fatalf("failure moving the moved file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(workingDir, "added.txt"), []byte("Hello\n"), 0600); err != nil {
+		t.Fatalf("failure writing the added file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(workingDir, "binary.bin"), []byte("\x00\x03"), 0600); err != nil {
+		t.Fatalf("failure updating the binary file: %v", err)
+	}
+	h2, _, err := snapshot.Current(ctx, s, snapshot.Path(workingDir))
+	if err != nil {
+		t.Fatalf("failure creating the updated snapshot: %v", err)
+	}
+
+	changes, err := Snapshots(ctx, s, h1, h2, true)
+	if err != nil {
+		t.Fatalf("failure comparing the snapshots: %v", err)
+	}
+	var got []string
+	for _, c := range changes {
+		desc := string(c.NewPath)
+		switch {
+		case c.IsAdded():
+			desc = "+" + desc
+		case c.IsDeleted():
+			desc = "-" + string(c.OldPath)
+		case c.IsRenamed():
+			desc = string(c.OldPath) + "=>" + desc
+		}
+		if c.ModeChanged() {
+			desc += "(mode)"
+		}
+		got = append(got, desc)
+	}
+	if got, want := strings.Join(got, ","), "+added.txt,binary.bin,-deleted.txt,modified.txt(mode),sub/moved.txt=>moved.txt"; got != want {
+		t.Errorf("unexpected changes: got %q, want %q", got, want)
+	}
+
+	var out bytes.Buffer
+	if err := Write(ctx, s, &out, changes, &Options{Context: 3}); err != nil {
+		t.Fatalf("failure writing the diff: %v", err)
+	}
+	for _, want := range []string{
+		"--- /dev/null\n+++ b/added.txt\n@@ -0,0 +1 @@\n+Hello\n",
+		"Binary files a/binary.bin and b/binary.bin differ\n",
+		"--- a/deleted.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-Goodbye\n",
+		"old mode -rw-------\nnew mode -rwx------\n",
+		"@@ -1,3 +1,3 @@\n A\n-B\n+X\n C\n",
+		"rename from sub/moved.txt\nrename to moved.txt\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("diff output missing %q: got %q", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := WriteStat(ctx, s, &out, changes, nil); err != nil {
+		t.Fatalf("failure writing the diff stat: %v", err)
+	}
+	if got, want := out.String(), ""+
+		" added.txt                  | 1 +\n"+
+		" binary.bin                 | Bin\n"+
+		" deleted.txt                | 1 -\n"+
+		" modified.txt               | 2 +-\n"+
+		" sub/moved.txt => moved.txt | 0\n"+
+		" 5 files changed, 2 insertions(+), 2 deletions(-)\n"; got != want {
+		t.Errorf("unexpected diff stat: got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diff defines methods for comparing the contents of two snapshots.
+package diff
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorBold   = "\033[1m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorCyan   = "\033[36m"
+	maxStatBars = 50
+)
+
+// Options controls how changes are formatted.
+type Options struct {
+	// Context is the number of unchanged lines to show around each change.
+	Context int
+
+	// Color specifies whether or not to add ascii color escape codes.
+	Color bool
+}
+
+func (o *Options) colorize(color, text string) string {
+	if o == nil || !o.Color || len(text) == 0 {
+		return text
+	}
+	return color + text + colorReset
+}
+
+func (o *Options) context() int {
+	if o == nil {
+		return 3
+	}
+	return o.Context
+}
+
+func displayPath(prefix string, p snapshot.Path) string {
+	if len(p) == 0 {
+		return strings.TrimSuffix(prefix, "/")
+	}
+	return prefix + string(p)
+}
+
+// Stat summarizes the size of a single change.
+type Stat struct {
+	// Insertions is the number of lines added by the change.
+	Insertions int
+
+	// Deletions is the number of lines removed by the change.
+	Deletions int
+
+	// Binary reports whether or not either side of the change is binary.
+	Binary bool
+}
+
+// Compare computes the line-level edits for the given change.
+//
+// If either side of the change is binary, or either side is a directory,
+// then the returned edits are nil.
+func Compare(ctx context.Context, s *storage.LocalFiles, c *Change) ([]Edit, *Stat, error) {
+	stat := &Stat{}
+	if c.OldFile.IsDir() || c.NewFile.IsDir() || !c.ContentsChanged() {
+		return nil, stat, nil
+	}
+	oldContents, oldBinary, err := ReadContents(ctx, s, c.OldFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure reading the old contents of %q: %v", c.OldPath, err)
+	}
+	newContents, newBinary, err := ReadContents(ctx, s, c.NewFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure reading the new contents of %q: %v", c.NewPath, err)
+	}
+	if oldBinary || newBinary {
+		stat.Binary = true
+		return nil, stat, nil
+	}
+	edits := Lines(SplitLines(string(oldContents)), SplitLines(string(newContents)))
+	for _, e := range edits {
+		switch e.Op {
+		case Insert:
+			stat.Insertions++
+		case Delete:
+			stat.Deletions++
+		}
+	}
+	return edits, stat, nil
+}
+
+func writeLine(w io.Writer, opts *Options, color, prefix, text string) error {
+	noNewline := !strings.HasSuffix(text, "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if _, err := fmt.Fprintln(w, opts.colorize(color, prefix+text)); err != nil {
+		return err
+	}
+	if noNewline {
+		if _, err := fmt.Fprintln(w, "\\ No newline at end of file"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeHeader(w io.Writer, opts *Options, c *Change) error {
+	oldName := displayPath("a/", c.OldPath)
+	newName := displayPath("b/", c.NewPath)
+	header := []string{fmt.Sprintf("diff --rvcs %s %s", oldName, newName)}
+	switch {
+	case c.IsAdded():
+		header = append(header, fmt.Sprintf("new file mode %s", c.NewFile.Mode))
+	case c.IsDeleted():
+		header = append(header, fmt.Sprintf("deleted file mode %s", c.OldFile.Mode))
+	case c.ModeChanged():
+		header = append(header, fmt.Sprintf("old mode %s", c.OldFile.Mode), fmt.Sprintf("new mode %s", c.NewFile.Mode))
+	}
+	if c.IsRenamed() {
+		header = append(header, fmt.Sprintf("rename from %s", c.OldPath), fmt.Sprintf("rename to %s", c.NewPath))
+	}
+	if c.Old != nil && c.New != nil && !c.Old.Equal(c.New) {
+		header = append(header, fmt.Sprintf("index %s..%s", c.Old, c.New))
+	}
+	for _, line := range header {
+		if _, err := fmt.Fprintln(w, opts.colorize(colorBold, line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write writes the unified diff for the given changes to the given writer.
+//
+// Changes to binary files are reported with a single line noting that
+// the files differ, and mode changes and renames are reported in the
+// header for each change.
+func Write(ctx context.Context, s *storage.LocalFiles, w io.Writer, changes []*Change, opts *Options) error {
+	for _, c := range changes {
+		if err := writeHeader(w, opts, c); err != nil {
+			return fmt.Errorf("failure writing the diff header for %q: %v", c.NewPath, err)
+		}
+		edits, stat, err := Compare(ctx, s, c)
+		if err != nil {
+			return err
+		}
+		oldName, newName := displayPath("a/", c.OldPath), displayPath("b/", c.NewPath)
+		if c.IsAdded() {
+			oldName = "/dev/null"
+		}
+		if c.IsDeleted() {
+			newName = "/dev/null"
+		}
+		if stat.Binary {
+			if _, err := fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName); err != nil {
+				return err
+			}
+			continue
+		}
+		hunks := Hunks(edits, opts.context())
+		if len(hunks) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, opts.colorize(colorBold, "--- "+oldName)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, opts.colorize(colorBold, "+++ "+newName)); err != nil {
+			return err
+		}
+		for _, h := range hunks {
+			hunkHeader := fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldCount), hunkRange(h.NewStart, h.NewCount))
+			if _, err := fmt.Fprintln(w, opts.colorize(colorCyan, hunkHeader)); err != nil {
+				return err
+			}
+			for _, e := range h.Edits {
+				var err error
+				switch e.Op {
+				case Equal:
+					err = writeLine(w, nil, "", " ", e.Text)
+				case Delete:
+					err = writeLine(w, opts, colorRed, "-", e.Text)
+				case Insert:
+					err = writeLine(w, opts, colorGreen, "+", e.Text)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func statName(c *Change) string {
+	if c.IsRenamed() {
+		return fmt.Sprintf("%s => %s", displayPath("", c.OldPath), displayPath("", c.NewPath))
+	}
+	if len(c.NewPath) == 0 {
+		return "."
+	}
+	return string(c.NewPath)
+}
+
+// WriteStat writes a summary of the number of lines changed per file,
+// followed by the totals for all of the given changes.
+func WriteStat(ctx context.Context, s *storage.LocalFiles, w io.Writer, changes []*Change, opts *Options) error {
+	names := make([]string, len(changes))
+	stats := make([]*Stat, len(changes))
+	var nameWidth, maxChanged, insertions, deletions int
+	for i, c := range changes {
+		_, stat, err := Compare(ctx, s, c)
+		if err != nil {
+			return err
+		}
+		names[i], stats[i] = statName(c), stat
+		if len(names[i]) > nameWidth {
+			nameWidth = len(names[i])
+		}
+		if changed := stat.Insertions + stat.Deletions; changed > maxChanged {
+			maxChanged = changed
+		}
+		insertions += stat.Insertions
+		deletions += stat.Deletions
+	}
+	countWidth := len(fmt.Sprintf("%d", maxChanged))
+	for i, stat := range stats {
+		if stat.Binary {
+			if _, err := fmt.Fprintf(w, " %-*s | %*s\n", nameWidth, names[i], countWidth, "Bin"); err != nil {
+				return err
+			}
+			continue
+		}
+		plus, minus := stat.Insertions, stat.Deletions
+		if maxChanged > maxStatBars {
+			// Scale the bars down so the widest one fits, but always
+			// show at least one character for any non-zero count.
+			plus = (plus*maxStatBars + maxChanged - 1) / maxChanged
+			minus = (minus*maxStatBars + maxChanged - 1) / maxChanged
+		}
+		bars := opts.colorize(colorGreen, strings.Repeat("+", plus)) + opts.colorize(colorRed, strings.Repeat("-", minus))
+		line := fmt.Sprintf(" %-*s | %*d %s", nameWidth, names[i], countWidth, stat.Insertions+stat.Deletions, bars)
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+	fileWord := "files"
+	if len(changes) == 1 {
+		fileWord = "file"
+	}
+	_, err := fmt.Fprintf(w, " %d %s changed, %d insertions(+), %d deletions(-)\n", len(changes), fileWord, insertions, deletions)
+	return err
+}
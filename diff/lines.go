@@ -0,0 +1,265 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diff defines methods for comparing the contents of two snapshots.
+package diff
+
+import (
+	"strings"
+)
+
+// Op identifies the kind of a single line-level edit.
+type Op int
+
+const (
+	// Equal means the line is present in both the old and new versions.
+	Equal Op = iota
+
+	// Insert means the line is only present in the new version.
+	Insert
+
+	// Delete means the line is only present in the old version.
+	Delete
+)
+
+// Edit is a single step in the edit script that transforms one sequence
+// of lines into another.
+type Edit struct {
+	Op Op
+
+	// OldLine is the zero-based index of the line in the old version.
+	//
+	// This is only meaningful for `Equal` and `Delete` edits.
+	OldLine int
+
+	// NewLine is the zero-based index of the line in the new version.
+	//
+	// This is only meaningful for `Equal` and `Insert` edits.
+	NewLine int
+
+	// Text is the contents of the line, including any trailing newline.
+	Text string
+}
+
+// SplitLines splits the given contents into lines.
+//
+// Each returned line includes its trailing newline, if any, so that
+// joining the returned lines reproduces the original contents exactly.
+func SplitLines(contents string) []string {
+	if len(contents) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(contents, "\n")
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines computes a minimal edit script that transforms `old` into `new`.
+//
+// The returned edits are in order, and every line from both inputs is
+// covered by exactly one edit.
+func Lines(old, new []string) []Edit {
+	var prefix int
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	var suffix int
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+	edits := make([]Edit, 0, len(old)+len(new)-prefix-suffix)
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, Edit{Op: Equal, OldLine: i, NewLine: i, Text: old[i]})
+	}
+	edits = append(edits, myers(old[prefix:len(old)-suffix], new[prefix:len(new)-suffix], prefix, prefix)...)
+	for i := suffix; i > 0; i-- {
+		oldLine, newLine := len(old)-i, len(new)-i
+		edits = append(edits, Edit{Op: Equal, OldLine: oldLine, NewLine: newLine, Text: old[oldLine]})
+	}
+	return edits
+}
+
+// myers implements the greedy shortest edit script algorithm from
+// "An O(ND) Difference Algorithm and Its Variations" by Eugene Myers.
+//
+// The `oldOffset` and `newOffset` arguments are added to the line
+// indices of the returned edits.
+func myers(old, new []string, oldOffset, newOffset int) []Edit {
+	n, m := len(old), len(new)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	// v[k+max] holds the furthest reaching x coordinate on diagonal k.
+	//
+	// trace[d] holds a copy of the diagonals -d..d after round d, which
+	// is what we need to walk back from the end to recover the path.
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && old[x] == new[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		round := make([]int, 2*d+1)
+		copy(round, v[max-d:max+d+1])
+		trace = append(trace, round)
+		if done {
+			break
+		}
+	}
+
+	var reversed []Edit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, Edit{Op: Equal, OldLine: x + oldOffset, NewLine: y + newOffset, Text: old[x]})
+		}
+		if x == prevX {
+			y--
+			reversed = append(reversed, Edit{Op: Insert, OldLine: x + oldOffset, NewLine: y + newOffset, Text: new[y]})
+		} else {
+			x--
+			reversed = append(reversed, Edit{Op: Delete, OldLine: x + oldOffset, NewLine: y + newOffset, Text: old[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		reversed = append(reversed, Edit{Op: Equal, OldLine: x + oldOffset, NewLine: y + newOffset, Text: old[x]})
+	}
+	edits := make([]Edit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
+
+// Hunk is a contiguous group of edits along with surrounding context.
+type Hunk struct {
+	// OldStart is the zero-based index of the first old line in the hunk.
+	OldStart int
+
+	// OldCount is the number of old lines covered by the hunk.
+	OldCount int
+
+	// NewStart is the zero-based index of the first new line in the hunk.
+	NewStart int
+
+	// NewCount is the number of new lines covered by the hunk.
+	NewCount int
+
+	// Edits are the edits in the hunk, including the context lines.
+	Edits []Edit
+}
+
+// Hunks groups the given edits into hunks with up to `context` lines of
+// unchanged text around each change.
+//
+// Changes separated by no more than `2*context` unchanged lines are
+// merged into a single hunk.
+func Hunks(edits []Edit, context int) []*Hunk {
+	if context < 0 {
+		context = 0
+	}
+	var hunks []*Hunk
+	var current *Hunk
+	lastChange := -1
+	for i, e := range edits {
+		if e.Op == Equal {
+			continue
+		}
+		if current != nil && i-lastChange-1 <= 2*context {
+			current.Edits = append(current.Edits, edits[lastChange+1:i]...)
+		} else {
+			if current != nil {
+				current.Edits = append(current.Edits, contextAfter(edits, lastChange, context)...)
+			}
+			start := i - context
+			if start < 0 {
+				start = 0
+			}
+			current = &Hunk{
+				Edits: append([]Edit(nil), edits[start:i]...),
+			}
+			hunks = append(hunks, current)
+		}
+		current.Edits = append(current.Edits, e)
+		lastChange = i
+	}
+	if current != nil {
+		current.Edits = append(current.Edits, contextAfter(edits, lastChange, context)...)
+	}
+	for _, h := range hunks {
+		h.computeRanges()
+	}
+	return hunks
+}
+
+func contextAfter(edits []Edit, lastChange, context int) []Edit {
+	end := lastChange + 1 + context
+	if end > len(edits) {
+		end = len(edits)
+	}
+	return edits[lastChange+1 : end]
+}
+
+func (h *Hunk) computeRanges() {
+	if len(h.Edits) == 0 {
+		return
+	}
+	first := h.Edits[0]
+	h.OldStart, h.NewStart = first.OldLine, first.NewLine
+	for _, e := range h.Edits {
+		switch e.Op {
+		case Equal:
+			h.OldCount++
+			h.NewCount++
+		case Delete:
+			h.OldCount++
+		case Insert:
+			h.NewCount++
+		}
+	}
+}
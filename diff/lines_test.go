@@ -0,0 +1,136 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func applyEdits(t *testing.T, old []string, edits []Edit) (reconstructedOld, reconstructedNew []string) {
+	t.Helper()
+	for _, e := range edits {
+		switch e.Op {
+		case Equal:
+			reconstructedOld = append(reconstructedOld, e.Text)
+			reconstructedNew = append(reconstructedNew, e.Text)
+		case Delete:
+			reconstructedOld = append(reconstructedOld, e.Text)
+		case Insert:
+			reconstructedNew = append(reconstructedNew, e.Text)
+		}
+	}
+	return reconstructedOld, reconstructedNew
+}
+
+func TestLines(t *testing.T) {
+	testCases := []struct {
+		Description string
+		Old         string
+		New         string
+		WantChanges int
+	}{
+		{
+			Description: "both empty",
+		},
+		{
+			Description: "identical",
+			Old:         "A\nB\nC\n",
+			New:         "A\nB\nC\n",
+		},
+		{
+			Description: "insert into empty",
+			New:         "A\nB\n",
+			WantChanges: 2,
+		},
+		{
+			Description: "delete everything",
+			Old:         "A\nB\n",
+			WantChanges: 2,
+		},
+		{
+			Description: "single insertion",
+			Old:         "A\nB\nC\n",
+			New:         "A\nX\nB\nC\n",
+			WantChanges: 1,
+		},
+		{
+			Description: "single replacement",
+			Old:         "A\nB\nC\n",
+			New:         "A\nX\nC\n",
+			WantChanges: 2,
+		},
+		{
+			Description: "classic myers example",
+			Old:         "A\nB\nC\nA\nB\nB\nA\n",
+			New:         "C\nB\nA\nB\nA\nC\n",
+			WantChanges: 5,
+		},
+		{
+			Description: "missing trailing newline",
+			Old:         "A\nB",
+			New:         "A\nB\n",
+			WantChanges: 2,
+		},
+	}
+	for _, testCase := range testCases {
+		old, new := SplitLines(testCase.Old), SplitLines(testCase.New)
+		edits := Lines(old, new)
+		gotOld, gotNew := applyEdits(t, old, edits)
+		if got, want := strings.Join(gotOld, ""), testCase.Old; got != want {
+			t.Errorf("unexpected old contents reconstructed for %q: got %q, want %q", testCase.Description, got, want)
+		}
+		if got, want := strings.Join(gotNew, ""), testCase.New; got != want {
+			t.Errorf("unexpected new contents reconstructed for %q: got %q, want %q", testCase.Description, got, want)
+		}
+		var changes int
+		for i, e := range edits {
+			if e.Op != Equal {
+				changes++
+			}
+			if e.Op != Insert && old[e.OldLine] != e.Text {
+				t.Errorf("unexpected old line index for edit %d of %q: %+v", i, testCase.Description, e)
+			}
+			if e.Op != Delete && new[e.NewLine] != e.Text {
+				t.Errorf("unexpected new line index for edit %d of %q: %+v", i, testCase.Description, e)
+			}
+		}
+		if got, want := changes, testCase.WantChanges; got != want {
+			t.Errorf("unexpected number of changes for %q: got %d, want %d", testCase.Description, got, want)
+		}
+	}
+}
+
+func TestHunks(t *testing.T) {
+	var old []string
+	for _, c := range "ABCDEFGHIJKLMNOPQRST" {
+		old = append(old, string(c)+"\n")
+	}
+	new := append([]string{}, old...)
+	new[1] = "x\n"
+	new[3] = "y\n"
+	new[15] = "z\n"
+
+	hunks := Hunks(Lines(old, new), 3)
+	if got, want := len(hunks), 2; got != want {
+		t.Fatalf("unexpected number of hunks: got %d, want %d: %+v", got, want, hunks)
+	}
+	if got, want := [4]int{hunks[0].OldStart, hunks[0].OldCount, hunks[0].NewStart, hunks[0].NewCount}, [4]int{0, 7, 0, 7}; got != want {
+		t.Errorf("unexpected range for the first hunk: got %v, want %v", got, want)
+	}
+	if got, want := [4]int{hunks[1].OldStart, hunks[1].OldCount, hunks[1].NewStart, hunks[1].NewCount}, [4]int{12, 7, 12, 7}; got != want {
+		t.Errorf("unexpected range for the second hunk: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package log defines methods for describing the history of a snapshot.
+package log
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/recursive-version-control-system/diff"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// BlameLine attributes a single line of a file to the snapshot that introduced it.
+type BlameLine struct {
+	// Entry is the log entry for the snapshot that introduced the line.
+	Entry *LogEntry
+
+	// OriginalLine is the one-based number of the line within the
+	// version of the file in `Entry`.
+	OriginalLine int
+
+	// Text is the contents of the line, including any trailing newline.
+	Text string
+}
+
+// pendingLine is a line of the blamed file that has been traced back to
+// a specific line of an ancestor, but not yet attributed.
+type pendingLine struct {
+	// index is the zero-based index of the line in the blamed file.
+	index int
+
+	// line is the zero-based index of the line in the ancestor.
+	line int
+}
+
+// blameLines reads the lines of the given log entry.
+//
+// The returned boolean reports whether or not the entry is a text file;
+// directories, symbolic links, and binary files have no lines to blame.
+func blameLines(ctx context.Context, s *storage.LocalFiles, e *LogEntry) ([]string, bool, error) {
+	if e.File.IsDir() || e.File.IsLink() {
+		return nil, false, nil
+	}
+	contents, binary, err := diff.ReadContents(ctx, s, e.File)
+	if err != nil {
+		return nil, false, fmt.Errorf("failure reading the contents of %q: %v", e.Hash, err)
+	}
+	if binary {
+		return nil, false, nil
+	}
+	return diff.SplitLines(string(contents)), true, nil
+}
+
+// Blame attributes each line of the given regular file snapshot to the
+// snapshot in its history that introduced that line.
+//
+// Each version of the file is compared against every one of its parents,
+// and a line that is unchanged from any parent is attributed to that
+// parent's version of the line, with earlier parents taking precedence.
+// Lines that do not appear in any parent are attributed to the version
+// itself.
+//
+// The history is traversed for at most `maxDepth` generations, or all
+// of it if `maxDepth` is negative. Lines that are unchanged from a
+// snapshot beyond that depth are attributed to its oldest included
+// descendant.
+func Blame(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, maxDepth int) ([]*BlameLine, error) {
+	entries, err := ReadLog(ctx, s, h, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	sorted, _ := topoSort(entries)
+	byHash := make(map[snapshot.Hash]*LogEntry)
+	for _, e := range sorted {
+		byHash[*e.Hash] = e
+	}
+	root := byHash[*h]
+	if root.File.IsDir() || root.File.IsLink() {
+		return nil, fmt.Errorf("%q is not the snapshot of a regular file", h)
+	}
+	lines, isText, err := blameLines(ctx, s, root)
+	if err != nil {
+		return nil, err
+	}
+	if !isText {
+		return nil, fmt.Errorf("%q is the snapshot of a binary file", h)
+	}
+	result := make([]*BlameLine, len(lines))
+	pending := make(map[snapshot.Hash][]pendingLine)
+	for i := range lines {
+		pending[*h] = append(pending[*h], pendingLine{index: i, line: i})
+	}
+	cache := map[snapshot.Hash][]string{*h: lines}
+	readLines := func(e *LogEntry) ([]string, bool, error) {
+		if lines, ok := cache[*e.Hash]; ok {
+			return lines, true, nil
+		}
+		lines, isText, err := blameLines(ctx, s, e)
+		if err != nil || !isText {
+			return nil, false, err
+		}
+		cache[*e.Hash] = lines
+		return lines, true, nil
+	}
+	for _, e := range sorted {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		remaining := pending[*e.Hash]
+		delete(pending, *e.Hash)
+		if len(remaining) == 0 {
+			continue
+		}
+		lines, _, err := readLines(e)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range e.File.Parents {
+			if len(remaining) == 0 {
+				break
+			}
+			parent, ok := byHash[*p]
+			if !ok {
+				continue
+			}
+			parentLines, isText, err := readLines(parent)
+			if err != nil {
+				return nil, err
+			} else if !isText {
+				continue
+			}
+			fromParent := make(map[int]int)
+			for _, edit := range diff.Lines(parentLines, lines) {
+				if edit.Op == diff.Equal {
+					fromParent[edit.NewLine] = edit.OldLine
+				}
+			}
+			var unmatched []pendingLine
+			for _, l := range remaining {
+				if parentLine, ok := fromParent[l.line]; ok {
+					pending[*p] = append(pending[*p], pendingLine{index: l.index, line: parentLine})
+				} else {
+					unmatched = append(unmatched, l)
+				}
+			}
+			remaining = unmatched
+		}
+		for _, l := range remaining {
+			result[l.index] = &BlameLine{
+				Entry:        e,
+				OriginalLine: l.line + 1,
+				Text:         lines[l.line],
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func TestBlame(t *testing.T) {
+	dir := t.TempDir()
+	s := &storage.LocalFiles{
+		ArchiveDir: filepath.Join(dir, "archive"),
+	}
+	ctx := context.Background()
+	storeFile := func(contents string, parents ...*snapshot.Hash) *snapshot.Hash {
+		contentsHash, err := s.StoreObject(ctx, int64(len(contents)), strings.NewReader(contents))
+		if err != nil {
+			t.Fatalf("failure storing the contents %q: %v", contents, err)
+		}
+		f := &snapshot.File{Mode: "-rw-------", Contents: contentsHash, Parents: parents}
+		h, err := s.StoreSnapshot(ctx, snapshot.Path(filepath.Join(dir, "file.txt")), f)
+		if err != nil {
+			t.Fatalf("failure storing the file snapshot: %v", err)
+		}
+		return h
+	}
+	v1 := storeFile("a\nb\nc\n")
+	v2 := storeFile("a\nB\nc\nd\n", v1)
+	other := storeFile("x\nc\n")
+	merged := storeFile("a\nB\nx\nc\nd\n", v2, other)
+
+	lines, err := Blame(ctx, s, merged, -1)
+	if err != nil {
+		t.Fatalf("failure blaming the merged snapshot: %v", err)
+	}
+	want := []struct {
+		h    *snapshot.Hash
+		line int
+		text string
+	}{
+		{v1, 1, "a\n"},
+		{v2, 2, "B\n"},
+		{other, 1, "x\n"},
+		{v1, 3, "c\n"},
+		{v2, 4, "d\n"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("unexpected number of blamed lines: got %d, want %d", len(lines), len(want))
+	}
+	for i, w := range want {
+		got := lines[i]
+		if !got.Entry.Hash.Equal(w.h) || got.OriginalLine != w.line || got.Text != w.text {
+			t.Errorf("unexpected blame for line %d: got (%q, %d, %q), want (%q, %d, %q)", i+1, got.Entry.Hash, got.OriginalLine, got.Text, w.h, w.line, w.text)
+		}
+	}
+
+	lines, err = Blame(ctx, s, merged, 1)
+	if err != nil {
+		t.Fatalf("failure blaming the merged snapshot with a limited depth: %v", err)
+	}
+	for i, got := range lines {
+		if !got.Entry.Hash.Equal(merged) || got.OriginalLine != i+1 {
+			t.Errorf("unexpected blame for line %d with a limited depth: got (%q, %d)", i+1, got.Entry.Hash, got.OriginalLine)
+		}
+	}
+
+	binary := "\x00\x01"
+	binaryContents, err := s.StoreObject(ctx, int64(len(binary)), strings.NewReader(binary))
+	if err != nil {
+		t.Fatalf("failure storing binary contents: %v", err)
+	}
+	binaryHash, err := s.StoreSnapshot(ctx, snapshot.Path(filepath.Join(dir, "file.txt")), &snapshot.File{Mode: "-rw-------", Contents: binaryContents})
+	if err != nil {
+		t.Fatalf("failure storing the binary file snapshot: %v", err)
+	}
+	if _, err := Blame(ctx, s, binaryHash, -1); err == nil {
+		t.Error("unexpected success blaming a binary file")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+)
+
+// GraphLine is a single line in the rendering of a history graph.
+type GraphLine struct {
+	// Lanes is the ASCII drawing of the lanes of history on this line.
+	Lanes string
+
+	// Entry is the log entry drawn on this line.
+	//
+	// This is nil for lines that only connect lanes between entries.
+	Entry *LogEntry
+
+	// Children is the number of the other entries in the graph that
+	// have this line's entry as a parent.
+	Children int
+}
+
+// IsMerge reports whether or not the line's entry has multiple parents.
+func (l *GraphLine) IsMerge() bool {
+	return l.Entry != nil && len(l.Entry.File.Parents) > 1
+}
+
+// IsBranchPoint reports whether or not the line's entry is the parent of
+// multiple other entries in the graph.
+func (l *GraphLine) IsBranchPoint() bool {
+	return l.Entry != nil && l.Children > 1
+}
+
+// topoSort orders the given entries so that every entry comes before all
+// of its parents, removing any duplicates.
+//
+// Entries that are not constrained by that requirement keep their
+// relative order from the input.
+func topoSort(entries []*LogEntry) ([]*LogEntry, map[snapshot.Hash]int) {
+	index := make(map[snapshot.Hash]int)
+	var unique []*LogEntry
+	for _, e := range entries {
+		if _, ok := index[*e.Hash]; ok {
+			continue
+		}
+		index[*e.Hash] = len(unique)
+		unique = append(unique, e)
+	}
+	children := make(map[snapshot.Hash]int)
+	for _, e := range unique {
+		for _, p := range parentsInGraph(e, index) {
+			children[p]++
+		}
+	}
+	remaining := make(map[snapshot.Hash]int)
+	var ready []int
+	for i, e := range unique {
+		remaining[*e.Hash] = children[*e.Hash]
+		if children[*e.Hash] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	var sorted []*LogEntry
+	for len(ready) > 0 {
+		e := unique[ready[0]]
+		ready = ready[1:]
+		sorted = append(sorted, e)
+		for _, p := range parentsInGraph(e, index) {
+			remaining[p]--
+			if remaining[p] == 0 {
+				i := index[p]
+				pos := sort.SearchInts(ready, i)
+				ready = append(ready[:pos], append([]int{i}, ready[pos:]...)...)
+			}
+		}
+	}
+	return sorted, children
+}
+
+// parentsInGraph returns the distinct parents of the given entry that are
+// included in the graph.
+func parentsInGraph(e *LogEntry, included map[snapshot.Hash]int) []snapshot.Hash {
+	var result []snapshot.Hash
+	seen := make(map[snapshot.Hash]struct{})
+	for _, p := range e.File.Parents {
+		if _, ok := included[*p]; !ok {
+			continue
+		}
+		if _, ok := seen[*p]; ok {
+			continue
+		}
+		seen[*p] = struct{}{}
+		result = append(result, *p)
+	}
+	return result
+}
+
+// track is a single lane moving between two columns of the graph.
+type track struct {
+	from, to int
+}
+
+// drawTransition returns the lines needed to move each of the given tracks
+// from its starting column to its target column, one column per line.
+func drawTransition(tracks []*track) []string {
+	var lines []string
+	for {
+		width := 0
+		moving := false
+		for _, t := range tracks {
+			if t.from != t.to {
+				moving = true
+			}
+			if w := 2*t.from + 2; w > width {
+				width = w
+			}
+		}
+		if !moving {
+			return lines
+		}
+		line := []byte(strings.Repeat(" ", width))
+		for _, t := range tracks {
+			switch {
+			case t.from == t.to:
+				line[2*t.from] = '|'
+			case t.to < t.from:
+				if line[2*t.from-1] == ' ' {
+					line[2*t.from-1] = '/'
+				}
+				t.from--
+			default:
+				if line[2*t.from+1] == ' ' {
+					line[2*t.from+1] = '\\'
+				}
+				t.from++
+			}
+		}
+		lines = append(lines, strings.TrimRight(string(line), " "))
+	}
+}
+
+// Graph lays out the given log entries as a graph of their parent links.
+//
+// The entries are drawn in lanes, similarly to `git log --graph`, with
+// each entry drawn before all of its parents. Parents that are not
+// included in `entries` are omitted from the graph.
+func Graph(entries []*LogEntry) []*GraphLine {
+	sorted, children := topoSort(entries)
+	included := make(map[snapshot.Hash]int)
+	for i, e := range sorted {
+		included[*e.Hash] = i
+	}
+	var lines []*GraphLine
+	var columns []snapshot.Hash
+	columnOf := func(cols []snapshot.Hash, h snapshot.Hash) int {
+		for i, col := range cols {
+			if col == h {
+				return i
+			}
+		}
+		return -1
+	}
+	for _, e := range sorted {
+		c := columnOf(columns, *e.Hash)
+		if c < 0 {
+			columns = append(columns, *e.Hash)
+			c = len(columns) - 1
+		}
+		lanes := make([]string, len(columns))
+		for i := range columns {
+			lanes[i] = "|"
+		}
+		lanes[c] = "*"
+		lines = append(lines, &GraphLine{
+			Lanes:    strings.Join(lanes, " "),
+			Entry:    e,
+			Children: children[*e.Hash],
+		})
+
+		// Replace the entry's column with the columns for any of
+		// its parents that are not already in some other column.
+		var newParents []snapshot.Hash
+		parents := parentsInGraph(e, included)
+		for _, p := range parents {
+			if columnOf(columns, p) < 0 {
+				newParents = append(newParents, p)
+			}
+		}
+		var next []snapshot.Hash
+		next = append(next, columns[:c]...)
+		next = append(next, newParents...)
+		next = append(next, columns[c+1:]...)
+
+		var tracks []*track
+		for i, col := range columns {
+			if i != c {
+				tracks = append(tracks, &track{from: i, to: columnOf(next, col)})
+			}
+		}
+		for _, p := range parents {
+			tracks = append(tracks, &track{from: c, to: columnOf(next, p)})
+		}
+		for _, transition := range drawTransition(tracks) {
+			lines = append(lines, &GraphLine{Lanes: transition})
+		}
+		columns = next
+	}
+	return lines
+}
+
+// RenderGraph renders the given log entries as a text graph of their
+// parent links, with one line per entry plus the lines connecting them.
+//
+// Each entry is described by its hash, followed by any of the given
+// `labels` for it (such as the paths or identities that point at it),
+// whether it is a merge or branch point, and the first line of its
+// annotation message.
+func RenderGraph(entries []*LogEntry, labels map[snapshot.Hash][]string) []string {
+	var result []string
+	for _, line := range Graph(entries) {
+		if line.Entry == nil {
+			result = append(result, line.Lanes)
+			continue
+		}
+		description := []string{line.Lanes, line.Entry.Hash.String()}
+		if entryLabels := labels[*line.Entry.Hash]; len(entryLabels) > 0 {
+			description = append(description, fmt.Sprintf("(%s)", strings.Join(entryLabels, ", ")))
+		}
+		if line.IsMerge() {
+			description = append(description, "[merge]")
+		}
+		if line.IsBranchPoint() {
+			description = append(description, "[branch point]")
+		}
+		if a := line.Entry.Annotation; a != nil {
+			if message := strings.TrimSpace(a.Message); len(message) > 0 {
+				description = append(description, strings.SplitN(message, "\n", 2)[0])
+			}
+		}
+		result = append(result, strings.Join(description, " "))
+	}
+	return result
+}
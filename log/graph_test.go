@@ -0,0 +1,72 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+)
+
+func TestRenderGraph(t *testing.T) {
+	hashes := make(map[string]*snapshot.Hash)
+	entry := func(name string, parents ...string) *LogEntry {
+		h, err := snapshot.NewHash(strings.NewReader(name))
+		if err != nil {
+			t.Fatalf("failure hashing %q: %v", name, err)
+		}
+		hashes[name] = h
+		f := &snapshot.File{Mode: "drwx------"}
+		for _, p := range parents {
+			f.Parents = append(f.Parents, hashes[p])
+		}
+		return &LogEntry{Hash: h, File: f}
+	}
+	root := entry("root")
+	left := entry("left", "root")
+	right := entry("right", "root")
+	merged := entry("merged", "left", "right")
+	merged.Annotation = &snapshot.Annotation{Message: "merge the two sides\n\nwith details"}
+
+	// The entries are passed in out of order to verify that each
+	// entry is drawn before its parents.
+	got := RenderGraph([]*LogEntry{merged, root, left, right, root}, map[snapshot.Hash][]string{
+		*hashes["merged"]: {"/some/path"},
+		*hashes["right"]:  {"example::user"},
+	})
+	want := []string{
+		fmt.Sprintf("* %s (/some/path) [merge] merge the two sides", hashes["merged"]),
+		"|\\",
+		fmt.Sprintf("* | %s", hashes["left"]),
+		fmt.Sprintf("| * %s (example::user)", hashes["right"]),
+		"|/",
+		fmt.Sprintf("* %s [branch point]", hashes["root"]),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected graph rendering:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Parents outside of the given entries end their lanes.
+	got = RenderGraph([]*LogEntry{merged, left}, nil)
+	want = []string{
+		fmt.Sprintf("* %s [merge] merge the two sides", hashes["merged"]),
+		fmt.Sprintf("* %s", hashes["left"]),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected rendering of a partial graph:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"sort"
+	"strings"
 	"syscall"
 
 	"github.com/google/recursive-version-control-system/snapshot"
@@ -34,6 +35,9 @@ type LogEntry struct {
 	// File is the file snapshot
 	File *snapshot.File
 
+	// Annotation is the annotation linked to the file snapshot, if any.
+	Annotation *snapshot.Annotation
+
 	// summary is a list of strings that describe what changed
 	// between the file snapshot and its first parent.
 	//
@@ -97,7 +101,7 @@ func (e *LogEntry) NestedContents(ctx context.Context, s *storage.LocalFiles, in
 	if err := dirContents(ctx, s, e.Hash, e.File, "", includeDirectories, contentsMap); err != nil {
 		return nil, nil, fmt.Errorf("failure reading the nested contents for %q: %v", e.Hash, err)
 	}
-	for path, _ := range contentsMap {
+	for path := range contentsMap {
 		paths = append(paths, path)
 	}
 	sort.Strings(paths)
@@ -124,14 +128,61 @@ func insertLine(insertedPath string, insertedHash *snapshot.Hash) string {
 	return fmt.Sprintf("\033[32m%s\033[0m", coreText)
 }
 
-func describeChanged(paths, previousPaths []string, contents, previousContents map[string]*snapshot.Hash) []string {
-	changes := []string{}
+func describeAnnotation(a *snapshot.Annotation) []string {
+	if a == nil {
+		return nil
+	}
+	var lines []string
+	if len(a.Author) > 0 {
+		lines = append(lines, fmt.Sprintf("  Author: %s", a.Author))
+	}
+	if !a.Time.IsZero() {
+		lines = append(lines, fmt.Sprintf("  Date:   %s", a.Time.Local().Format("Mon Jan 2 15:04:05 2006 -0700")))
+	}
+	if message := strings.TrimRight(a.Message, "\n"); len(message) > 0 {
+		lines = append(lines, "")
+		for _, line := range strings.Split(message, "\n") {
+			lines = append(lines, "    "+line)
+		}
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+// PathChange describes a nested file whose snapshot differs between a
+// log entry and its first parent.
+type PathChange struct {
+	// Path is the path of the nested file, relative to the log entry's file.
+	Path string
+
+	// Old is the snapshot of the nested file in the first parent, or nil
+	// if the nested file was added.
+	Old *snapshot.Hash
+
+	// New is the snapshot of the nested file in the log entry, or nil
+	// if the nested file was deleted.
+	New *snapshot.Hash
+}
+
+func (c *PathChange) describe() []string {
+	var lines []string
+	if c.Old != nil {
+		lines = append(lines, deleteLine(c.Path, c.Old))
+	}
+	if c.New != nil {
+		lines = append(lines, insertLine(c.Path, c.New))
+	}
+	return lines
+}
+
+func describeChanged(paths, previousPaths []string, contents, previousContents map[string]*snapshot.Hash) []*PathChange {
+	changes := []*PathChange{}
 	for _, p := range paths {
 		h := contents[p]
 		for len(previousPaths) > 0 && previousPaths[0] < p {
 			deletedPath := previousPaths[0]
 			previousPaths = previousPaths[1:]
-			changes = append(changes, deleteLine(deletedPath, previousContents[deletedPath]))
+			changes = append(changes, &PathChange{Path: deletedPath, Old: previousContents[deletedPath]})
 		}
 		var previousHash *snapshot.Hash
 		if len(previousPaths) > 0 && previousPaths[0] == p {
@@ -141,19 +192,23 @@ func describeChanged(paths, previousPaths []string, contents, previousContents m
 		if previousHash.Equal(h) {
 			continue
 		}
-		if previousHash != nil {
-			changes = append(changes, deleteLine(p, previousHash))
-		}
-		changes = append(changes, insertLine(p, h))
+		changes = append(changes, &PathChange{Path: p, Old: previousHash, New: h})
 	}
 	for _, deletedPath := range previousPaths {
-		previousHash := previousContents[deletedPath]
-		changes = append(changes, deleteLine(deletedPath, previousHash))
+		changes = append(changes, &PathChange{Path: deletedPath, Old: previousContents[deletedPath]})
 	}
 	return changes
 }
 
-func SummarizeLog(ctx context.Context, s *storage.LocalFiles, entries []*LogEntry) (map[snapshot.Hash][]string, error) {
+// LogChanges computes the nested files changed by each of the given log
+// entries, relative to the first parent of each entry.
+//
+// The returned map only has values for entries that are snapshots of
+// directories, and the changes for each entry are sorted by path.
+//
+// Parents that are not themselves included in `entries` are treated
+// as if they were empty.
+func LogChanges(ctx context.Context, s *storage.LocalFiles, entries []*LogEntry) (map[snapshot.Hash][]*PathChange, error) {
 	pathsMap := make(map[snapshot.Hash][]string)
 	contentsMap := make(map[snapshot.Hash]map[string]*snapshot.Hash)
 	for _, e := range entries {
@@ -166,7 +221,7 @@ func SummarizeLog(ctx context.Context, s *storage.LocalFiles, entries []*LogEntr
 			contentsMap[*e.Hash] = contents
 		}
 	}
-	result := make(map[snapshot.Hash][]string)
+	result := make(map[snapshot.Hash][]*PathChange)
 	for _, e := range entries {
 		var prevPaths []string
 		var prevContents map[string]*snapshot.Hash
@@ -175,36 +230,88 @@ func SummarizeLog(ctx context.Context, s *storage.LocalFiles, entries []*LogEntr
 			prevPaths = pathsMap[*firstParent]
 			prevContents = contentsMap[*firstParent]
 		}
-		summary := []string{e.Hash.String()}
 		contents, contentsOk := contentsMap[*e.Hash]
 		paths, pathsOk := pathsMap[*e.Hash]
 		if contentsOk && pathsOk {
-			summary = append(summary, describeChanged(paths, prevPaths, contents, prevContents)...)
+			result[*e.Hash] = describeChanged(paths, prevPaths, contents, prevContents)
+		}
+	}
+	return result, nil
+}
+
+// SummaryOptions configures the summaries generated by `SummarizeLog`.
+type SummaryOptions struct {
+	// Stats specifies that, rather than listing the changed files, the
+	// summary should list the number of lines added and removed for
+	// each changed text file, the change in size of each changed binary
+	// file, and the totals of those.
+	//
+	// These are computed against every parent of each entry (see the
+	// `LogStats` method), whereas the list of changed files is only
+	// computed against the first parent.
+	Stats bool
+}
+
+// SummarizeLog generates a human readable summary of each of the given log entries.
+//
+// The `opts` argument may be nil, in which case the default options are used.
+func SummarizeLog(ctx context.Context, s *storage.LocalFiles, entries []*LogEntry, opts *SummaryOptions) (map[snapshot.Hash][]string, error) {
+	if opts == nil {
+		opts = &SummaryOptions{}
+	}
+	var changes map[snapshot.Hash][]*PathChange
+	var stats map[snapshot.Hash][]*ParentStat
+	var err error
+	if opts.Stats {
+		stats, err = LogStats(ctx, s, entries)
+	} else {
+		changes, err = LogChanges(ctx, s, entries)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[snapshot.Hash][]string)
+	for _, e := range entries {
+		summary := []string{e.Hash.String()}
+		summary = append(summary, describeAnnotation(e.Annotation)...)
+		for _, c := range changes[*e.Hash] {
+			summary = append(summary, c.describe()...)
+		}
+		for _, p := range stats[*e.Hash] {
+			summary = append(summary, p.describe()...)
 		}
 		result[*e.Hash] = summary
 	}
 	return result, nil
 }
 
-func ReadLog(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, maxDepth int) ([]*LogEntry, error) {
-	visited := make(map[snapshot.Hash]*snapshot.File)
+// ReadAncestors returns the hashes of the given snapshot and its ancestors
+// in breadth-first order, without duplicates.
+//
+// The traversal stops after `maxDepth` generations of parents, or continues
+// through the entire history if `maxDepth` is negative.
+//
+// The parents of each snapshot are read from the ancestry index, so this
+// does not have to read and parse the snapshots themselves.
+func ReadAncestors(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, maxDepth int) ([]*snapshot.Hash, error) {
+	visited := map[snapshot.Hash]struct{}{*h: {}}
 	queue := []*snapshot.Hash{h}
-	result := []*LogEntry{}
+	var result []*snapshot.Hash
 	var depth int
 	for len(queue) > 0 && depth != maxDepth {
 		var next []*snapshot.Hash
 		for _, h := range queue {
-			f, err := s.ReadSnapshot(ctx, h)
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			e, err := s.Ancestry(ctx, h)
 			if err != nil {
-				return nil, fmt.Errorf("failure reading the snapshot for %q: %v", h, err)
+				return nil, fmt.Errorf("failure reading the ancestry of %q: %v", h, err)
 			}
-			visited[*h] = f
-			result = append(result, &LogEntry{
-				Hash: h,
-				File: f,
-			})
-			for _, p := range f.Parents {
+			result = append(result, h)
+			for _, p := range e.Parents {
 				if _, ok := visited[*p]; !ok {
+					visited[*p] = struct{}{}
 					next = append(next, p)
 				}
 			}
@@ -214,3 +321,29 @@ func ReadLog(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, maxDe
 	}
 	return result, nil
 }
+
+// ReadLog reads the log entries for the given snapshot and its ancestors,
+// in the same order as the `ReadAncestors` method.
+func ReadLog(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, maxDepth int) ([]*LogEntry, error) {
+	hashes, err := ReadAncestors(ctx, s, h, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	result := []*LogEntry{}
+	for _, h := range hashes {
+		f, err := s.ReadSnapshot(ctx, h)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading the snapshot for %q: %v", h, err)
+		}
+		_, a, err := s.FindAnnotation(ctx, h)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading the annotation for %q: %v", h, err)
+		}
+		result = append(result, &LogEntry{
+			Hash:       h,
+			File:       f,
+			Annotation: a,
+		})
+	}
+	return result, nil
+}
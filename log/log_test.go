@@ -15,6 +15,7 @@
 package log
 
 import (
+	"bytes"
 	"context"
 	"os"
 	"path/filepath"
@@ -82,3 +83,186 @@ func TestLog(t *testing.T) {
 		t.Errorf("unexpected second log entry hash with a depth of -1: %+v", entries[0].Hash)
 	}
 }
+
+func TestLogChanges(t *testing.T) {
+	dir := t.TempDir()
+	s := &storage.LocalFiles{
+		ArchiveDir: filepath.Join(dir, "archive"),
+	}
+	workingDir := filepath.Join(dir, "working-dir")
+	if err := os.MkdirAll(workingDir, os.FileMode(0700)); err != nil {
+		t.Fatalf("failure creating the temporary working dir: %v", err)
+	}
+	ctx := context.Background()
+
+	keptFile := filepath.Join(workingDir, "kept.txt")
+	removedFile := filepath.Join(workingDir, "removed.txt")
+	for _, f := range []string{keptFile, removedFile} {
+		if err := os.WriteFile(f, []byte("Hello, World!"), 0700); err != nil {
+			t.Fatalf("failure creating the example file %q: %v", f, err)
+		}
+	}
+	if _, _, err := snapshot.Current(ctx, s, snapshot.Path(workingDir)); err != nil {
+		t.Fatalf("failure creating the initial snapshot: %v", err)
+	}
+	oldKept, _, err := s.FindSnapshot(ctx, snapshot.Path(keptFile))
+	if err != nil {
+		t.Fatalf("failure looking up the snapshot of %q: %v", keptFile, err)
+	}
+	if err := os.Remove(removedFile); err != nil {
+		t.Fatalf("failure removing %q: %v", removedFile, err)
+	}
+	if err := os.WriteFile(keptFile, []byte("Goodbye, World!"), 0700); err != nil {
+		t.Fatalf("failure updating %q: %v", keptFile, err)
+	}
+	h, _, err := snapshot.Current(ctx, s, snapshot.Path(workingDir))
+	if err != nil {
+		t.Fatalf("failure creating the updated snapshot: %v", err)
+	}
+	newKept, _, err := s.FindSnapshot(ctx, snapshot.Path(keptFile))
+	if err != nil {
+		t.Fatalf("failure looking up the snapshot of %q: %v", keptFile, err)
+	}
+
+	entries, err := ReadLog(ctx, s, h, -1)
+	if err != nil {
+		t.Fatalf("failure reading the log: %v", err)
+	}
+	changes, err := LogChanges(ctx, s, entries)
+	if err != nil {
+		t.Fatalf("failure computing the log changes: %v", err)
+	}
+	got := changes[*h]
+	if len(got) != 2 {
+		t.Fatalf("unexpected changes for %q: %+v", h, got)
+	}
+	if got[0].Path != "kept.txt" || !got[0].Old.Equal(oldKept) || !got[0].New.Equal(newKept) {
+		t.Errorf("unexpected change for the modified file: %+v", got[0])
+	}
+	if got[1].Path != "removed.txt" || got[1].Old == nil || got[1].New != nil {
+		t.Errorf("unexpected change for the removed file: %+v", got[1])
+	}
+}
+
+func TestReadSubpathLog(t *testing.T) {
+	dir := t.TempDir()
+	s := &storage.LocalFiles{
+		ArchiveDir: filepath.Join(dir, "archive"),
+	}
+	workingDir := filepath.Join(dir, "working-dir")
+	if err := os.MkdirAll(workingDir, os.FileMode(0700)); err != nil {
+		t.Fatalf("failure creating the temporary working dir: %v", err)
+	}
+	ctx := context.Background()
+	takeSnapshot := func(files map[string]string) *snapshot.Hash {
+		for name, contents := range files {
+			p := filepath.Join(workingDir, name)
+			if len(contents) == 0 {
+				if err := os.Remove(p); err != nil {
+					t.Fatalf("failure removing %q: %v", p, err)
+				}
+			} else if err := os.WriteFile(p, []byte(contents), 0700); err != nil {
+				t.Fatalf("failure writing %q: %v", p, err)
+			}
+		}
+		h, _, err := snapshot.Current(ctx, s, snapshot.Path(workingDir))
+		if err != nil {
+			t.Fatalf("failure snapshotting the working dir: %v", err)
+		}
+		return h
+	}
+	added := takeSnapshot(map[string]string{"a.txt": "first", "other.txt": "other"})
+	takeSnapshot(map[string]string{"other.txt": "unrelated change"})
+	modified := takeSnapshot(map[string]string{"a.txt": "second"})
+	renamed := takeSnapshot(map[string]string{"a.txt": "", "b.txt": "second"})
+
+	entries, err := ReadSubpathLog(ctx, s, renamed, "b.txt", -1, false)
+	if err != nil {
+		t.Fatalf("failure reading the subpath log: %v", err)
+	}
+	if len(entries) != 1 || !entries[0].Hash.Equal(renamed) || entries[0].Previous != nil {
+		t.Errorf("unexpected subpath log entries without following renames: %+v", entries)
+	}
+
+	entries, err = ReadSubpathLog(ctx, s, renamed, "b.txt", -1, true)
+	if err != nil {
+		t.Fatalf("failure reading the subpath log while following renames: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("unexpected subpath log entries while following renames: %+v", entries)
+	}
+	if got := entries[0]; !got.Hash.Equal(renamed) || !got.IsRenamed() || got.PreviousPath != "a.txt" || got.Path != "b.txt" {
+		t.Errorf("unexpected entry for the rename: %+v", got)
+	}
+	if got := entries[1]; !got.Hash.Equal(modified) || got.IsRenamed() || got.Path != "a.txt" || got.Previous == nil {
+		t.Errorf("unexpected entry for the modification: %+v", got)
+	}
+	if got := entries[2]; !got.Hash.Equal(added) || got.Previous != nil || got.Nested == nil {
+		t.Errorf("unexpected entry for the addition: %+v", got)
+	}
+}
+
+func TestLogStats(t *testing.T) {
+	dir := t.TempDir()
+	s := &storage.LocalFiles{
+		ArchiveDir: filepath.Join(dir, "archive"),
+	}
+	workingDir := filepath.Join(dir, "working-dir")
+	if err := os.MkdirAll(workingDir, os.FileMode(0700)); err != nil {
+		t.Fatalf("failure creating the temporary working dir: %v", err)
+	}
+	ctx := context.Background()
+	textFile := filepath.Join(workingDir, "text.txt")
+	binaryFile := filepath.Join(workingDir, "binary.dat")
+	if err := os.WriteFile(textFile, []byte("one\ntwo\nthree\n"), 0700); err != nil {
+		t.Fatalf("failure writing %q: %v", textFile, err)
+	}
+	h1, _, err := snapshot.Current(ctx, s, snapshot.Path(workingDir))
+	if err != nil {
+		t.Fatalf("failure creating the initial snapshot: %v", err)
+	}
+	if err := os.WriteFile(textFile, []byte("one\n2\nthree\nfour\n"), 0700); err != nil {
+		t.Fatalf("failure updating %q: %v", textFile, err)
+	}
+	if err := os.WriteFile(binaryFile, []byte{0, 1, 2, 3}, 0700); err != nil {
+		t.Fatalf("failure writing %q: %v", binaryFile, err)
+	}
+	h2, f2, err := snapshot.Current(ctx, s, snapshot.Path(workingDir))
+	if err != nil {
+		t.Fatalf("failure creating the updated snapshot: %v", err)
+	}
+	mergedBytes := []byte((&snapshot.File{Mode: f2.Mode, Contents: f2.Contents, Parents: []*snapshot.Hash{h2, h1}}).String())
+	merged, err := s.StoreObject(ctx, int64(len(mergedBytes)), bytes.NewReader(mergedBytes))
+	if err != nil {
+		t.Fatalf("failure storing the merged snapshot: %v", err)
+	}
+
+	entries, err := ReadLog(ctx, s, merged, -1)
+	if err != nil {
+		t.Fatalf("failure reading the log: %v", err)
+	}
+	stats, err := LogStats(ctx, s, entries)
+	if err != nil {
+		t.Fatalf("failure computing the log stats: %v", err)
+	}
+	mergedStats := stats[*merged]
+	if len(mergedStats) != 2 {
+		t.Fatalf("unexpected stats for the merged snapshot: %+v", mergedStats)
+	}
+	if got := mergedStats[0]; !got.Parent.Equal(h2) || len(got.Files) != 0 {
+		t.Errorf("unexpected stats against the first parent of the merge: %+v", got)
+	}
+	got := mergedStats[1]
+	if !got.Parent.Equal(h1) || len(got.Files) != 2 || got.Insertions != 2 || got.Deletions != 1 || got.ByteDelta != 7 {
+		t.Fatalf("unexpected stats against the second parent of the merge: %+v", got)
+	}
+	if f := got.Files[0]; f.Path != "binary.dat" || !f.Binary || f.ByteDelta != 4 {
+		t.Errorf("unexpected stats for the binary file: %+v", f)
+	}
+	if f := got.Files[1]; f.Path != "text.txt" || f.Binary || f.Insertions != 2 || f.Deletions != 1 || f.ByteDelta != 3 {
+		t.Errorf("unexpected stats for the text file: %+v", f)
+	}
+	if initial := stats[*h1]; len(initial) != 1 || initial[0].Parent != nil || initial[0].Insertions != 3 {
+		t.Errorf("unexpected stats for the initial snapshot: %+v", initial)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/recursive-version-control-system/diff"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// FileStat summarizes the size of the change to a single nested file.
+type FileStat struct {
+	// Path is the path of the nested file, relative to the log entry's file.
+	//
+	// For log entries that are not directories, this is ".".
+	Path string
+
+	// Insertions is the number of lines added to a text file.
+	Insertions int
+
+	// Deletions is the number of lines removed from a text file.
+	Deletions int
+
+	// Binary reports whether or not either version of the file is binary,
+	// in which case the line counts are zero.
+	Binary bool
+
+	// ByteDelta is the change in the size of the file, in bytes.
+	ByteDelta int64
+}
+
+// ParentStat summarizes the changes between a log entry and one of its parents.
+type ParentStat struct {
+	// Parent is the parent that the entry is compared against, or nil
+	// for entries without any parents.
+	Parent *snapshot.Hash
+
+	// Files holds the stats for each changed file, sorted by path.
+	Files []*FileStat
+
+	// Insertions is the total number of lines added to text files.
+	Insertions int
+
+	// Deletions is the total number of lines removed from text files.
+	Deletions int
+
+	// ByteDelta is the total change in the size of all files, in bytes.
+	ByteDelta int64
+}
+
+// statsReader computes stats while reusing the log entry for each
+// snapshot, since those cache their nested contents and are shared
+// between entries and their parents.
+type statsReader struct {
+	s       *storage.LocalFiles
+	entries map[snapshot.Hash]*LogEntry
+}
+
+func (r *statsReader) entry(ctx context.Context, h *snapshot.Hash) (*LogEntry, error) {
+	if e, ok := r.entries[*h]; ok {
+		return e, nil
+	}
+	f, err := r.s.ReadSnapshot(ctx, h)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the snapshot %q: %v", h, err)
+	}
+	e := &LogEntry{Hash: h, File: f}
+	r.entries[*h] = e
+	return e, nil
+}
+
+// nestedFiles returns the nested files of the given entry, or the entry
+// itself (under the path ".") if it is not a directory.
+func (r *statsReader) nestedFiles(ctx context.Context, e *LogEntry) ([]string, map[string]*snapshot.Hash, error) {
+	if e == nil {
+		return nil, nil, nil
+	}
+	if !e.File.IsDir() {
+		return []string{"."}, map[string]*snapshot.Hash{".": e.Hash}, nil
+	}
+	return e.NestedContents(ctx, r.s, false)
+}
+
+func (r *statsReader) readContents(ctx context.Context, h *snapshot.Hash) (contents []byte, binary bool, err error) {
+	if h == nil {
+		return nil, false, nil
+	}
+	f, err := r.s.ReadSnapshot(ctx, h)
+	if err != nil {
+		return nil, false, fmt.Errorf("failure reading the snapshot %q: %v", h, err)
+	}
+	return diff.ReadContents(ctx, r.s, f)
+}
+
+func (r *statsReader) fileStat(ctx context.Context, c *PathChange) (*FileStat, error) {
+	oldContents, oldBinary, err := r.readContents(ctx, c.Old)
+	if err != nil {
+		return nil, err
+	}
+	newContents, newBinary, err := r.readContents(ctx, c.New)
+	if err != nil {
+		return nil, err
+	}
+	stat := &FileStat{
+		Path:      c.Path,
+		Binary:    oldBinary || newBinary,
+		ByteDelta: int64(len(newContents)) - int64(len(oldContents)),
+	}
+	if stat.Binary {
+		return stat, nil
+	}
+	for _, edit := range diff.Lines(diff.SplitLines(string(oldContents)), diff.SplitLines(string(newContents))) {
+		switch edit.Op {
+		case diff.Insert:
+			stat.Insertions++
+		case diff.Delete:
+			stat.Deletions++
+		}
+	}
+	return stat, nil
+}
+
+func (r *statsReader) parentStat(ctx context.Context, e *LogEntry, parent *snapshot.Hash) (*ParentStat, error) {
+	var parentEntry *LogEntry
+	if parent != nil {
+		var err error
+		parentEntry, err = r.entry(ctx, parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	paths, contents, err := r.nestedFiles(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	parentPaths, parentContents, err := r.nestedFiles(ctx, parentEntry)
+	if err != nil {
+		return nil, err
+	}
+	result := &ParentStat{Parent: parent, Files: []*FileStat{}}
+	for _, c := range describeChanged(paths, parentPaths, contents, parentContents) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		stat, err := r.fileStat(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("failure computing the changes to %q: %v", c.Path, err)
+		}
+		result.Files = append(result.Files, stat)
+		result.Insertions += stat.Insertions
+		result.Deletions += stat.Deletions
+		result.ByteDelta += stat.ByteDelta
+	}
+	return result, nil
+}
+
+// LogStats computes the size of the changes made by each of the given log entries.
+//
+// Each entry is compared against every one of its parents, so merge
+// snapshots have one `ParentStat` per parent. Entries without any parents
+// are compared against an empty snapshot.
+func LogStats(ctx context.Context, s *storage.LocalFiles, entries []*LogEntry) (map[snapshot.Hash][]*ParentStat, error) {
+	r := &statsReader{
+		s:       s,
+		entries: make(map[snapshot.Hash]*LogEntry),
+	}
+	for _, e := range entries {
+		r.entries[*e.Hash] = e
+	}
+	result := make(map[snapshot.Hash][]*ParentStat)
+	for _, e := range entries {
+		parents := e.File.Parents
+		if len(parents) == 0 {
+			parents = []*snapshot.Hash{nil}
+		}
+		for _, parent := range parents {
+			stat, err := r.parentStat(ctx, e, parent)
+			if err != nil {
+				return nil, fmt.Errorf("failure comparing %q against its parent %q: %v", e.Hash, parent, err)
+			}
+			result[*e.Hash] = append(result[*e.Hash], stat)
+		}
+	}
+	return result, nil
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func (p *ParentStat) describe() []string {
+	parentDescription := "an empty snapshot"
+	if p.Parent != nil {
+		parentDescription = p.Parent.String()
+	}
+	lines := []string{fmt.Sprintf("  %s changed, %s(+), %s(-), %+d bytes compared to %s", plural(len(p.Files), "file"), plural(p.Insertions, "insertion"), plural(p.Deletions, "deletion"), p.ByteDelta, parentDescription)}
+	for _, f := range p.Files {
+		if f.Binary {
+			lines = append(lines, fmt.Sprintf("    %s | binary, %+d bytes", f.Path, f.ByteDelta))
+		} else {
+			lines = append(lines, fmt.Sprintf("    %s | +%d -%d", f.Path, f.Insertions, f.Deletions))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// SubpathEntry describes a change to a file nested within the history of
+// a directory snapshot.
+type SubpathEntry struct {
+	// LogEntry is the entry for the directory snapshot that changed the nested file.
+	*LogEntry
+
+	// Path is the path of the nested file within the directory snapshot.
+	Path snapshot.Path
+
+	// Nested is the snapshot of the nested file, or nil if the nested
+	// file was deleted.
+	Nested *snapshot.Hash
+
+	// PreviousPath is the path of the nested file within the first parent
+	// of the directory snapshot.
+	//
+	// This only differs from `Path` if the nested file was renamed.
+	PreviousPath snapshot.Path
+
+	// Previous is the snapshot of the nested file in the first parent of
+	// the directory snapshot, or nil if the nested file was added.
+	Previous *snapshot.Hash
+}
+
+// IsRenamed reports whether or not the nested file was renamed by this entry.
+func (e *SubpathEntry) IsRenamed() bool {
+	return e.Previous != nil && e.Nested != nil && e.Path != e.PreviousPath
+}
+
+// Changes returns the changes made to the nested file by this entry.
+func (e *SubpathEntry) Changes() []*PathChange {
+	if e.IsRenamed() {
+		return []*PathChange{
+			{Path: string(e.PreviousPath), Old: e.Previous},
+			{Path: string(e.Path), New: e.Nested},
+		}
+	}
+	return []*PathChange{{Path: string(e.Path), Old: e.Previous, New: e.Nested}}
+}
+
+// Summary returns a list of strings that describe the entry and the
+// change it made to the nested file.
+func (e *SubpathEntry) Summary() []string {
+	summary := []string{e.Hash.String()}
+	summary = append(summary, describeAnnotation(e.Annotation)...)
+	for _, c := range e.Changes() {
+		summary = append(summary, c.describe()...)
+	}
+	return summary
+}
+
+// findRenameSource looks for a nested file in the given directory snapshot
+// that has the same contents as `nested` and is not at `exclude`.
+func findRenameSource(ctx context.Context, s *storage.LocalFiles, dir *LogEntry, nested *snapshot.File, exclude snapshot.Path) (snapshot.Path, *snapshot.Hash, error) {
+	if nested == nil || nested.Contents == nil || nested.IsDir() {
+		return "", nil, nil
+	}
+	paths, contents, err := dir.NestedContents(ctx, s, false)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, p := range paths {
+		if snapshot.Path(p) == exclude {
+			continue
+		}
+		h := contents[p]
+		f, err := s.ReadSnapshot(ctx, h)
+		if err != nil {
+			return "", nil, fmt.Errorf("failure reading the snapshot %q of the nested file %q: %v", h, p, err)
+		}
+		if f.Contents.Equal(nested.Contents) && f.IsLink() == nested.IsLink() {
+			return snapshot.Path(p), h, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// ReadSubpathLog reads the history of the file nested at `subpath` within
+// the directory snapshot `h`.
+//
+// This walks the history of the directory snapshot, up to `maxDepth`
+// generations, and only returns the entries where the snapshot of the
+// nested file differs from its snapshot in every one of the entry's
+// parents. Like the `ReadLog` method, a negative `maxDepth` means there
+// is no limit.
+//
+// If `follow` is true, then whenever the nested file is added, the parent
+// directory snapshots are searched for a file with the same contents, and
+// if one is found then the nested file is treated as having been renamed
+// from it and the history of that path is followed from then on.
+func ReadSubpathLog(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, subpath snapshot.Path, maxDepth int, follow bool) ([]*SubpathEntry, error) {
+	subpath = snapshot.Path(filepath.Clean(string(subpath)))
+	entries, err := ReadLog(ctx, s, h, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	sorted, _ := topoSort(entries)
+	paths := map[snapshot.Hash]snapshot.Path{*h: subpath}
+	var result []*SubpathEntry
+	for _, e := range sorted {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p, ok := paths[*e.Hash]
+		if !ok {
+			p = subpath
+		}
+		nested, nestedFile, err := s.ReadSubpath(ctx, e.Hash, p)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading %q within the snapshot %q: %v", p, e.Hash, err)
+		}
+		changed := true
+		var previousPath snapshot.Path
+		var previous *snapshot.Hash
+		for i, parent := range e.File.Parents {
+			parentPath := p
+			parentNested, _, err := s.ReadSubpath(ctx, parent, p)
+			if err != nil {
+				return nil, fmt.Errorf("failure reading %q within the snapshot %q: %v", p, parent, err)
+			}
+			if follow && parentNested == nil && nested != nil {
+				parentFile, err := s.ReadSnapshot(ctx, parent)
+				if err != nil {
+					return nil, fmt.Errorf("failure reading the snapshot %q: %v", parent, err)
+				}
+				renamedPath, renamed, err := findRenameSource(ctx, s, &LogEntry{Hash: parent, File: parentFile}, nestedFile, p)
+				if err != nil {
+					return nil, fmt.Errorf("failure looking for the previous path of %q in %q: %v", p, parent, err)
+				}
+				if renamed != nil {
+					parentPath, parentNested = renamedPath, renamed
+				}
+			}
+			if _, ok := paths[*parent]; !ok {
+				paths[*parent] = parentPath
+			}
+			if i == 0 {
+				previousPath, previous = parentPath, parentNested
+			}
+			if parentNested.Equal(nested) && parentPath == p {
+				changed = false
+			}
+		}
+		if len(e.File.Parents) == 0 && nested == nil {
+			changed = false
+		}
+		if !changed {
+			continue
+		}
+		if previous == nil {
+			previousPath = p
+		}
+		result = append(result, &SubpathEntry{
+			LogEntry:     e,
+			Path:         p,
+			Nested:       nested,
+			PreviousPath: previousPath,
+			Previous:     previous,
+		})
+	}
+	return result, nil
+}
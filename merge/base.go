@@ -16,10 +16,10 @@
 package merge
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
 
-	"github.com/google/recursive-version-control-system/log"
 	"github.com/google/recursive-version-control-system/snapshot"
 	"github.com/google/recursive-version-control-system/storage"
 )
@@ -39,39 +39,209 @@ import (
 //
 // Regardless, this method can still return an error in cases where the
 // snapshot storage is incomplete and some snapshots are missing.
+//
+// If there are multiple best common ancestors (see the `Bases` method),
+// then the one with the highest generation is returned, with ties broken
+// by choosing the one with the lowest hash.
 func Base(ctx context.Context, s *storage.LocalFiles, lhs, rhs *snapshot.Hash) (*snapshot.Hash, error) {
+	bases, err := Bases(ctx, s, lhs, rhs)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		return nil, nil
+	}
+	return bases[0], nil
+}
+
+// Bases identifies all of the best common ancestors of two snapshots.
+//
+// A best common ancestor is a common ancestor that is not itself an
+// ancestor of any other common ancestor. Histories with criss-cross merges
+// can have more than one of these, and in that case none of them is
+// better than the others as a merge base.
+//
+// The ancestry index is used to walk the histories of both snapshots in
+// order of decreasing generation, which lets the walk stop once every
+// remaining snapshot is known to be an ancestor of a common ancestor.
+//
+// The returned bases are ordered from the highest generation to the
+// lowest, with ties broken by the hashes. If the only common ancestor is
+// the nil snapshot, then the returned slice is empty.
+func Bases(ctx context.Context, s *storage.LocalFiles, lhs, rhs *snapshot.Hash) ([]*snapshot.Hash, error) {
 	if lhs.Equal(rhs) {
-		return lhs, nil
+		if lhs == nil {
+			return nil, nil
+		}
+		return []*snapshot.Hash{lhs}, nil
 	}
 	if lhs == nil || rhs == nil {
 		return nil, nil
 	}
-	lhsLog, err := log.ReadLog(ctx, s, lhs, -1)
-	if err != nil {
-		return nil, fmt.Errorf("failure reading the log for %q: %v", lhs, err)
+	q := &generationQueue{}
+	flags := make(map[snapshot.Hash]int)
+	queued := make(map[snapshot.Hash]struct{})
+	// unvisited is the number of queued snapshots that are not yet known
+	// to be ancestors of a common ancestor.
+	unvisited := 0
+	push := func(h *snapshot.Hash, flag int) error {
+		if prev, ok := flags[*h]; ok {
+			flags[*h] = prev | flag
+			if _, ok := queued[*h]; ok && prev&stale == 0 && flag&stale != 0 {
+				unvisited--
+			}
+			return nil
+		}
+		e, err := s.Ancestry(ctx, h)
+		if err != nil {
+			return fmt.Errorf("failure reading the ancestry of %q: %v", h, err)
+		}
+		flags[*h] = flag
+		queued[*h] = struct{}{}
+		if flag&stale == 0 {
+			unvisited++
+		}
+		heap.Push(q, &queuedSnapshot{hash: h, entry: e})
+		return nil
 	}
-	lhsAncestors := make(map[snapshot.Hash]struct{})
-	for _, e := range lhsLog {
-		lhsAncestors[*e.Hash] = struct{}{}
+	if err := push(lhs, fromLHS); err != nil {
+		return nil, err
 	}
-	rhsLog, err := log.ReadLog(ctx, s, rhs, -1)
-	if err != nil {
-		return nil, fmt.Errorf("failure reading the log for %q: %v", rhs, err)
+	if err := push(rhs, fromRHS); err != nil {
+		return nil, err
+	}
+	var candidates []*snapshot.Hash
+	for unvisited > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		next := heap.Pop(q).(*queuedSnapshot)
+		delete(queued, *next.hash)
+		flag := flags[*next.hash]
+		if flag&stale == 0 {
+			unvisited--
+		}
+		if flag&(fromLHS|fromRHS) == fromLHS|fromRHS && flag&stale == 0 {
+			candidates = append(candidates, next.hash)
+			// Every ancestor of a common ancestor is also a
+			// common ancestor, but not a best one.
+			flag |= stale
+		}
+		for _, p := range next.entry.Parents {
+			if err := push(p, flag); err != nil {
+				// The history is incomplete, so skip the missing parent.
+				continue
+			}
+		}
+	}
+
+	// A candidate found via one side of the history may still be an
+	// ancestor of a candidate found via the other side.
+	var bases []*snapshot.Hash
+	for i, candidate := range candidates {
+		redundant := false
+		for j, other := range candidates {
+			if i == j {
+				continue
+			}
+			isAncestor, err := IsAncestor(ctx, s, candidate, other)
+			if err != nil {
+				return nil, err
+			}
+			if isAncestor {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			bases = append(bases, candidate)
+		}
+	}
+	return bases, nil
+}
+
+const (
+	fromLHS = 1 << iota
+	fromRHS
+	stale
+)
+
+// queuedSnapshot is a snapshot waiting to be visited in a history walk.
+type queuedSnapshot struct {
+	hash  *snapshot.Hash
+	entry *storage.AncestryEntry
+}
+
+// generationQueue is a priority queue of snapshots, ordered from the
+// highest generation to the lowest.
+//
+// This implements the `heap.Interface` interface.
+type generationQueue []*queuedSnapshot
+
+func (q generationQueue) Len() int { return len(q) }
+
+func (q generationQueue) Less(i, j int) bool {
+	if q[i].entry.Generation != q[j].entry.Generation {
+		return q[i].entry.Generation > q[j].entry.Generation
+	}
+	return q[i].hash.String() < q[j].hash.String()
+}
+
+func (q generationQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *generationQueue) Push(x any) { *q = append(*q, x.(*queuedSnapshot)) }
+
+func (q *generationQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+// IsAncestor reports whether or not `base` is an ancestor of `h`.
+//
+// This only walks the history of `h` down to the generation of `base`,
+// since no snapshot with a lower generation can have `base` as an ancestor.
+func IsAncestor(ctx context.Context, s *storage.LocalFiles, base, h *snapshot.Hash) (bool, error) {
+	if base == nil {
+		// The nil snapshot is an ancestor of all other snapshots.
+		return true, nil
+	}
+	if h == nil {
+		return false, nil
 	}
-	rhsAncestors := make(map[snapshot.Hash]struct{})
-	for _, e := range rhsLog {
-		rhsAncestors[*e.Hash] = struct{}{}
+	baseEntry, err := s.Ancestry(ctx, base)
+	if err != nil {
+		return false, fmt.Errorf("failure reading the ancestry of %q: %v", base, err)
 	}
-	for len(lhsLog) > 0 && len(rhsLog) > 0 {
-		if _, ok := rhsAncestors[*lhsLog[0].Hash]; ok {
-			return lhsLog[0].Hash, nil
+	visited := map[snapshot.Hash]struct{}{*h: struct{}{}}
+	stack := []*snapshot.Hash{h}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if next.Equal(base) {
+			return true, nil
+		}
+		e, err := s.Ancestry(ctx, next)
+		if err != nil {
+			if next.Equal(h) {
+				return false, fmt.Errorf("failure reading the ancestry of %q: %v", h, err)
+			}
+			// The history is incomplete.
+			continue
+		}
+		if e.Generation <= baseEntry.Generation {
+			continue
 		}
-		if _, ok := lhsAncestors[*rhsLog[0].Hash]; ok {
-			return rhsLog[0].Hash, nil
+		for _, p := range e.Parents {
+			if _, ok := visited[*p]; !ok {
+				visited[*p] = struct{}{}
+				stack = append(stack, p)
+			}
 		}
-		lhsLog = lhsLog[1:]
-		rhsLog = rhsLog[1:]
 	}
-	// There are no common ancestors other than the nil snapshot
-	return nil, nil
+	return false, nil
 }
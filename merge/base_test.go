@@ -16,9 +16,12 @@
 package merge
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/recursive-version-control-system/snapshot"
@@ -143,3 +146,271 @@ func TestMutualParentBase(t *testing.T) {
 		t.Errorf("unexpected mergebase for two sibling snapshots: %v", base)
 	}
 }
+
+func TestIsAncestor(t *testing.T) {
+	s, h1, h2, h3 := setupSnapshots(t)
+	testCases := []struct {
+		Description string
+		Base, H     *snapshot.Hash
+		Want        bool
+	}{
+		{"nil base", nil, h2, true},
+		{"self", h2, h2, true},
+		{"parent", h1, h2, true},
+		{"child", h2, h1, false},
+		{"sibling", h2, h3, false},
+	}
+	for _, testCase := range testCases {
+		if got, err := IsAncestor(context.Background(), s, testCase.Base, testCase.H); err != nil {
+			t.Errorf("failure checking ancestry for the test case %q: %v", testCase.Description, err)
+		} else if got != testCase.Want {
+			t.Errorf("unexpected ancestry result for the test case %q: got %v, want %v", testCase.Description, got, testCase.Want)
+		}
+	}
+}
+
+// setupLongHistory creates a linear history of `length` snapshots, and
+// then two sibling snapshots that both have the last of those as their parent.
+func setupLongHistory(b *testing.B, length int) (s *storage.LocalFiles, lhs, rhs *snapshot.Hash) {
+	ctx := context.Background()
+	s = &storage.LocalFiles{ArchiveDir: filepath.Join(b.TempDir(), "archive")}
+	storeFile := func(contents string, parents ...*snapshot.Hash) *snapshot.Hash {
+		contentsHash, err := s.StoreObject(ctx, int64(len(contents)), strings.NewReader(contents))
+		if err != nil {
+			b.Fatalf("failure storing the contents %q: %v", contents, err)
+		}
+		bs := []byte((&snapshot.File{Mode: "-rw-------", Contents: contentsHash, Parents: parents}).String())
+		h, err := s.StoreObject(ctx, int64(len(bs)), bytes.NewReader(bs))
+		if err != nil {
+			b.Fatalf("failure storing the snapshot of %q: %v", contents, err)
+		}
+		return h
+	}
+	var h *snapshot.Hash
+	for i := 0; i < length; i++ {
+		if h == nil {
+			h = storeFile("version 0")
+		} else {
+			h = storeFile(fmt.Sprintf("version %d", i), h)
+		}
+	}
+	lhs = storeFile("left", h)
+	rhs = storeFile("right", h)
+	// Populate the ancestry index, as would happen when storing snapshots.
+	for _, h := range []*snapshot.Hash{lhs, rhs} {
+		if _, err := s.Ancestry(ctx, h); err != nil {
+			b.Fatalf("failure indexing the ancestry of %q: %v", h, err)
+		}
+	}
+	return s, lhs, rhs
+}
+
+// readFullHistory reads every ancestor of the given snapshot without using
+// the ancestry index, which is how ancestry queries worked before the index.
+func readFullHistory(b *testing.B, s *storage.LocalFiles, h *snapshot.Hash) []*snapshot.Hash {
+	var result []*snapshot.Hash
+	visited := make(map[snapshot.Hash]struct{})
+	queue := []*snapshot.Hash{h}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[*next]; ok {
+			continue
+		}
+		visited[*next] = struct{}{}
+		result = append(result, next)
+		f, err := s.ReadSnapshot(context.Background(), next)
+		if err != nil {
+			b.Fatalf("failure reading the snapshot %q: %v", next, err)
+		}
+		queue = append(queue, f.Parents...)
+	}
+	return result
+}
+
+const benchmarkHistoryLength = 500
+
+func BenchmarkIsAncestor(b *testing.B) {
+	s, lhs, _ := setupLongHistory(b, benchmarkHistoryLength)
+	f, err := s.ReadSnapshot(context.Background(), lhs)
+	if err != nil {
+		b.Fatalf("failure reading the snapshot %q: %v", lhs, err)
+	}
+	parent := f.Parents[0]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if isAncestor, err := IsAncestor(context.Background(), s, parent, lhs); err != nil || !isAncestor {
+			b.Fatalf("unexpected result checking ancestry: %v, %v", isAncestor, err)
+		}
+	}
+}
+
+func BenchmarkIsAncestorWithoutIndex(b *testing.B) {
+	s, lhs, _ := setupLongHistory(b, benchmarkHistoryLength)
+	f, err := s.ReadSnapshot(context.Background(), lhs)
+	if err != nil {
+		b.Fatalf("failure reading the snapshot %q: %v", lhs, err)
+	}
+	parent := f.Parents[0]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		found := false
+		for _, h := range readFullHistory(b, s, lhs) {
+			if h.Equal(parent) {
+				found = true
+			}
+		}
+		if !found {
+			b.Fatalf("failed to find the parent %q in the history of %q", parent, lhs)
+		}
+	}
+}
+
+func BenchmarkBase(b *testing.B) {
+	s, lhs, rhs := setupLongHistory(b, benchmarkHistoryLength)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if base, err := Base(context.Background(), s, lhs, rhs); err != nil || base == nil {
+			b.Fatalf("unexpected merge base: %v, %v", base, err)
+		}
+	}
+}
+
+func BenchmarkBaseWithoutIndex(b *testing.B) {
+	s, lhs, rhs := setupLongHistory(b, benchmarkHistoryLength)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lhsHistory := readFullHistory(b, s, lhs)
+		rhsHistory := make(map[snapshot.Hash]struct{})
+		for _, h := range readFullHistory(b, s, rhs) {
+			rhsHistory[*h] = struct{}{}
+		}
+		var base *snapshot.Hash
+		for _, h := range lhsHistory {
+			if _, ok := rhsHistory[*h]; ok {
+				base = h
+				break
+			}
+		}
+		if base == nil {
+			b.Fatalf("failed to find a merge base")
+		}
+	}
+}
+
+// crissCrossFixture holds a history with criss-cross merges:
+//
+//	   R
+//	  / \
+//	X1   Y1
+//	 | \/ |
+//	 | /\ |
+//	X2   Y2
+//	 |    |
+//	X3   Y3
+//
+// Each snapshot is a directory with two files, `a` and `b`. The `X` side
+// changes `a` and then `b`, and the `Y` side changes `b` and then `a`,
+// with both X2 and Y2 merging X1 and Y1.
+type crissCrossFixture struct {
+	s                         *storage.LocalFiles
+	r, x1, y1, x2, y2, x3, y3 *snapshot.Hash
+	a1, a2, a3, b1, b2, b3    *snapshot.Hash
+}
+
+func setupCrissCross(t *testing.T) *crissCrossFixture {
+	ctx := context.Background()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive")}
+	storeFile := func(mode string, contents *snapshot.Hash, parents ...*snapshot.Hash) *snapshot.Hash {
+		bs := []byte((&snapshot.File{Mode: mode, Contents: contents, Parents: parents}).String())
+		h, err := s.StoreObject(ctx, int64(len(bs)), bytes.NewReader(bs))
+		if err != nil {
+			t.Fatalf("failure storing a snapshot: %v", err)
+		}
+		return h
+	}
+	storeContents := func(contents string) *snapshot.Hash {
+		h, err := s.StoreObject(ctx, int64(len(contents)), strings.NewReader(contents))
+		if err != nil {
+			t.Fatalf("failure storing the contents %q: %v", contents, err)
+		}
+		return h
+	}
+	storeDir := func(a, b *snapshot.Hash, parents ...*snapshot.Hash) *snapshot.Hash {
+		contents, err := snapshot.StoreTree(ctx, s, snapshot.Tree{"a": a, "b": b})
+		if err != nil {
+			t.Fatalf("failure storing a tree: %v", err)
+		}
+		return storeFile("drwx------", contents, parents...)
+	}
+	f := &crissCrossFixture{s: s}
+	f.a1 = storeFile("-rw-------", storeContents("a1\n"))
+	f.a2 = storeFile("-rw-------", storeContents("a2\n"), f.a1)
+	f.a3 = storeFile("-rw-------", storeContents("a3\n"), f.a2)
+	f.b1 = storeFile("-rw-------", storeContents("b1\n"))
+	f.b2 = storeFile("-rw-------", storeContents("b2\n"), f.b1)
+	f.b3 = storeFile("-rw-------", storeContents("b3\n"), f.b2)
+	f.r = storeDir(f.a1, f.b1)
+	f.x1 = storeDir(f.a2, f.b1, f.r)
+	f.y1 = storeDir(f.a1, f.b2, f.r)
+	f.x2 = storeDir(f.a2, f.b2, f.x1, f.y1)
+	f.y2 = storeDir(f.a2, f.b2, f.y1, f.x1)
+	f.x3 = storeDir(f.a2, f.b3, f.x2)
+	f.y3 = storeDir(f.a3, f.b2, f.y2)
+	return f
+}
+
+func TestCrissCrossBases(t *testing.T) {
+	f := setupCrissCross(t)
+	bases, err := Bases(context.Background(), f.s, f.x3, f.y3)
+	if err != nil {
+		t.Fatalf("failure computing the merge bases: %v", err)
+	}
+	if len(bases) != 2 {
+		t.Fatalf("unexpected merge bases for a criss-cross history: %v", bases)
+	}
+	found := make(map[snapshot.Hash]struct{})
+	for _, b := range bases {
+		found[*b] = struct{}{}
+	}
+	for _, want := range []*snapshot.Hash{f.x1, f.y1} {
+		if _, ok := found[*want]; !ok {
+			t.Errorf("missing merge base %q from %v", want, bases)
+		}
+	}
+	if base, err := Base(context.Background(), f.s, f.x3, f.y3); err != nil {
+		t.Errorf("failure computing the merge base: %v", err)
+	} else if !base.Equal(bases[0]) {
+		t.Errorf("unexpected merge base: got %q, want %q", base, bases[0])
+	}
+
+	// Once the criss-cross is resolved, there is only one best common ancestor.
+	if bases, err := Bases(context.Background(), f.s, f.x3, f.x2); err != nil {
+		t.Errorf("failure computing the merge bases of a snapshot and its parent: %v", err)
+	} else if len(bases) != 1 || !bases[0].Equal(f.x2) {
+		t.Errorf("unexpected merge bases for a snapshot and its parent: %v", bases)
+	}
+	if bases, err := Bases(context.Background(), f.s, f.x1, f.y1); err != nil {
+		t.Errorf("failure computing the merge bases of two siblings: %v", err)
+	} else if len(bases) != 1 || !bases[0].Equal(f.r) {
+		t.Errorf("unexpected merge bases for two siblings: %v", bases)
+	}
+}
+
+func TestVirtualBaseErrors(t *testing.T) {
+	f := setupCrissCross(t)
+	opts := &mergeOptions{virtualBases: make(map[snapshot.Hash]struct{})}
+	if base, err := virtualBase(context.Background(), f.s, "", []*snapshot.Hash{f.x1, f.y1}, opts); err != nil {
+		t.Errorf("failure merging the criss-cross bases: %v", err)
+	} else if base.Equal(f.x1) || base.Equal(f.y1) {
+		t.Errorf("unexpected fallback to one of the criss-cross bases: %q", base)
+	}
+
+	// Failures other than conflicts between the bases must not fall back
+	// to one of the bases.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if base, err := virtualBase(ctx, f.s, "", []*snapshot.Hash{f.x1, f.y1}, opts); err == nil {
+		t.Errorf("unexpected success merging the criss-cross bases with a cancelled context: %q", base)
+	}
+}
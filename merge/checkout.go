@@ -21,7 +21,10 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/google/recursive-version-control-system/progress"
 	"github.com/google/recursive-version-control-system/snapshot"
 	"github.com/google/recursive-version-control-system/storage"
 )
@@ -45,6 +48,7 @@ func recreateLink(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash,
 	if err := os.Symlink(string(contents), string(p)); err != nil {
 		return fmt.Errorf("failure recreating the symlink %q: %v", h, err)
 	}
+	progress.Add(ctx, 1, 0)
 	return nil
 }
 
@@ -65,7 +69,7 @@ func ensureDirExistsWithPermissions(ctx context.Context, path string, perm os.Fi
 	return os.Mkdir(path, perm)
 }
 
-func recreateDir(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, f *snapshot.File, p snapshot.Path) error {
+func recreateDir(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, f *snapshot.File, p snapshot.Path, record bool) error {
 	perm := f.Permissions()
 	if err := ensureDirExistsWithPermissions(ctx, string(p), perm); err != nil {
 		return fmt.Errorf("failure creating the directory %q: %v", p, err)
@@ -102,6 +106,9 @@ func recreateDir(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, f
 		}
 	}
 	for child, childHash := range tree {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		childPath := p.Join(child)
 		if s.Exclude(childPath) {
 			// The child path is meant to be excluded from
@@ -109,7 +116,7 @@ func recreateDir(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, f
 			// being updated when checking out a snapshot.
 			continue
 		}
-		if err := Checkout(ctx, s, childHash, childPath); err != nil {
+		if err := checkout(ctx, s, childHash, childPath, record); err != nil {
 			return fmt.Errorf("failure checking out the child path %q: %v", childPath, err)
 		}
 	}
@@ -133,28 +140,32 @@ func ensureFileExistsWithPermissions(ctx context.Context, path string, perm os.F
 	return out, nil
 }
 
-func recreateFile(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, f *snapshot.File, p snapshot.Path) error {
+func recreateFile(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, f *snapshot.File, p snapshot.Path, record bool) error {
 	if f.IsLink() {
 		return recreateLink(ctx, s, h, f, p)
 	}
 	if f.IsDir() {
-		return recreateDir(ctx, s, h, f, p)
+		return recreateDir(ctx, s, h, f, p, record)
 	}
 	perm := f.Permissions()
 	contentsReader, err := s.ReadObject(ctx, f.Contents)
 	if err != nil {
 		return fmt.Errorf("failure opening the contents of the link snapshot %q: %v", h, err)
 	}
+	defer contentsReader.Close()
 	out, err := ensureFileExistsWithPermissions(ctx, string(p), perm)
 	if err != nil {
 		return fmt.Errorf("failure opening the file %q: %v", p, err)
 	}
-	if _, err := io.Copy(out, contentsReader); err != nil {
+	written, err := io.Copy(out, progress.Reader(ctx, contentsReader))
+	if err != nil {
+		out.Close()
 		return fmt.Errorf("failure writing the contents of %q: %v", p, err)
 	}
 	if err := out.Close(); err != nil {
 		return fmt.Errorf("failure closing the file %q: %v", p, err)
 	}
+	progress.Add(ctx, 1, written)
 	return nil
 }
 
@@ -171,8 +182,25 @@ func recreateFile(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash,
 //
 // If there are any errors during the checkout, then the applied filesystem
 // changes are not rolled back and the local file system can be left in an
-// inconsistent state.
+// inconsistent state. The same applies if the context is cancelled part way
+// through the checkout.
+//
+// Progress is reported to any `progress.Reporter` carried by the context.
 func Checkout(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, p snapshot.Path) error {
+	return checkout(ctx, s, h, p, true)
+}
+
+// Extract writes the given snapshot to a file location the same way as
+// `Checkout`, but without mapping the location, or any of its nested paths,
+// to the snapshot.
+//
+// This is meant for temporary copies of a snapshot that should not be
+// remembered once they are removed.
+func Extract(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, p snapshot.Path) error {
+	return checkout(ctx, s, h, p, false)
+}
+
+func checkout(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, p snapshot.Path, record bool) error {
 	f, err := s.ReadSnapshot(ctx, h)
 	if err != nil {
 		return fmt.Errorf("failure reading the file snapshot for %q: %v", h, err)
@@ -185,11 +213,246 @@ func Checkout(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, p sn
 	if err := os.MkdirAll(parent, os.FileMode(0700)); err != nil {
 		return fmt.Errorf("failure ensuring the parent directory of %q exists: %v", p, err)
 	}
-	if err := recreateFile(ctx, s, h, f, p); err != nil {
+	if err := recreateFile(ctx, s, h, f, p, record); err != nil {
 		return fmt.Errorf("failure checking out the snapshot %q to the path %q: %v", h, p, err)
 	}
+	if !record {
+		return nil
+	}
 	if _, err := s.StoreSnapshot(ctx, p, f); err != nil {
 		return fmt.Errorf("failure updating the snapshot for %q to %q: %v", p, h, err)
 	}
 	return nil
 }
+
+// ModifiedError is returned by `Update` when some of the paths it would
+// have to change no longer match the snapshot they were expected to have.
+type ModifiedError struct {
+	// Paths are the paths that were modified.
+	Paths []snapshot.Path
+}
+
+func (e *ModifiedError) Error() string {
+	var paths []string
+	for _, p := range e.Paths {
+		paths = append(paths, string(p))
+	}
+	return fmt.Sprintf("the following paths were modified and would have been overwritten: %s", strings.Join(paths, ", "))
+}
+
+// pathUpdate is a single change to the local filesystem needed to update
+// it from one snapshot to another.
+type pathUpdate struct {
+	path     snapshot.Path
+	old, new *snapshot.Hash
+
+	// oldFile and newFile are the snapshots for `old` and `new`, or nil
+	// if the corresponding hash is nil.
+	oldFile, newFile *snapshot.File
+
+	// widened reports whether or not the permissions of a directory
+	// update were temporarily widened so that its nested paths could
+	// be updated.
+	widened bool
+}
+
+// isDirUpdate reports whether or not the update is to a directory that
+// remains a directory, in which case its nested paths are updated
+// separately and only the directory's own permissions need to change.
+func (u *pathUpdate) isDirUpdate() bool {
+	return u.oldFile != nil && u.newFile != nil && u.oldFile.IsDir() && u.newFile.IsDir()
+}
+
+func readSnapshotIfPresent(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash) (*snapshot.File, error) {
+	if h == nil {
+		return nil, nil
+	}
+	f, err := s.ReadSnapshot(ctx, h)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the file snapshot for %q: %v", h, err)
+	}
+	return f, nil
+}
+
+// planUpdates appends the updates needed to change the path `p` from the
+// snapshot `old` to the snapshot `new`.
+//
+// The updates for nested paths come before the update for the directory
+// holding them, and paths whose snapshots are unchanged are skipped.
+func planUpdates(ctx context.Context, s *storage.LocalFiles, p snapshot.Path, old, new *snapshot.Hash, updates []*pathUpdate) ([]*pathUpdate, error) {
+	if old.Equal(new) || s.Exclude(p) {
+		return updates, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	oldFile, err := readSnapshotIfPresent(ctx, s, old)
+	if err != nil {
+		return nil, err
+	}
+	newFile, err := readSnapshotIfPresent(ctx, s, new)
+	if err != nil {
+		return nil, err
+	}
+	u := &pathUpdate{
+		path:    p,
+		old:     old,
+		new:     new,
+		oldFile: oldFile,
+		newFile: newFile,
+	}
+	if !u.isDirUpdate() {
+		return append(updates, u), nil
+	}
+	oldTree, err := s.ListDirectorySnapshotContents(ctx, old, oldFile)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the contents of the directory snapshot %q: %v", old, err)
+	}
+	newTree, err := s.ListDirectorySnapshotContents(ctx, new, newFile)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the contents of the directory snapshot %q: %v", new, err)
+	}
+	childSet := make(map[snapshot.Path]struct{})
+	for child, _ := range oldTree {
+		childSet[child] = struct{}{}
+	}
+	for child, _ := range newTree {
+		childSet[child] = struct{}{}
+	}
+	var children []snapshot.Path
+	for child, _ := range childSet {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i] < children[j]
+	})
+	for _, child := range children {
+		updates, err = planUpdates(ctx, s, p.Join(child), oldTree[child], newTree[child], updates)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(updates, u), nil
+}
+
+// isModified reports whether or not the path for the given update no
+// longer matches the snapshot it is expected to have.
+//
+// The path's current contents are only peeked at, so checking them does not
+// record a new snapshot for the path.
+//
+// The nested paths of directory updates are checked by their own updates,
+// so for those this only checks that the path is still a directory.
+func isModified(ctx context.Context, s *storage.LocalFiles, u *pathUpdate) (bool, error) {
+	if u.isDirUpdate() {
+		info, err := os.Lstat(string(u.path))
+		if err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failure reading file metadata for the path %q: %v", u.path, err)
+		}
+		return err != nil || !info.IsDir(), nil
+	}
+	current, _, err := snapshot.Peek(ctx, s, u.path)
+	if err != nil {
+		return false, fmt.Errorf("failure snapshotting the current contents of %q: %v", u.path, err)
+	}
+	return !current.Equal(u.old), nil
+}
+
+// widenDirPermissions makes every directory being updated accessible and
+// writable by its owner before any of its nested paths are updated.
+//
+// Each directory's own update comes after the updates for its nested paths,
+// so without this the nested paths of a directory whose permissions did
+// not allow writing before the update could not be changed. The final
+// permissions are set when the directory's own update is applied.
+func widenDirPermissions(updates []*pathUpdate) error {
+	// Parent directories come after their nested paths, so they are
+	// widened first by going through the updates in reverse.
+	for i := len(updates) - 1; i >= 0; i-- {
+		u := updates[i]
+		if !u.isDirUpdate() {
+			continue
+		}
+		perm := u.oldFile.Permissions() | u.newFile.Permissions() | 0700
+		if perm == u.oldFile.Permissions() {
+			continue
+		}
+		if err := os.Chmod(string(u.path), perm); err != nil {
+			return fmt.Errorf("failure changing the permissions of %q: %v", u.path, err)
+		}
+		u.widened = true
+	}
+	return nil
+}
+
+func applyUpdate(ctx context.Context, s *storage.LocalFiles, u *pathUpdate) error {
+	if u.newFile == nil {
+		if err := os.RemoveAll(string(u.path)); err != nil {
+			return fmt.Errorf("failure removing the deleted file %q: %v", u.path, err)
+		}
+		if err := s.RemoveMappingForPath(ctx, u.path); err != nil {
+			return fmt.Errorf("failure removing the snapshot for the deleted file %q: %v", u.path, err)
+		}
+		return nil
+	}
+	sameType := u.oldFile != nil && u.oldFile.IsDir() == u.newFile.IsDir() && u.oldFile.IsLink() == u.newFile.IsLink()
+	if sameType && (u.newFile.IsDir() || u.oldFile.Contents.Equal(u.newFile.Contents)) {
+		// The contents on disk are already correct, so at most the
+		// permissions need to change.
+		if !u.newFile.IsLink() && (u.widened || u.oldFile.Permissions() != u.newFile.Permissions()) {
+			if err := os.Chmod(string(u.path), u.newFile.Permissions()); err != nil {
+				return fmt.Errorf("failure changing the permissions of %q: %v", u.path, err)
+			}
+		}
+		if _, err := s.StoreSnapshot(ctx, u.path, u.newFile); err != nil {
+			return fmt.Errorf("failure updating the snapshot for %q to %q: %v", u.path, u.new, err)
+		}
+		return nil
+	}
+	return Checkout(ctx, s, u.new, u.path)
+}
+
+// Update changes the local file location `p` from the snapshot `old` to
+// the snapshot `new`, only touching the nested paths whose snapshots differ.
+//
+// Before anything is changed, every path that would be changed is checked
+// against the snapshot it is expected to have in `old`. If any of them have
+// been modified since, then nothing is changed and a `*ModifiedError` is
+// returned listing them.
+//
+// Nested paths that are not in either snapshot (including excluded paths)
+// are left alone.
+//
+// Progress is reported to any `progress.Reporter` carried by the context.
+func Update(ctx context.Context, s *storage.LocalFiles, old, new *snapshot.Hash, p snapshot.Path) error {
+	updates, err := planUpdates(ctx, s, p, old, new, nil)
+	if err != nil {
+		return fmt.Errorf("failure comparing the snapshots %q and %q: %v", old, new, err)
+	}
+	var modified []snapshot.Path
+	for _, u := range updates {
+		if m, err := isModified(ctx, s, u); err != nil {
+			return err
+		} else if m {
+			modified = append(modified, u.path)
+		}
+	}
+	if len(modified) > 0 {
+		return &ModifiedError{Paths: modified}
+	}
+	if err := os.MkdirAll(filepath.Dir(string(p)), os.FileMode(0700)); err != nil {
+		return fmt.Errorf("failure ensuring the parent directory of %q exists: %v", p, err)
+	}
+	if err := widenDirPermissions(updates); err != nil {
+		return err
+	}
+	for _, u := range updates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := applyUpdate(ctx, s, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -17,9 +17,11 @@ package merge
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/recursive-version-control-system/snapshot"
@@ -306,6 +308,21 @@ func TestCheckoutDir(t *testing.T) {
 	verifyFilesMatch(t, file1, filepath.Join(cloneDir, "example1.txt"))
 	verifyFilesMatch(t, file2, filepath.Join(cloneDir, "example2.txt"))
 	verifyFilesMatch(t, file3, filepath.Join(cloneDir, "example3.txt"))
+
+	extractDir := filepath.Join(dir, "extract-dir")
+	extractDirPath := snapshot.Path(extractDir)
+	if err := Extract(context.Background(), s, h1, extractDirPath); err != nil {
+		t.Fatalf("failure extracting the directory snapshot %q: %v", h1, err)
+	}
+	verifyFilesMatch(t, file1, filepath.Join(extractDir, "example1.txt"))
+	for _, p := range []snapshot.Path{extractDirPath, extractDirPath.Join("example1.txt")} {
+		if _, _, err := s.FindSnapshot(context.Background(), p); !os.IsNotExist(err) {
+			t.Errorf("unexpected mapping for the extracted path %q: %v", p, err)
+		}
+		if history, err := s.PathHistory(context.Background(), p); err != nil || len(history) > 0 {
+			t.Errorf("unexpected history for the extracted path %q: %+v, %v", p, history, err)
+		}
+	}
 }
 
 func TestCheckoutOverwriteDir(t *testing.T) {
@@ -408,3 +425,136 @@ func TestCheckoutOverwriteTextFileWithDir(t *testing.T) {
 	verifyFilesMatch(t, file2, filepath.Join(cloneDir, "example2.txt"))
 	verifyFilesMatch(t, file3, filepath.Join(cloneDir, "example3.txt"))
 }
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	s := &storage.LocalFiles{ArchiveDir: archive}
+	ctx := context.Background()
+
+	workingDir := filepath.Join(dir, "working-dir")
+	if err := os.MkdirAll(workingDir, 0700); err != nil {
+		t.Fatalf("failure creating the working dir: %v", err)
+	}
+	writeFile := func(name, contents string) {
+		if err := os.WriteFile(filepath.Join(workingDir, name), []byte(contents), 0700); err != nil {
+			t.Fatalf("failure writing %q: %v", name, err)
+		}
+	}
+	readFile := func(name string) string {
+		bs, err := os.ReadFile(filepath.Join(workingDir, name))
+		if os.IsNotExist(err) {
+			return "<missing>"
+		} else if err != nil {
+			t.Fatalf("failure reading %q: %v", name, err)
+		}
+		return string(bs)
+	}
+	takeSnapshot := func() *snapshot.Hash {
+		h, _, err := snapshot.Current(ctx, s, snapshot.Path(workingDir))
+		if err != nil {
+			t.Fatalf("failure snapshotting the working dir: %v", err)
+		}
+		return h
+	}
+
+	writeFile("a.txt", "unchanged")
+	writeFile("b.txt", "first")
+	writeFile("c.txt", "removed")
+	unchangedTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(workingDir, "a.txt"), unchangedTime, unchangedTime); err != nil {
+		t.Fatalf("failure setting the modification time of the unchanged file: %v", err)
+	}
+	h1 := takeSnapshot()
+
+	writeFile("b.txt", "second")
+	writeFile("d.txt", "added")
+	if err := os.Remove(filepath.Join(workingDir, "c.txt")); err != nil {
+		t.Fatalf("failure removing the file: %v", err)
+	}
+	h2 := takeSnapshot()
+
+	if err := Update(ctx, s, h2, h1, snapshot.Path(workingDir)); err != nil {
+		t.Fatalf("failure updating the working dir: %v", err)
+	}
+	for name, want := range map[string]string{
+		"a.txt": "unchanged",
+		"b.txt": "first",
+		"c.txt": "removed",
+		"d.txt": "<missing>",
+	} {
+		if got := readFile(name); got != want {
+			t.Errorf("unexpected contents for %q after updating: got %q, want %q", name, got, want)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(workingDir, "a.txt")); err != nil {
+		t.Errorf("failure reading the metadata of the unchanged file: %v", err)
+	} else if got, want := info.ModTime(), unchangedTime; !got.Equal(want) {
+		t.Errorf("unexpected modification time for the unchanged file: got %v, want %v", got, want)
+	}
+	if got, want := takeSnapshot(), h1; !got.Equal(want) {
+		t.Errorf("unexpected snapshot after updating: got %q, want %q", got, want)
+	}
+
+	// Modified files must not be overwritten, and nothing else should be
+	// changed when they are found.
+	bPath := snapshot.Path(filepath.Join(workingDir, "b.txt"))
+	bPrev, _, err := s.FindSnapshot(ctx, bPath)
+	if err != nil {
+		t.Fatalf("failure looking up the snapshot of the file: %v", err)
+	}
+	writeFile("b.txt", "modified")
+	err = Update(ctx, s, h1, h2, snapshot.Path(workingDir))
+	var modifiedErr *ModifiedError
+	if !errors.As(err, &modifiedErr) {
+		t.Fatalf("unexpected error updating over a modified file: %v", err)
+	}
+	wantModified := []snapshot.Path{snapshot.Path(filepath.Join(workingDir, "b.txt"))}
+	if diff := cmp.Diff(wantModified, modifiedErr.Paths); len(diff) > 0 {
+		t.Errorf("unexpected modified paths: %s", diff)
+	}
+	for name, want := range map[string]string{
+		"b.txt": "modified",
+		"c.txt": "removed",
+		"d.txt": "<missing>",
+	} {
+		if got := readFile(name); got != want {
+			t.Errorf("unexpected contents for %q after a failed update: got %q, want %q", name, got, want)
+		}
+	}
+	if h, _, err := s.FindSnapshot(ctx, bPath); err != nil || !h.Equal(bPrev) {
+		t.Errorf("unexpected snapshot recorded for the modified file: got %q, %v, want %q", h, err, bPrev)
+	}
+	// Nested files are updated even if their directory was not writable
+	// before the update.
+	writeFile("b.txt", "second")
+	nested := filepath.Join(workingDir, "nested")
+	if err := os.Mkdir(nested, 0700); err != nil {
+		t.Fatalf("failure creating the nested dir: %v", err)
+	}
+	writeFile(filepath.Join("nested", "e.txt"), "after")
+	writeFile(filepath.Join("nested", "f.txt"), "added")
+	h4 := takeSnapshot()
+	writeFile(filepath.Join("nested", "e.txt"), "before")
+	if err := os.Remove(filepath.Join(nested, "f.txt")); err != nil {
+		t.Fatalf("failure removing the nested file: %v", err)
+	}
+	if err := os.Chmod(nested, 0500); err != nil {
+		t.Fatalf("failure changing the permissions of the nested dir: %v", err)
+	}
+	h3 := takeSnapshot()
+	if err := Update(ctx, s, h3, h4, snapshot.Path(workingDir)); err != nil {
+		t.Fatalf("failure updating the read-only nested dir: %v", err)
+	}
+	for name, want := range map[string]string{
+		filepath.Join("nested", "e.txt"): "after",
+		filepath.Join("nested", "f.txt"): "added",
+	} {
+		if got := readFile(name); got != want {
+			t.Errorf("unexpected contents for %q after updating a read-only dir: got %q, want %q", name, got, want)
+		}
+	}
+	if got, want := takeSnapshot(), h4; !got.Equal(want) {
+		t.Errorf("unexpected snapshot after updating a read-only dir: got %q, want %q", got, want)
+	}
+}
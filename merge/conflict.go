@@ -0,0 +1,310 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const (
+	// ConflictMarkers is the kind of conflict where the file was written
+	// with the conflicting changes marked inside of it.
+	ConflictMarkers = "markers"
+
+	// ConflictVersions is the kind of conflict where the destination
+	// version was kept, and the source version was written alongside it.
+	ConflictVersions = "versions"
+
+	// ConflictType is the kind of conflict where the two sides changed
+	// the path into different types of files (e.g. a regular file and a
+	// directory). As with `ConflictVersions`, the destination version was
+	// kept, and the source version was written alongside it.
+	ConflictType = "type"
+
+	// ConflictMode is the kind of conflict where the modes of the two
+	// directories differed, and the destination mode was kept.
+	ConflictMode = "mode"
+
+	// SourceSuffix is appended to the name of a conflicting file to get
+	// the name of the file holding the source version of it.
+	SourceSuffix = ".rvcs-source"
+
+	// DestinationSuffix is appended to the name of a conflicting file to
+	// get the name of the file holding the destination version of it,
+	// when the source deleted that file.
+	DestinationSuffix = ".rvcs-destination"
+)
+
+// conflictError reports a nested path that could not be automatically merged.
+type conflictError struct {
+	kind   string
+	reason string
+
+	// markers holds the contents of the file with the conflicting
+	// changes marked, for conflicts of the `ConflictMarkers` kind.
+	markers []byte
+}
+
+func (e *conflictError) Error() string {
+	return e.reason
+}
+
+// ConflictError is returned by `Merge` when some paths could not be
+// automatically merged.
+//
+// In that case, the destination has been updated with the conflicts
+// written into it, and the merge remains in progress until it is either
+// continued with `Continue` or aborted with `Abort`.
+type ConflictError struct {
+	// Path is the destination of the merge.
+	Path snapshot.Path
+
+	// Conflicts are the nested paths that could not be merged.
+	Conflicts []*storage.MergeConflict
+}
+
+func (e *ConflictError) Error() string {
+	var paths []string
+	for _, c := range e.Conflicts {
+		paths = append(paths, string(c.Path))
+	}
+	return fmt.Sprintf("conflicts merging into %q: %s", e.Path, strings.Join(paths, ", "))
+}
+
+// sidecarPath returns the path to write a separate version of `subPath`
+// under, by appending the given suffix to it.
+//
+// If the `taken` function reports that the resulting name is already used
+// in the parent directory, then a number is appended until it is not.
+func sidecarPath(subPath snapshot.Path, suffix string, taken func(name snapshot.Path) bool) snapshot.Path {
+	sidecar := snapshot.Path(string(subPath) + suffix)
+	for i := 1; taken != nil && taken(snapshot.Path(filepath.Base(string(sidecar)))); i++ {
+		sidecar = snapshot.Path(fmt.Sprintf("%s%s.%d", subPath, suffix, i))
+	}
+	return sidecar
+}
+
+// recordConflict records the given conflict for the path `subPath`, and
+// returns the snapshot to write at that path along with the snapshot to
+// write alongside it (under `sidecar`), if any.
+//
+// The `taken` function reports whether or not a name in the parent
+// directory of `subPath` is already used, so that the sidecar does not
+// replace it. It may be nil if no sidecar can be written.
+func recordConflict(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, src, dest *snapshot.Hash, conflict *conflictError, taken func(name snapshot.Path) bool, opts *mergeOptions) (merged *snapshot.Hash, sidecar snapshot.Path, sidecarHash *snapshot.Hash, err error) {
+	recorded := &storage.MergeConflict{
+		Path:   subPath,
+		Kind:   conflict.kind,
+		Reason: conflict.reason,
+	}
+	switch conflict.kind {
+	case ConflictMarkers:
+		destFile, err := s.ReadSnapshot(ctx, dest)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failure reading the file snapshot for %q: %v", dest, err)
+		}
+		contentsHash, err := s.StoreObject(ctx, int64(len(conflict.markers)), bytes.NewReader(conflict.markers))
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failure storing the conflicting contents for %q: %v", subPath, err)
+		}
+		merged, err = storeMerged(ctx, s, &snapshot.File{
+			Mode:     destFile.Mode,
+			Contents: contentsHash,
+			Parents:  []*snapshot.Hash{src, dest},
+		}, opts)
+		if err != nil {
+			return nil, "", nil, err
+		}
+	case ConflictVersions, ConflictType:
+		if src == nil {
+			// The source deleted the file, so move the destination
+			// version out of the way to match that.
+			recorded.Sidecar = sidecarPath(subPath, DestinationSuffix, taken)
+			sidecarHash = dest
+		} else {
+			merged = dest
+			recorded.Sidecar = sidecarPath(subPath, SourceSuffix, taken)
+			sidecarHash = src
+		}
+	default:
+		return nil, "", nil, conflict
+	}
+	opts.conflicts = append(opts.conflicts, recorded)
+	return merged, recorded.Sidecar, sidecarHash, nil
+}
+
+// hasConflictMarkers reports whether or not the given file still has any
+// conflict markers in it.
+func hasConflictMarkers(p string) (bool, error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failure opening %q: %v", p, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failure reading %q: %v", p, err)
+	}
+	return false, nil
+}
+
+// IsResolved reports whether or not the given conflict from a merge into
+// `dest` has been resolved.
+//
+// Conflicts written with markers are resolved once the file no longer has
+// any conflict markers in it (or has been removed), and conflicts written
+// as separate versions (including type conflicts) are resolved once the
+// extra version has been removed.
+// Mode conflicts are always considered resolved, since the destination's
+// mode was kept and any mode set on the directory will be used.
+func IsResolved(dest snapshot.Path, c *storage.MergeConflict) (bool, error) {
+	switch c.Kind {
+	case ConflictMarkers:
+		hasMarkers, err := hasConflictMarkers(string(dest.Join(c.Path)))
+		return !hasMarkers, err
+	case ConflictVersions, ConflictType:
+		if _, err := os.Lstat(string(dest.Join(c.Sidecar))); os.IsNotExist(err) {
+			return true, nil
+		} else if err != nil {
+			return false, fmt.Errorf("failure checking for %q: %v", c.Sidecar, err)
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// Unresolved returns the conflicts of the in-progress merge into `dest`
+// that have not yet been resolved.
+func Unresolved(ctx context.Context, s *storage.LocalFiles, dest snapshot.Path) ([]*storage.MergeConflict, error) {
+	state, err := s.ReadMergeState(ctx, dest)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("there is no merge in progress for %q", dest)
+	}
+	var result []*storage.MergeConflict
+	for _, c := range state.Conflicts {
+		resolved, err := IsResolved(dest, c)
+		if err != nil {
+			return nil, err
+		}
+		if !resolved {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+// Continue completes the in-progress merge into `dest` once all of its
+// conflicts have been resolved.
+//
+// The resolved contents of `dest` are snapshotted, with both sides of the
+// merge as the parents, and the hash of that merged snapshot is returned.
+func Continue(ctx context.Context, s *storage.LocalFiles, dest snapshot.Path) (*snapshot.Hash, error) {
+	state, err := s.ReadMergeState(ctx, dest)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("there is no merge in progress for %q", dest)
+	}
+	unresolved, err := Unresolved(ctx, s, dest)
+	if err != nil {
+		return nil, err
+	}
+	if len(unresolved) > 0 {
+		return nil, &ConflictError{Path: dest, Conflicts: unresolved}
+	}
+	_, resolved, err := snapshot.Current(ctx, s, dest)
+	if err != nil {
+		return nil, fmt.Errorf("failure snapshotting the resolved contents of %q: %v", dest, err)
+	}
+	if resolved == nil {
+		return nil, fmt.Errorf("the merge destination %q no longer exists", dest)
+	}
+	merged := &snapshot.File{
+		Mode:     resolved.Mode,
+		Contents: resolved.Contents,
+		Parents:  []*snapshot.Hash{state.Source, state.Destination},
+	}
+	h, err := s.StoreSnapshot(ctx, dest, merged)
+	if err != nil {
+		return nil, fmt.Errorf("failure storing the merged snapshot for %q: %v", dest, err)
+	}
+	if err := s.RemoveMergeState(ctx, dest); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Abort cancels the in-progress merge into `dest`, restoring it to the
+// snapshot it had before the merge.
+func Abort(ctx context.Context, s *storage.LocalFiles, dest snapshot.Path) error {
+	state, err := s.ReadMergeState(ctx, dest)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("there is no merge in progress for %q", dest)
+	}
+	current, _, err := snapshot.Current(ctx, s, dest)
+	if err != nil {
+		return fmt.Errorf("failure snapshotting the partially merged contents of %q: %v", dest, err)
+	}
+	if err := Update(ctx, s, current, state.Destination, dest); err != nil {
+		return fmt.Errorf("failure restoring %q to %q: %v", dest, state.Destination, err)
+	}
+	return s.RemoveMergeState(ctx, dest)
+}
+
+// relativeConflicts converts the paths of the given conflicts to be
+// relative to the merge destination.
+func relativeConflicts(dest snapshot.Path, conflicts []*storage.MergeConflict) []*storage.MergeConflict {
+	relative := func(p snapshot.Path) snapshot.Path {
+		if len(p) == 0 {
+			return p
+		}
+		rel, err := filepath.Rel(string(dest), string(p))
+		if err != nil {
+			return p
+		}
+		return snapshot.Path(rel)
+	}
+	for _, c := range conflicts {
+		c.Path = relative(c.Path)
+		c.Sidecar = relative(c.Sidecar)
+	}
+	return conflicts
+}
@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -30,24 +31,49 @@ import (
 )
 
 const (
-	HelperEnvironmentVariable     = "RVCS_MERGE_HELPER_COMMAND"
-	HelperArgsEnvironmentVariable = "RVCS_MERGE_HELPER_ARGS"
+	HelperEnvironmentVariable        = "RVCS_MERGE_HELPER_COMMAND"
+	HelperArgsEnvironmentVariable    = "RVCS_MERGE_HELPER_ARGS"
+	HelperTimeoutEnvironmentVariable = "RVCS_MERGE_HELPER_TIMEOUT"
+
+	// defaultHelperTimeout is how long a merge helper may run for each
+	// file if no timeout is configured.
+	defaultHelperTimeout = time.Minute
 )
 
-func mergeWithHelper(ctx context.Context, s *storage.LocalFiles, p snapshot.Path, mode string, base, src, dest *snapshot.Hash) (*snapshot.Hash, error) {
+// helperTimeout returns how long a merge helper may run for each file.
+func helperTimeout() (time.Duration, error) {
+	timeout := os.Getenv(HelperTimeoutEnvironmentVariable)
+	if len(timeout) == 0 {
+		return defaultHelperTimeout, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("failure parsing the merge helper timeout %q: %v", timeout, err)
+	}
+	return d, nil
+}
+
+// mergeWithHelper merges the given file snapshots using the external merge
+// helper command configured in the environment.
+//
+// This is only called if a merge helper command is configured.
+func mergeWithHelper(ctx context.Context, s *storage.LocalFiles, p snapshot.Path, mode string, base, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
 	helperCmd := os.Getenv(HelperEnvironmentVariable)
 	helperArgs := os.Getenv(HelperArgsEnvironmentVariable)
-	if len(helperCmd) == 0 {
-		helperCmd = "diff3"
-		if len(helperArgs) == 0 {
-			helperArgs = "[\"-m\"]"
-		}
-	}
 	var args []string
-	if err := json.Unmarshal([]byte(helperArgs), &args); err != nil {
-		return nil, fmt.Errorf("failure parsing the helper args %q: %v", helperArgs, err)
+	if len(helperArgs) > 0 {
+		if err := json.Unmarshal([]byte(helperArgs), &args); err != nil {
+			return nil, fmt.Errorf("failure parsing the helper args %q: %v", helperArgs, err)
+		}
 	}
-	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("rvcs-merge-helper-%q", helperCmd))
+	return runHelper(ctx, s, p, mode, base, src, dest, helperCmd, args, opts)
+}
+
+// runHelper merges the given file snapshots using the helper command
+// `helperCmd`, invoked with the given args followed by the paths of
+// temporary copies of the source, base, and destination versions.
+func runHelper(ctx context.Context, s *storage.LocalFiles, p snapshot.Path, mode string, base, src, dest *snapshot.Hash, helperCmd string, args []string, opts *mergeOptions) (*snapshot.Hash, error) {
+	tmpDir, err := os.MkdirTemp("", "rvcs-merge-helper-")
 	if err != nil {
 		return nil, fmt.Errorf("failure generating the temporary working directory for the merge helper %q: %v", helperCmd, err)
 	}
@@ -55,15 +81,15 @@ func mergeWithHelper(ctx context.Context, s *storage.LocalFiles, p snapshot.Path
 
 	tmpPath := snapshot.Path(tmpDir)
 	srcPath := tmpPath.Join(snapshot.Path("src")).Join(p)
-	if err := Checkout(ctx, s, src, srcPath); err != nil {
+	if err := Extract(ctx, s, src, srcPath); err != nil {
 		return nil, fmt.Errorf("failure checking out %q to a temporary path for the merge helper: %v", src, err)
 	}
 	basePath := tmpPath.Join(snapshot.Path("base")).Join(p)
 	if base == nil {
 		// Simply create an empty file to serve as the merge base.
 		//
-		// With the default merge helper of `diff3`, this will always
-		// result in unresolvable conflicts, but the user might have
+		// With a merge helper like `diff3`, this will always result
+		// in unresolvable conflicts, but the user might have
 		// configured a more intelligent merge helper that knows how
 		// to resolve some cases of this, so we give it a chance to
 		// try.
@@ -74,21 +100,48 @@ func mergeWithHelper(ctx context.Context, s *storage.LocalFiles, p snapshot.Path
 		if _, err := os.Create(string(basePath)); err != nil {
 			return nil, fmt.Errorf("failure creating an empty temporary file to serve as the merge base for the merge helper: %v", err)
 		}
-	} else if err := Checkout(ctx, s, base, basePath); err != nil {
+	} else if err := Extract(ctx, s, base, basePath); err != nil {
 		return nil, fmt.Errorf("failure checking out %q to a temporary path for the merge helper: %v", base, err)
 	}
 	destPath := tmpPath.Join(snapshot.Path("dest")).Join(p)
-	if err := Checkout(ctx, s, dest, destPath); err != nil {
+	if err := Extract(ctx, s, dest, destPath); err != nil {
 		return nil, fmt.Errorf("failure checking out %q to a temporary path for the merge helper: %v", dest, err)
 	}
-	args = append(args, string(srcPath), string(basePath), string(destPath))
+	args = append(append([]string(nil), args...), string(srcPath), string(basePath), string(destPath))
 
-	helperCtx, cancel := context.WithTimeout(ctx, time.Second)
+	timeout, err := helperTimeout()
+	if err != nil {
+		return nil, err
+	}
+	helperCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	out, err := exec.CommandContext(helperCtx, helperCmd, args...).Output()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if helperCtx.Err() != nil {
+		return nil, fmt.Errorf("merge helper %q timed out after %v", helperCmd, timeout)
+	}
+	var exitErr *exec.ExitError
+	if err != nil && (!errors.As(err, &exitErr) || exitErr.ExitCode() <= 0) {
+		// The helper could not be run, or did not exit normally, which
+		// is a problem with the helper rather than a conflict. Only a
+		// non-zero exit code reports that the files conflict.
+		return nil, fmt.Errorf("failure running the merge helper %q: %v", helperCmd, err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("merge helper %q failed: %v", helperCmd, err)
+		conflict := &conflictError{
+			kind:   ConflictVersions,
+			reason: fmt.Sprintf("merge helper %q failed: %v", helperCmd, err),
+		}
+		if exitErr.ExitCode() == 1 && len(out) > 0 {
+			// By convention, an exit code of 1 means the output
+			// has the conflicting changes marked in it.
+			conflict.kind = ConflictMarkers
+			conflict.markers = out
+		}
+		return nil, conflict
 	}
 	contentsHash, err := s.StoreObject(ctx, int64(len(out)), bytes.NewReader(out))
 	if err != nil {
@@ -99,10 +152,5 @@ func mergeWithHelper(ctx context.Context, s *storage.LocalFiles, p snapshot.Path
 		Contents: contentsHash,
 		Parents:  []*snapshot.Hash{src, dest},
 	}
-	fileBytes := []byte(mergedFile.String())
-	h, err := s.StoreObject(ctx, int64(len(fileBytes)), bytes.NewReader(fileBytes))
-	if err != nil {
-		return nil, fmt.Errorf("failure storing the merged snapshot: %v", err)
-	}
-	return h, nil
+	return storeMerged(ctx, s, mergedFile, opts)
 }
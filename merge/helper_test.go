@@ -17,6 +17,7 @@ package merge
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -26,6 +27,8 @@ import (
 )
 
 func TestMergeWithHelperNoConflict(t *testing.T) {
+	t.Setenv(HelperEnvironmentVariable, "diff3")
+	t.Setenv(HelperArgsEnvironmentVariable, `["-m", "-L", "source", "-L", "base", "-L", "destination"]`)
 	dir := t.TempDir()
 	archive := filepath.Join(dir, "archive")
 	s := &storage.LocalFiles{ArchiveDir: archive}
@@ -76,7 +79,7 @@ func TestMergeWithHelperNoConflict(t *testing.T) {
 		t.Fatalf("unexpected nil hash for the file")
 	}
 
-	h4, err := mergeWithHelper(context.Background(), s, originalPath, "-rwx------", h1, h2, h3)
+	h4, err := mergeWithHelper(context.Background(), s, originalPath, "-rwx------", h1, h2, h3, &mergeOptions{})
 	if err != nil {
 		t.Fatalf("failure merging non-conflicting changes with the helper: %v", err)
 	}
@@ -96,6 +99,8 @@ func TestMergeWithHelperNoConflict(t *testing.T) {
 }
 
 func TestMergeWithHelperNilBaseNoConflict(t *testing.T) {
+	t.Setenv(HelperEnvironmentVariable, "diff3")
+	t.Setenv(HelperArgsEnvironmentVariable, `["-m", "-L", "source", "-L", "base", "-L", "destination"]`)
 	dir := t.TempDir()
 	archive := filepath.Join(dir, "archive")
 	s := &storage.LocalFiles{ArchiveDir: archive}
@@ -127,9 +132,54 @@ func TestMergeWithHelperNilBaseNoConflict(t *testing.T) {
 		t.Fatalf("unexpected nil hash for the file")
 	}
 
-	if mergedHash, err := mergeWithHelper(context.Background(), s, originalPath, "-rwx------", nil, v1Hash, v2Hash); err == nil {
-		t.Errorf("unexpected result from merging unrelated files with the default merge helper: %v", mergedHash)
+	if mergedHash, err := mergeWithHelper(context.Background(), s, originalPath, "-rwx------", nil, v1Hash, v2Hash, &mergeOptions{}); err == nil {
+		t.Errorf("unexpected result from merging unrelated files with the diff3 merge helper: %v", mergedHash)
 	} else if got, want := err.Error(), "merge helper \"diff3\" failed: exit status 1"; got != want {
-		t.Errorf("unexexpected error message from merging unrelated files with the default merge helper: got %q, want %q", got, want)
+		t.Errorf("unexexpected error message from merging unrelated files with the diff3 merge helper: got %q, want %q", got, want)
+	}
+}
+
+func TestMergeWithHelperFailures(t *testing.T) {
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+	ctx := context.Background()
+	snapshotFile := func(name, contents string) *snapshot.Hash {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(contents), 0700); err != nil {
+			t.Fatalf("failure writing %q: %v", p, err)
+		}
+		h, _, err := snapshot.Current(ctx, s, snapshot.Path(p))
+		if err != nil {
+			t.Fatalf("failure snapshotting %q: %v", p, err)
+		}
+		return h
+	}
+	base := snapshotFile("base.txt", "base\n")
+	src := snapshotFile("src.txt", "source\n")
+	dest := snapshotFile("dest.txt", "destination\n")
+	p := snapshot.Path(filepath.Join(dir, "merged.txt"))
+
+	// A helper that cannot be run is an error rather than a conflict.
+	t.Setenv(HelperEnvironmentVariable, filepath.Join(dir, "missing-helper"))
+	_, err := mergeWithHelper(ctx, s, p, "-rwx------", base, src, dest, &mergeOptions{})
+	var conflict *conflictError
+	if err == nil || errors.As(err, &conflict) {
+		t.Errorf("unexpected result from a missing merge helper: %v", err)
+	}
+
+	// A helper that exits with a non-zero code reports a conflict.
+	t.Setenv(HelperEnvironmentVariable, "false")
+	_, err = mergeWithHelper(ctx, s, p, "-rwx------", base, src, dest, &mergeOptions{})
+	if !errors.As(err, &conflict) || conflict.kind != ConflictVersions {
+		t.Errorf("unexpected result from a failing merge helper: %v", err)
+	}
+
+	// A helper that runs for too long is an error rather than a conflict.
+	t.Setenv(HelperEnvironmentVariable, "sh")
+	t.Setenv(HelperArgsEnvironmentVariable, `["-c", "exec sleep 10", "helper"]`)
+	t.Setenv(HelperTimeoutEnvironmentVariable, "100ms")
+	_, err = mergeWithHelper(ctx, s, p, "-rwx------", base, src, dest, &mergeOptions{})
+	if err == nil || errors.As(err, &conflict) {
+		t.Errorf("unexpected result from a merge helper that timed out: %v", err)
 	}
 }
@@ -0,0 +1,120 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"github.com/google/recursive-version-control-system/diff"
+)
+
+// lineChunk is a contiguous range of lines in a three-way merge of lines.
+type lineChunk struct {
+	// conflict reports whether or not both sides changed the lines of
+	// the chunk differently.
+	conflict bool
+
+	// lines holds the merged lines for chunks without a conflict.
+	lines []string
+
+	// base, src, and dest hold the lines of each version for chunks
+	// with a conflict.
+	base, src, dest []string
+}
+
+// matchedLines returns, for each line of `base` that is unchanged in
+// `other`, the index of that line in `other`.
+func matchedLines(base, other []string) map[int]int {
+	matches := make(map[int]int)
+	for _, e := range diff.Lines(base, other) {
+		if e.Op == diff.Equal {
+			matches[e.OldLine] = e.NewLine
+		}
+	}
+	return matches
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, line := range a {
+		if line != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeLines performs a three-way merge of the lines in `src` and `dest`
+// relative to their common ancestor `base`.
+//
+// The lines of `base` that are unchanged in both versions split the three
+// versions into chunks. Each chunk changed by only one side (or changed the
+// same way by both) takes that side's lines, and every other chunk is a
+// conflict.
+func mergeLines(base, src, dest []string) []*lineChunk {
+	srcMatches := matchedLines(base, src)
+	destMatches := matchedLines(base, dest)
+	var chunks []*lineChunk
+	addLines := func(lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		if len(chunks) > 0 && !chunks[len(chunks)-1].conflict {
+			last := chunks[len(chunks)-1]
+			last.lines = append(last.lines, lines...)
+			return
+		}
+		chunks = append(chunks, &lineChunk{lines: append([]string(nil), lines...)})
+	}
+	var i, j, k int
+	for i < len(base) || j < len(src) || k < len(dest) {
+		if i < len(base) {
+			if sj, ok := srcMatches[i]; ok && sj == j {
+				if dk, ok := destMatches[i]; ok && dk == k {
+					addLines(base[i : i+1])
+					i, j, k = i+1, j+1, k+1
+					continue
+				}
+			}
+		}
+		// Find the next base line that is unchanged in both versions.
+		nextI, nextJ, nextK := len(base), len(src), len(dest)
+		for b := i; b < len(base); b++ {
+			sj, srcOK := srcMatches[b]
+			dk, destOK := destMatches[b]
+			if srcOK && destOK && sj >= j && dk >= k {
+				nextI, nextJ, nextK = b, sj, dk
+				break
+			}
+		}
+		baseChunk, srcChunk, destChunk := base[i:nextI], src[j:nextJ], dest[k:nextK]
+		switch {
+		case equalLines(srcChunk, baseChunk):
+			addLines(destChunk)
+		case equalLines(destChunk, baseChunk), equalLines(srcChunk, destChunk):
+			addLines(srcChunk)
+		default:
+			chunks = append(chunks, &lineChunk{
+				conflict: true,
+				base:     baseChunk,
+				src:      srcChunk,
+				dest:     destChunk,
+			})
+		}
+		i, j, k = nextI, nextJ, nextK
+	}
+	return chunks
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// sameLink reports whether or not the two file snapshots are both
+// symbolic links with the same target.
+func sameLink(a, b *snapshot.File) bool {
+	return a.IsLink() && b.IsLink() && a.Contents.Equal(b.Contents)
+}
+
+// linkTarget reads the target of the given symbolic link snapshot.
+func linkTarget(ctx context.Context, s *storage.LocalFiles, f *snapshot.File) (string, error) {
+	r, err := s.ReadObject(ctx, f.Contents)
+	if err != nil {
+		return "", fmt.Errorf("failure opening the target of the link %q: %v", f.Contents, err)
+	}
+	defer r.Close()
+	target, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failure reading the target of the link %q: %v", f.Contents, err)
+	}
+	return string(target), nil
+}
+
+// mergeLinks merges the given snapshots, both of which are symbolic links,
+// by comparing their link targets against the base.
+//
+// If only one side changed the target relative to the base, then that side
+// is taken, and if both sides changed a link to the same target, then that
+// target is taken. Anything else is a conflict.
+func mergeLinks(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, baseFile, srcFile, destFile *snapshot.File, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	var merged *snapshot.File
+	switch {
+	case sameLink(srcFile, destFile), sameLink(baseFile, srcFile):
+		merged = destFile
+	case sameLink(baseFile, destFile):
+		merged = srcFile
+	}
+	if merged != nil {
+		return storeMerged(ctx, s, &snapshot.File{
+			Mode:     merged.Mode,
+			Contents: merged.Contents,
+			Parents:  []*snapshot.Hash{src, dest},
+		}, opts)
+	}
+	srcTarget, err := linkTarget(ctx, s, srcFile)
+	if err != nil {
+		return nil, err
+	}
+	destTarget, err := linkTarget(ctx, s, destFile)
+	if err != nil {
+		return nil, err
+	}
+	return nil, &conflictError{
+		kind:   ConflictVersions,
+		reason: fmt.Sprintf("the symlink at %q was changed to point to %q in the source and to %q in the destination, so the two snapshots for that path have to be manually merged", subPath, srcTarget, destTarget),
+	}
+}
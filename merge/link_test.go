@@ -0,0 +1,97 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func TestMergeLinks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+
+	srcDir := filepath.Join(dir, "src")
+	destDir := filepath.Join(dir, "dest")
+	for _, sub := range []string{"sub", "sub2"} {
+		if err := os.MkdirAll(filepath.Join(srcDir, sub), 0700); err != nil {
+			t.Fatalf("failure creating the linked directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(srcDir, sub, "f.txt"), []byte(sub), 0700); err != nil {
+			t.Fatalf("failure writing a file in the linked directory: %v", err)
+		}
+	}
+	base := writeAndSnapshot(ctx, t, s, srcDir, map[string]string{
+		"todir":    "link:sub",
+		"broken":   "link:missing",
+		"div":      "link:a",
+		"filelink": "link:a",
+	})
+	if _, err := Merge(ctx, s, base, snapshot.Path(destDir), nil); err != nil {
+		t.Fatalf("failure checking out the base: %v", err)
+	}
+
+	// Each update is snapshotted, so that a link changed and then changed
+	// back has a different hash than its base despite the same target.
+	//
+	// The source only changes the link to a directory temporarily, while
+	// the destination retargets it, so the destination target is used.
+	writeAndSnapshot(ctx, t, s, srcDir, map[string]string{"todir": "link:elsewhere", "broken": "link:tmp"})
+	writeAndSnapshot(ctx, t, s, srcDir, map[string]string{"todir": "link:sub", "broken": "link:missing-too", "div": "link:b", "filelink": "contents"})
+	src := writeAndSnapshot(ctx, t, s, srcDir, nil)
+	writeAndSnapshot(ctx, t, s, destDir, map[string]string{"todir": "link:sub2", "broken": "link:missing-too", "div": "link:c", "filelink": "link:c"})
+
+	_, err := Merge(ctx, s, src, snapshot.Path(destDir), nil)
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("unexpected result merging the links: %v", err)
+	}
+	wantConflicts := map[snapshot.Path]string{"div": ConflictVersions, "filelink": ConflictType}
+	if len(conflictErr.Conflicts) != len(wantConflicts) {
+		t.Errorf("unexpected conflicts: %+v", conflictErr.Conflicts)
+	}
+	for _, c := range conflictErr.Conflicts {
+		if c.Kind != wantConflicts[c.Path] {
+			t.Errorf("unexpected conflict: %+v", c)
+		}
+	}
+	for name, want := range map[string]string{
+		"todir":              "sub2",
+		"broken":             "missing-too",
+		"div":                "c",
+		"div" + SourceSuffix: "b",
+		"filelink":           "c",
+	} {
+		if got, err := os.Readlink(filepath.Join(destDir, name)); err != nil {
+			t.Errorf("failure reading the merged link %q: %v", name, err)
+		} else if got != want {
+			t.Errorf("unexpected target for the merged link %q: got %q, want %q", name, got, want)
+		}
+	}
+	if contents, err := os.ReadFile(filepath.Join(destDir, "filelink"+SourceSuffix)); err != nil || string(contents) != "contents" {
+		t.Errorf("unexpected source version of the file replacing a link: %q, %v", contents, err)
+	}
+	if contents, err := os.ReadFile(filepath.Join(destDir, "todir", "f.txt")); err != nil || string(contents) != "sub2" {
+		t.Errorf("unexpected contents through the merged link to a directory: %q, %v", contents, err)
+	}
+}
@@ -24,29 +24,147 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/google/recursive-version-control-system/log"
+	"github.com/google/recursive-version-control-system/progress"
 	"github.com/google/recursive-version-control-system/snapshot"
 	"github.com/google/recursive-version-control-system/storage"
 )
 
-func IsAncestor(ctx context.Context, s *storage.LocalFiles, base, h *snapshot.Hash) (bool, error) {
+// mergeOptions holds the settings that apply to every nested path of a merge.
+type mergeOptions struct {
+	// forceKeepMode specifies that the source file mode should be used
+	// whenever the source and destination modes differ.
+	forceKeepMode bool
+
+	// virtualBases holds every snapshot created while merging multiple
+	// merge bases together into a single, virtual merge base.
+	//
+	// These snapshots are not ancestors of either side of the merge, so
+	// instead they are treated as an ancestor of any snapshot that has
+	// all of their parents as ancestors.
+	virtualBases map[snapshot.Hash]struct{}
+
+	// recordVirtual specifies that the merged snapshots being created
+	// should be added to `virtualBases`.
+	recordVirtual bool
+
+	// recordConflicts specifies that conflicting nested paths should be
+	// written into the merged snapshot and added to `conflicts`, rather
+	// than failing the merge.
+	recordConflicts bool
+
+	// conflicts holds every conflict recorded so far, with absolute paths.
+	conflicts []*storage.MergeConflict
+
+	// resolutions holds every deletion, rollback, or rename that was
+	// automatically resolved so far, with absolute paths.
+	resolutions []*Resolution
+
+	// renamedFrom and renamedTo hold the files renamed on one side of
+	// the merge and changed on the other, keyed by the absolute paths
+	// they were renamed from and to, respectively.
+	//
+	// Entries are removed from `renamedTo` once they have been merged.
+	renamedFrom map[snapshot.Path]*rename
+	renamedTo   map[snapshot.Path]*rename
+
+	// root is the path of the merge destination, which the paths matched
+	// against the rules in `strategies` are relative to.
+	root snapshot.Path
+
+	// strategies configures how to merge nested paths changed on both
+	// sides. If nil, then the defaults are used.
+	strategies *Options
+}
+
+// storeMerged stores the given merged file snapshot.
+func storeMerged(ctx context.Context, s *storage.LocalFiles, mergedFile *snapshot.File, opts *mergeOptions) (*snapshot.Hash, error) {
+	fileBytes := []byte(mergedFile.String())
+	h, err := s.StoreObject(ctx, int64(len(fileBytes)), bytes.NewReader(fileBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failure storing the merged snapshot: %v", err)
+	}
+	if opts.recordVirtual {
+		opts.virtualBases[*h] = struct{}{}
+	}
+	return h, nil
+}
+
+// isBaseAncestor reports whether or not the given merge base, which might
+// be a virtual merge base, is an ancestor of `h`.
+func isBaseAncestor(ctx context.Context, s *storage.LocalFiles, base, h *snapshot.Hash, opts *mergeOptions) (bool, error) {
 	if base == nil {
-		// The nil snapshot is an ancestor of all other snapshots.
 		return true, nil
 	}
-	snapshotLog, err := log.ReadLog(ctx, s, h, -1)
+	if _, ok := opts.virtualBases[*base]; !ok {
+		return IsAncestor(ctx, s, base, h)
+	}
+	baseFile, err := s.ReadSnapshot(ctx, base)
 	if err != nil {
-		return false, fmt.Errorf("failure reading the log for %q: %v", h, err)
+		return false, fmt.Errorf("failure reading the virtual merge base %q: %v", base, err)
 	}
-	for _, e := range snapshotLog {
-		if e.Hash.Equal(base) {
-			return true, nil
+	for _, parent := range baseFile.Parents {
+		if isAncestor, err := isBaseAncestor(ctx, s, parent, h, opts); err != nil || !isAncestor {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// virtualBase merges the given list of merge bases into a single base.
+//
+// The bases are merged pairwise, using the best common ancestors of each
+// pair (themselves recursively merged) as the base for that pair.
+//
+// If the bases conflict with each other, then there is no single version
+// that they all build on, so the first of the given bases is used instead.
+// Any other failure is returned as an error.
+func virtualBase(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, bases []*snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	virtualOpts := &mergeOptions{
+		forceKeepMode: opts.forceKeepMode,
+		virtualBases:  opts.virtualBases,
+		recordVirtual: true,
+		root:          opts.root,
+		strategies:    opts.strategies,
+	}
+	merged := bases[0]
+	for _, next := range bases[1:] {
+		pairBases, err := Bases(ctx, s, merged, next)
+		if err != nil {
+			return nil, fmt.Errorf("failure determining the merge bases for %q and %q: %v", merged, next, err)
+		}
+		var pairBase *snapshot.Hash
+		if len(pairBases) > 1 {
+			pairBase, err = virtualBase(ctx, s, subPath, pairBases, opts)
+			if err != nil {
+				return nil, err
+			}
+		} else if len(pairBases) == 1 {
+			pairBase = pairBases[0]
+		}
+		merged, err = mergeWithBase(ctx, s, subPath, pairBase, next, merged, virtualOpts)
+		var conflictErr *conflictError
+		if errors.As(err, &conflictErr) {
+			return bases[0], nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failure merging the merge bases for %q: %v", subPath, err)
 		}
 	}
-	return false, nil
+	return merged, nil
 }
 
-func mergeWithBase(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, base, src, dest *snapshot.Hash, forceKeepMode bool) (*snapshot.Hash, error) {
+func mergeWithBase(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, base, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	// Files renamed on one side are merged at their new path with the
+	// changes made on the other side, so their old path is dropped.
+	if _, ok := opts.renamedFrom[subPath]; ok {
+		return nil, nil
+	}
+	if r, ok := opts.renamedTo[subPath]; ok {
+		return mergeRenamed(ctx, s, subPath, r, opts)
+	}
+	if opts.hasRenameUnder(subPath) {
+		return mergeRenameParent(ctx, s, subPath, base, src, dest, opts)
+	}
+
 	// First we handle the trivial cases where the merge result should
 	// just be one of the two provided snapshots.
 	if src.Equal(dest) {
@@ -58,27 +176,24 @@ func mergeWithBase(ctx context.Context, s *storage.LocalFiles, subPath snapshot.
 	if dest.Equal(base) {
 		return src, nil
 	}
+	if merged, resolved, err := mergeWithStrategy(ctx, s, subPath, base, src, dest, opts); err != nil || resolved {
+		return merged, err
+	}
 
-	// If either the source or destination do not have the base as an
-	// ancestor, then that means the changes in the base were rolled back
-	// in that version. In that case, we have to ask the user to manually
-	// merge the two versions.
+	// A path deleted on only one side is either cleanly deleted, or
+	// conflicts with the changes made to it on the other side.
 	if src == nil || dest == nil {
-		return nil, fmt.Errorf("the nested snapshot under the path %q was deleted in either the source or destination snapshot, so the two snapshots have to be manually merged", subPath)
-	}
-	if isAncestor, err := IsAncestor(ctx, s, base, src); err != nil {
-		return nil, err
-	} else if !isAncestor {
-		// The changes from the base snapshot were rolled back in
-		// the source...
-		return nil, fmt.Errorf("nested changes under the path %q were rolled back in the source snapshot, so the two snapshots have to be manually merged", subPath)
+		return mergeDeleted(ctx, s, subPath, base, src, dest, opts)
 	}
-	if isAncestor, err := IsAncestor(ctx, s, base, dest); err != nil {
+
+	// If either the source or destination do not have the base as an
+	// ancestor, then that means the changes in the base were rolled back
+	// in that version. In that case, the merge base is recomputed from
+	// the histories of the two versions.
+	if newBase, rolledBack, err := recomputeBase(ctx, s, subPath, base, src, dest, opts); err != nil {
 		return nil, err
-	} else if !isAncestor {
-		// The changes from the base snapshot were rolled back in
-		// the destination...
-		return nil, fmt.Errorf("nested changes under the path %q were rolled back in the destination snapshot, so the two snapshots have to be manually merged", subPath)
+	} else if rolledBack {
+		return mergeWithBase(ctx, s, subPath, newBase, src, dest, opts)
 	}
 
 	// For everything else we have to compare the actual snapshots, so
@@ -99,26 +214,47 @@ func mergeWithBase(ctx context.Context, s *storage.LocalFiles, subPath snapshot.
 		}
 	}
 
-	// If either the source or the destination are symbolic links, then
-	// the user has to manually merge them.
-	if srcFile.IsLink() || destFile.IsLink() {
-		return nil, fmt.Errorf("one or both versions of the snapshot at %q represent a symlink, so the two snapshots for that path have to be manually merged", subPath)
+	// If the source and destination are different types of files, then
+	// only one of them can be kept.
+	if fileType(srcFile) != fileType(destFile) {
+		return mergeTypes(ctx, s, subPath, baseFile, srcFile, destFile, src, dest, opts)
 	}
 
-	if !(srcFile.IsDir() && destFile.IsDir()) {
-		return mergeWithHelper(ctx, s, subPath, destFile.Mode, base, src, dest)
+	// If both the source and the destination are symbolic links, then
+	// they are merged by comparing their targets.
+	if srcFile.IsLink() {
+		return mergeLinks(ctx, s, subPath, baseFile, srcFile, destFile, src, dest, opts)
 	}
 
-	// Both source and destination are directories, so we recursively
-	// merge every nested path under either of them using the corresponding
-	// nested path from the base as a reference point.
-	srcTree, err := s.ListDirectorySnapshotContents(ctx, src, srcFile)
-	if err != nil {
-		return nil, fmt.Errorf("failure reading the tree for the snapshot %q: %v", src, err)
+	if !srcFile.IsDir() {
+		return mergeFiles(ctx, s, subPath, destFile.Mode, base, src, dest, opts)
 	}
-	destTree, err := s.ListDirectorySnapshotContents(ctx, dest, destFile)
-	if err != nil {
-		return nil, fmt.Errorf("failure reading the tree for the snapshot %q: %v", dest, err)
+
+	return mergeDirs(ctx, s, subPath, baseFile, srcFile, destFile, base, src, dest, opts)
+}
+
+// mergeDirs merges two versions of a directory by recursively merging
+// every nested path under either of them, using the corresponding nested
+// path from the base as a reference point.
+//
+// One of the two versions may be nil if it was deleted, in which case it
+// is treated as an empty directory and the merged directory is only kept
+// if some of its nested paths were kept.
+func mergeDirs(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, baseFile, srcFile, destFile *snapshot.File, base, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	var err error
+	srcTree := make(snapshot.Tree)
+	if srcFile != nil {
+		srcTree, err = s.ListDirectorySnapshotContents(ctx, src, srcFile)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading the tree for the snapshot %q: %v", src, err)
+		}
+	}
+	destTree := make(snapshot.Tree)
+	if destFile != nil {
+		destTree, err = s.ListDirectorySnapshotContents(ctx, dest, destFile)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading the tree for the snapshot %q: %v", dest, err)
+		}
 	}
 	var baseTree snapshot.Tree
 	if baseFile.IsDir() {
@@ -140,13 +276,29 @@ func mergeWithBase(ctx context.Context, s *storage.LocalFiles, subPath snapshot.
 	for p, _ := range destTree {
 		subpaths[p] = struct{}{}
 	}
+	// Sidecars for conflicting paths must not replace any other path in
+	// the directory, or each other.
+	taken := func(name snapshot.Path) bool {
+		_, inSides := subpaths[name]
+		_, inMerged := mergedTree[name]
+		return inSides || inMerged
+	}
 	var nestedErrors []string
 	for p, _ := range subpaths {
 		childSubPath := subPath.Join(p)
 		childBase := baseTree[p]
 		childSrc := srcTree[p]
 		childDest := destTree[p]
-		mergedChild, err := mergeWithBase(ctx, s, childSubPath, childBase, childSrc, childDest, forceKeepMode)
+		mergedChild, err := mergeWithBase(ctx, s, childSubPath, childBase, childSrc, childDest, opts)
+		var conflict *conflictError
+		if err != nil && opts.recordConflicts && errors.As(err, &conflict) {
+			var sidecar snapshot.Path
+			var sidecarHash *snapshot.Hash
+			mergedChild, sidecar, sidecarHash, err = recordConflict(ctx, s, childSubPath, childSrc, childDest, conflict, taken, opts)
+			if sidecarHash != nil {
+				mergedTree[snapshot.Path(filepath.Base(string(sidecar)))] = sidecarHash
+			}
+		}
 		if err != nil {
 			nestedErrors = append(nestedErrors, err.Error())
 		}
@@ -154,73 +306,181 @@ func mergeWithBase(ctx context.Context, s *storage.LocalFiles, subPath snapshot.
 			mergedTree[p] = mergedChild
 		}
 	}
-	if srcFile.Mode != destFile.Mode && !forceKeepMode {
-		nestedErrors = append(nestedErrors, fmt.Sprintf("file permissions for %q do not match between versions; source mode line: %q, destination mode line %q. Manually update the permissions for the source to match what you want for the merge result, and then re-run the merge with the option to force using the source permissions", subPath, srcFile.Mode, destFile.Mode))
+	var mergedMode string
+	var parents []*snapshot.Hash
+	switch {
+	case srcFile == nil:
+		// The directory was deleted in the source, so only the nested
+		// paths that were changed in the destination are kept.
+		mergedMode, parents = destFile.Mode, []*snapshot.Hash{dest}
+	case destFile == nil:
+		mergedMode, parents = srcFile.Mode, []*snapshot.Hash{src}
+	default:
+		mergedMode, parents = srcFile.Mode, []*snapshot.Hash{src, dest}
+		if srcFile.Mode != destFile.Mode && !opts.forceKeepMode {
+			reason := fmt.Sprintf("file permissions for %q do not match between versions; source mode line: %q, destination mode line %q. Manually update the permissions for the source to match what you want for the merge result, and then re-run the merge with the option to force using the source permissions", subPath, srcFile.Mode, destFile.Mode)
+			if opts.recordConflicts {
+				mergedMode = destFile.Mode
+				opts.conflicts = append(opts.conflicts, &storage.MergeConflict{
+					Path:   subPath,
+					Kind:   ConflictMode,
+					Reason: reason,
+				})
+			} else {
+				nestedErrors = append(nestedErrors, reason)
+			}
+		}
 	}
 	if len(nestedErrors) > 0 {
 		return nil, errors.New(strings.Join(nestedErrors, "\n"))
 	}
+	if len(mergedTree) == 0 && len(parents) == 1 {
+		// Every nested path was deleted along with the directory.
+		return nil, nil
+	}
 
-	contentsBytes := []byte(mergedTree.String())
-	contentsHash, err := s.StoreObject(ctx, int64(len(contentsBytes)), bytes.NewReader(contentsBytes))
+	contentsHash, err := snapshot.StoreTree(ctx, s, mergedTree)
 	if err != nil {
 		return nil, fmt.Errorf("failure storing the contents of a merged tree: %v", err)
 	}
 	mergedFile := &snapshot.File{
-		Mode:     srcFile.Mode,
+		Mode:     mergedMode,
 		Contents: contentsHash,
-		Parents:  []*snapshot.Hash{src, dest},
+		Parents:  parents,
 	}
-	fileBytes := []byte(mergedFile.String())
-	h, err := s.StoreObject(ctx, int64(len(fileBytes)), bytes.NewReader(fileBytes))
+	return storeMerged(ctx, s, mergedFile, opts)
+}
+
+// mergeSnapshots merges the snapshot `src` with the snapshot `dest`, which
+// is the latest snapshot of the path `destPath`, returning the merged snapshot
+// along with the merge base that was used.
+//
+// If the two snapshots have multiple best common ancestors, then those are
+// first merged together into a virtual merge base.
+//
+// If `opts.recordConflicts` is set, then any conflicts are written into the
+// merged snapshot and recorded in `opts.conflicts`.
+func mergeSnapshots(ctx context.Context, s *storage.LocalFiles, destPath snapshot.Path, src, dest *snapshot.Hash, opts *mergeOptions) (merged, mergeBase *snapshot.Hash, err error) {
+	bases, err := Bases(ctx, s, src, dest)
 	if err != nil {
-		return nil, fmt.Errorf("failure storing the merged snapshot: %v", err)
+		return nil, nil, fmt.Errorf("failure determining the merge bases for %q and %q: %v", src, dest, err)
 	}
-	return h, nil
+	if opts.virtualBases == nil {
+		opts.virtualBases = make(map[snapshot.Hash]struct{})
+	}
+	if len(opts.root) == 0 {
+		opts.root = destPath
+	}
+	if len(bases) > 1 {
+		mergeBase, err = virtualBase(ctx, s, destPath, bases, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if len(bases) == 1 {
+		mergeBase = bases[0]
+	}
+	if mergeBase.Equal(src) {
+		// The source has already been merged in
+		return dest, mergeBase, nil
+	}
+	if err := detectRenames(ctx, s, destPath, mergeBase, src, dest, opts); err != nil {
+		return nil, nil, err
+	}
+	merged, err = mergeWithBase(ctx, s, destPath, mergeBase, src, dest, opts)
+	var conflict *conflictError
+	if err != nil && opts.recordConflicts && errors.As(err, &conflict) && conflict.kind == ConflictMarkers {
+		// The conflict is for the destination itself, so there is
+		// no place to write a separate version alongside it and only
+		// conflict markers can be recorded.
+		merged, _, _, err = recordConflict(ctx, s, destPath, src, dest, conflict, nil, opts)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return merged, mergeBase, nil
 }
 
 // Merge attempts to automatically merge the given snapshot into the local
 // filesystem at the specified destination path.
 //
-// If there are any conflicts between the specified snapshot and the local
-// filesystem contents, then the `Merge` method retursn an error without
-// modifying the local filesystem.
+// If some nested paths have conflicting changes, then the merge result is
+// still written to the destination, but with those conflicts written into
+// it either as conflict markers inside the conflicting files, or as extra
+// files alongside them holding the source versions. In that case, the state
+// of the merge is recorded, and a `*ConflictError` is returned listing the
+// conflicts. The merge can then be completed using `Continue` once the
+// conflicts have been resolved, or undone using `Abort`.
+//
+// If the conflicts cannot be written into the destination, then the `Merge`
+// method returns an error without modifying the local filesystem.
+//
+// Only the nested paths whose merged snapshots differ from the destination's
+// previous snapshot are written. If any of those were modified while the
+// merge was being computed, then the merge stops with a `*ModifiedError`
+// rather than overwriting those modifications. If writing the merge result
+// fails for any reason, then no merge state is recorded.
+//
+// The returned `Preview` describes the merge, including any paths that
+// were deleted or rolled back on one side and automatically resolved. Its
+// `Changes` field is not populated.
+//
+// Progress writing the merge result is reported to any `progress.Reporter`
+// carried by the context. Progress snapshotting the destination beforehand
+// is not reported, so callers that want to report it can snapshot the
+// destination using `snapshot.Current` before calling this.
 //
 // In case there are no conflicts but the local storage is missing some
 // referenced snapshots, then it is possible for this method to both modify
 // the local filesystem contents *and* to also return an error. In that case
 // the previous version of the local filesystem contents will be retrievable
 // using the `rvcs log` command.
-func Merge(ctx context.Context, s *storage.LocalFiles, src *snapshot.Hash, dest snapshot.Path) error {
+func Merge(ctx context.Context, s *storage.LocalFiles, src *snapshot.Hash, dest snapshot.Path, opts *Options) (*Preview, error) {
+	if state, err := s.ReadMergeState(ctx, dest); err != nil {
+		return nil, err
+	} else if state != nil {
+		return nil, fmt.Errorf("a merge into %q is already in progress; either continue or abort it first", dest)
+	}
 	destParent := filepath.Dir(string(dest))
 	if err := os.MkdirAll(destParent, os.FileMode(0700)); err != nil {
-		return fmt.Errorf("failure ensuring the parent directory of %q exists: %v", dest, err)
+		return nil, fmt.Errorf("failure ensuring the parent directory of %q exists: %v", dest, err)
 	}
-	destPrevHash, _, err := snapshot.Current(ctx, s, dest)
+	p, err := computeMerge(progress.WithReporter(ctx, nil), s, src, dest, opts, true)
 	if err != nil {
-		return fmt.Errorf("failure generating snapshot of destination %q prior to merging: %v", dest, err)
+		return nil, err
 	}
-	if destPrevHash == nil {
+	if p.Destination == nil {
 		// The destination does not exist; simply check out the source hash there.
-		return Checkout(ctx, s, src, dest)
-	}
-	mergeBase, err := Base(ctx, s, src, destPrevHash)
-	if err != nil {
-		return fmt.Errorf("failure determining the merge base for %q and %q: %v", src, destPrevHash, err)
+		return p, Checkout(ctx, s, src, dest)
 	}
-	if mergeBase.Equal(src) {
+	if p.Merged.Equal(p.Destination) {
 		// The source has already been merged in
-		return nil
-	}
-
-	mergedHash, err := mergeWithBase(ctx, s, dest, mergeBase, src, destPrevHash, false)
-	if err != nil {
-		return fmt.Errorf("unable to automatically merge the two snapshots: %v", err)
+		return p, nil
+	}
+	var conflictErr error
+	if len(p.Conflicts) > 0 {
+		state := &storage.MergeState{
+			Base:        p.Base,
+			Source:      src,
+			Destination: p.Destination,
+			Conflicts:   p.Conflicts,
+		}
+		if err := s.WriteMergeState(ctx, dest, state); err != nil {
+			return nil, fmt.Errorf("failure recording the conflicts merging into %q: %v", dest, err)
+		}
+		conflictErr = &ConflictError{Path: dest, Conflicts: p.Conflicts}
 	}
 
 	// Update the destination to point to the merged snapshot
-	if err := os.RemoveAll(string(dest)); err != nil {
-		return fmt.Errorf("failure updating %q to point to newer snapshot %q; failure removing old files: %v", dest, mergedHash, err)
+	if err := Update(ctx, s, p.Destination, p.Merged, dest); err != nil {
+		// The conflicts were not all written, so the merge cannot be
+		// continued. Any changes that were written can be undone using
+		// the history of the destination.
+		if conflictErr != nil {
+			if rmErr := s.RemoveMergeState(ctx, dest); rmErr != nil {
+				return nil, fmt.Errorf("failure updating %q to point to newer snapshot %q: %v (and removing the merge state failed: %v)", dest, p.Merged, err, rmErr)
+			}
+		}
+		return nil, fmt.Errorf("failure updating %q to point to newer snapshot %q: %v", dest, p.Merged, err)
 	}
-	return Checkout(ctx, s, mergedHash, dest)
+	return p, conflictErr
 }
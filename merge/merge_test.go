@@ -17,10 +17,15 @@ package merge
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/recursive-version-control-system/progress"
 	"github.com/google/recursive-version-control-system/snapshot"
 	"github.com/google/recursive-version-control-system/storage"
 )
@@ -49,7 +54,7 @@ func TestMergeRegularFile(t *testing.T) {
 	clone := filepath.Join(dir, "clone.txt")
 	clonePath := snapshot.Path(clone)
 
-	if err := Merge(context.Background(), s, h1, clonePath); err != nil {
+	if _, err := Merge(context.Background(), s, h1, clonePath, nil); err != nil {
 		t.Fatalf("failure checking out the file snapshot %q: %v", h1, err)
 	}
 
@@ -93,7 +98,7 @@ func TestMergeSymlink(t *testing.T) {
 
 	clone := filepath.Join(dir, "clone.txt")
 	clonePath := snapshot.Path(clone)
-	if err := Merge(context.Background(), s, h1, clonePath); err != nil {
+	if _, err := Merge(context.Background(), s, h1, clonePath, nil); err != nil {
 		t.Fatalf("failure checking out the symlink snapshot %q: %v", h1, err)
 	}
 
@@ -142,7 +147,7 @@ func TestMergeExcludedDir(t *testing.T) {
 		t.Error("unexpectedly included the storage archive in the snapshot")
 	}
 
-	if err := Merge(context.Background(), s, h1, dirPath); err != nil {
+	if _, err := Merge(context.Background(), s, h1, dirPath, nil); err != nil {
 		t.Fatalf("failure checking out the directory snapshot %q: %v", h1, err)
 	}
 
@@ -189,7 +194,7 @@ func TestMergeDir(t *testing.T) {
 
 	cloneDir := filepath.Join(dir, "clone-dir")
 	cloneDirPath := snapshot.Path(cloneDir)
-	if err := Merge(context.Background(), s, h1, cloneDirPath); err != nil {
+	if _, err := Merge(context.Background(), s, h1, cloneDirPath, nil); err != nil {
 		t.Fatalf("failure checking out the directory snapshot %q: %v", h1, err)
 	}
 	verifyFilesMatch(t, file1, filepath.Join(cloneDir, "example1.txt"))
@@ -232,7 +237,7 @@ func TestMergeNonConflictingChangesDir(t *testing.T) {
 
 	cloneDir := filepath.Join(dir, "clone-dir")
 	cloneDirPath := snapshot.Path(cloneDir)
-	if err := Merge(context.Background(), s, h1, cloneDirPath); err != nil {
+	if _, err := Merge(context.Background(), s, h1, cloneDirPath, nil); err != nil {
 		t.Fatalf("failure checking out the directory snapshot %q: %v", h1, err)
 	}
 	verifyFilesMatch(t, file1, filepath.Join(cloneDir, "example1.txt"))
@@ -268,10 +273,289 @@ func TestMergeNonConflictingChangesDir(t *testing.T) {
 	if err := Checkout(context.Background(), s, h2, mergeDirPath); err != nil {
 		t.Fatalf("failure checking out the directory snapshot %q: %v", h2, err)
 	}
-	if err := Merge(context.Background(), s, h3, mergeDirPath); err != nil {
+	if _, err := Merge(context.Background(), s, h3, mergeDirPath, nil); err != nil {
 		t.Fatalf("failure checking out the directory snapshot %q: %v", h1, err)
 	}
 	verifyFilesMatch(t, file1, filepath.Join(mergeDir, "example1.txt"))
 	verifyFilesMatch(t, filepath.Join(cloneDir, "example2.txt"), filepath.Join(mergeDir, "example2.txt"))
 	verifyFilesMatch(t, file3, filepath.Join(mergeDir, "example3.txt"))
 }
+
+func TestMergeCrissCross(t *testing.T) {
+	ctx := context.Background()
+	f := setupCrissCross(t)
+
+	// Using either of the two merge bases on its own results in a
+	// spurious conflict for one of the two files.
+	for _, base := range []*snapshot.Hash{f.x1, f.y1} {
+		if merged, err := mergeWithBase(ctx, f.s, "/example", base, f.x3, f.y3, &mergeOptions{}); err == nil {
+			t.Errorf("unexpected success merging with the single base %q: %q", base, merged)
+		}
+	}
+
+	merged, _, err := mergeSnapshots(ctx, f.s, "/example", f.x3, f.y3, &mergeOptions{})
+	if err != nil {
+		t.Fatalf("failure merging with a virtual merge base: %v", err)
+	}
+	mergedFile, err := f.s.ReadSnapshot(ctx, merged)
+	if err != nil {
+		t.Fatalf("failure reading the merged snapshot: %v", err)
+	}
+	if len(mergedFile.Parents) != 2 || !mergedFile.Parents[0].Equal(f.x3) || !mergedFile.Parents[1].Equal(f.y3) {
+		t.Errorf("unexpected parents for the merged snapshot: %v", mergedFile.Parents)
+	}
+	tree, err := f.s.ListDirectorySnapshotContents(ctx, merged, mergedFile)
+	if err != nil {
+		t.Fatalf("failure reading the merged tree: %v", err)
+	}
+	if got, want := tree.String(), (snapshot.Tree{"a": f.a3, "b": f.b3}).String(); got != want {
+		t.Errorf("unexpected merged tree: got %q, want %q", got, want)
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+
+	srcDir := filepath.Join(dir, "src")
+	destDir := filepath.Join(dir, "dest")
+	writeFiles := func(root string, files map[string]string) {
+		for name, contents := range files {
+			p := filepath.Join(root, name)
+			if len(contents) == 0 {
+				if err := os.Remove(p); err != nil {
+					t.Fatalf("failure removing %q: %v", p, err)
+				}
+			} else if err := os.WriteFile(p, []byte(contents), 0700); err != nil {
+				t.Fatalf("failure writing %q: %v", p, err)
+			}
+		}
+	}
+	if err := os.Mkdir(srcDir, 0700); err != nil {
+		t.Fatalf("failure creating the source directory: %v", err)
+	}
+	writeFiles(srcDir, map[string]string{
+		"a.txt":  "one\ntwo\nthree\n",
+		"b.bin":  "\x00base",
+		"c.txt":  "unchanged\n",
+		"ok.txt": "will be changed\n",
+	})
+	base, _, err := snapshot.Current(ctx, s, snapshot.Path(srcDir))
+	if err != nil {
+		t.Fatalf("failure snapshotting the base: %v", err)
+	}
+	if _, err := Merge(ctx, s, base, snapshot.Path(destDir), nil); err != nil {
+		t.Fatalf("failure checking out the base: %v", err)
+	}
+	writeFiles(srcDir, map[string]string{
+		"a.txt":  "one\nsource\nthree\n",
+		"b.bin":  "\x00source",
+		"c.txt":  "",
+		"ok.txt": "changed in the source\n",
+	})
+	writeFiles(destDir, map[string]string{
+		"a.txt": "one\ndestination\nthree\n",
+		"b.bin": "\x00destination",
+		"c.txt": "changed in the destination\n",
+	})
+	src, _, err := snapshot.Current(ctx, s, snapshot.Path(srcDir))
+	if err != nil {
+		t.Fatalf("failure snapshotting the source: %v", err)
+	}
+	mapped, _, err := s.FindSnapshot(ctx, snapshot.Path(destDir))
+	if err != nil {
+		t.Fatalf("failure looking up the snapshot of the destination: %v", err)
+	}
+	if preview, err := DryRun(ctx, s, src, snapshot.Path(destDir), nil); err != nil || preview.Destination.Equal(mapped) {
+		t.Errorf("unexpected preview of the merge into the modified destination: %+v, %v", preview, err)
+	}
+	if h, _, err := s.FindSnapshot(ctx, snapshot.Path(destDir)); err != nil || !h.Equal(mapped) {
+		t.Errorf("unexpected snapshot mapped to the destination after previewing the merge: got %q, %v, want %q", h, err, mapped)
+	}
+	destPrev, _, err := snapshot.Current(ctx, s, snapshot.Path(destDir))
+	if err != nil {
+		t.Fatalf("failure snapshotting the destination: %v", err)
+	}
+
+	checkConflicts := func() {
+		_, err := Merge(ctx, s, src, snapshot.Path(destDir), nil)
+		var conflictErr *ConflictError
+		if !errors.As(err, &conflictErr) {
+			t.Fatalf("unexpected result merging conflicting changes: %v", err)
+		}
+		want := map[string]string{
+			"a.txt": ConflictMarkers,
+			"b.bin": ConflictVersions,
+			"c.txt": ConflictVersions,
+		}
+		if len(conflictErr.Conflicts) != len(want) {
+			t.Fatalf("unexpected conflicts: %+v", conflictErr.Conflicts)
+		}
+		for _, c := range conflictErr.Conflicts {
+			if got := want[string(c.Path)]; got != c.Kind {
+				t.Errorf("unexpected conflict kind for %q: got %q, want %q", c.Path, c.Kind, got)
+			}
+		}
+		if unresolved, err := Unresolved(ctx, s, snapshot.Path(destDir)); err != nil || len(unresolved) != len(want) {
+			t.Errorf("unexpected unresolved conflicts: %+v, %v", unresolved, err)
+		}
+		if contents, err := os.ReadFile(filepath.Join(destDir, "a.txt")); err != nil || !strings.Contains(string(contents), "<<<<<<< source") {
+			t.Errorf("unexpected contents for the file with conflict markers: %q, %v", contents, err)
+		}
+		verifyFilesMatch(t, filepath.Join(srcDir, "b.bin"), filepath.Join(destDir, "b.bin"+SourceSuffix))
+		if _, err := os.Stat(filepath.Join(destDir, "c.txt"+DestinationSuffix)); err != nil {
+			t.Errorf("missing the destination version of the deleted file: %v", err)
+		}
+		verifyFilesMatch(t, filepath.Join(srcDir, "ok.txt"), filepath.Join(destDir, "ok.txt"))
+		if _, err := Merge(ctx, s, src, snapshot.Path(destDir), nil); err == nil {
+			t.Error("unexpected success starting a second merge while one is in progress")
+		}
+	}
+	preview, err := DryRun(ctx, s, src, snapshot.Path(destDir), nil)
+	if err != nil {
+		t.Fatalf("failure previewing the merge: %v", err)
+	}
+	if !preview.Destination.Equal(destPrev) || !preview.Base.Equal(base) || len(preview.Conflicts) != 3 {
+		t.Errorf("unexpected preview of the merge: %+v", preview)
+	}
+	var changed []string
+	for _, c := range preview.Changes {
+		changed = append(changed, string(c.NewPath))
+	}
+	wantChanged := []string{"a.txt", "b.bin" + SourceSuffix, "c.txt", "c.txt" + DestinationSuffix, "ok.txt"}
+	if diff := cmp.Diff(wantChanged, changed); len(diff) > 0 {
+		t.Errorf("unexpected changes in the merge preview: %s", diff)
+	}
+	if h, _, err := snapshot.Current(ctx, s, snapshot.Path(destDir)); err != nil || !h.Equal(destPrev) {
+		t.Errorf("unexpected snapshot after previewing the merge: got %q, %v, want %q", h, err, destPrev)
+	}
+	if state, err := s.ReadMergeState(ctx, snapshot.Path(destDir)); err != nil || state != nil {
+		t.Errorf("unexpected merge state after previewing the merge: %+v, %v", state, err)
+	}
+
+	checkConflicts()
+	if err := Abort(ctx, s, snapshot.Path(destDir)); err != nil {
+		t.Fatalf("failure aborting the merge: %v", err)
+	}
+	if h, _, err := snapshot.Current(ctx, s, snapshot.Path(destDir)); err != nil || !h.Equal(destPrev) {
+		t.Errorf("unexpected snapshot after aborting the merge: got %q, %v, want %q", h, err, destPrev)
+	}
+
+	checkConflicts()
+	writeFiles(destDir, map[string]string{"a.txt": "one\nresolved\nthree\n"})
+	if _, err := Continue(ctx, s, snapshot.Path(destDir)); err == nil {
+		t.Error("unexpected success continuing a merge with unresolved conflicts")
+	}
+	if err := os.Remove(filepath.Join(destDir, "b.bin"+SourceSuffix)); err != nil {
+		t.Fatalf("failure resolving the binary conflict: %v", err)
+	}
+	if err := os.Rename(filepath.Join(destDir, "c.txt"+DestinationSuffix), filepath.Join(destDir, "c.txt")); err != nil {
+		t.Fatalf("failure resolving the deletion conflict: %v", err)
+	}
+	merged, err := Continue(ctx, s, snapshot.Path(destDir))
+	if err != nil {
+		t.Fatalf("failure continuing the resolved merge: %v", err)
+	}
+	mergedFile, err := s.ReadSnapshot(ctx, merged)
+	if err != nil {
+		t.Fatalf("failure reading the merged snapshot: %v", err)
+	}
+	if len(mergedFile.Parents) != 2 || !mergedFile.Parents[0].Equal(src) || !mergedFile.Parents[1].Equal(destPrev) {
+		t.Errorf("unexpected parents for the merged snapshot: %v", mergedFile.Parents)
+	}
+	if state, err := s.ReadMergeState(ctx, snapshot.Path(destDir)); err != nil || state != nil {
+		t.Errorf("unexpected merge state after continuing the merge: %+v, %v", state, err)
+	}
+}
+
+func TestMergeSidecarCollision(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+
+	srcDir := filepath.Join(dir, "src")
+	destDir := filepath.Join(dir, "dest")
+	writeFiles := func(root string, files map[string]string) *snapshot.Hash {
+		if err := os.MkdirAll(root, 0700); err != nil {
+			t.Fatalf("failure creating %q: %v", root, err)
+		}
+		for name, contents := range files {
+			if err := os.WriteFile(filepath.Join(root, name), []byte(contents), 0700); err != nil {
+				t.Fatalf("failure writing %q: %v", name, err)
+			}
+		}
+		h, _, err := snapshot.Current(ctx, s, snapshot.Path(root))
+		if err != nil {
+			t.Fatalf("failure snapshotting %q: %v", root, err)
+		}
+		return h
+	}
+	// The directory already has a file named like the sidecar for the
+	// source version of a conflicting file.
+	base := writeFiles(srcDir, map[string]string{
+		"b.bin":                "\x00base",
+		"b.bin" + SourceSuffix: "existing\n",
+	})
+	if _, err := Merge(ctx, s, base, snapshot.Path(destDir), nil); err != nil {
+		t.Fatalf("failure checking out the base: %v", err)
+	}
+	src := writeFiles(srcDir, map[string]string{"b.bin": "\x00source"})
+	writeFiles(destDir, map[string]string{"b.bin": "\x00destination"})
+
+	_, err := Merge(ctx, s, src, snapshot.Path(destDir), nil)
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("unexpected result merging conflicting changes: %v", err)
+	}
+	wantSidecar := snapshot.Path("b.bin" + SourceSuffix + ".1")
+	if len(conflictErr.Conflicts) != 1 || conflictErr.Conflicts[0].Sidecar != wantSidecar {
+		t.Errorf("unexpected conflicts: %+v", conflictErr.Conflicts)
+	}
+	for name, want := range map[string]string{
+		"b.bin" + SourceSuffix: "existing\n",
+		string(wantSidecar):    "\x00source",
+	} {
+		if got, err := os.ReadFile(filepath.Join(destDir, name)); err != nil || string(got) != want {
+			t.Errorf("unexpected contents for %q: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+type progressForTest struct {
+	files int64
+}
+
+func (p *progressForTest) SetTotal(files, bytes int64) {}
+
+func (p *progressForTest) Add(files, bytes int64) {
+	p.files += files
+}
+
+func TestMergeProgress(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+
+	srcDir := filepath.Join(dir, "src")
+	destDir := filepath.Join(dir, "dest")
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("unchanged-%d.txt", i)] = "unchanged\n"
+	}
+	base := writeAndSnapshot(ctx, t, s, srcDir, files)
+	if _, err := Merge(ctx, s, base, snapshot.Path(destDir), nil); err != nil {
+		t.Fatalf("failure checking out the base: %v", err)
+	}
+	src := writeAndSnapshot(ctx, t, s, srcDir, map[string]string{"changed.txt": "changed\n"})
+
+	// Only writing the merge result is reported, not snapshotting the
+	// unchanged files of the destination.
+	reported := &progressForTest{}
+	if _, err := Merge(progress.WithReporter(ctx, reported), s, src, snapshot.Path(destDir), nil); err != nil {
+		t.Fatalf("failure merging the changed file: %v", err)
+	}
+	if reported.files == 0 || reported.files >= int64(len(files)) {
+		t.Errorf("unexpected number of files reported: %d", reported.files)
+	}
+}
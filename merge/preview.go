@@ -0,0 +1,115 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/recursive-version-control-system/diff"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// Preview describes the result of merging a snapshot into a local file path.
+type Preview struct {
+	// Base is the merge base, or nil if the two sides had no common ancestor.
+	Base *snapshot.Hash
+
+	// Source is the snapshot being merged in.
+	Source *snapshot.Hash
+
+	// Destination is the current snapshot of the destination, or nil if
+	// the destination does not exist.
+	Destination *snapshot.Hash
+
+	// Merged is the merged snapshot, including any conflicts written into it.
+	Merged *snapshot.Hash
+
+	// Changes are the nested files that differ between `Destination`
+	// and `Merged`, with paths relative to the destination.
+	Changes []*diff.Change
+
+	// Conflicts are the nested paths that could not be automatically
+	// merged, with paths relative to the destination.
+	Conflicts []*storage.MergeConflict
+
+	// Resolutions are the nested paths that were deleted, rolled back, or
+	// renamed on one side and were automatically resolved, with paths
+	// relative to the destination.
+	Resolutions []*Resolution
+}
+
+// computeMerge merges the given snapshot with the current snapshot of `dest`,
+// without modifying `dest` itself.
+//
+// If `record` is true, then `dest` is mapped to its current snapshot, as
+// with `snapshot.Current`. Otherwise, its current snapshot is only peeked at.
+//
+// The `Changes` field of the returned `Preview` is not populated.
+func computeMerge(ctx context.Context, s *storage.LocalFiles, src *snapshot.Hash, dest snapshot.Path, options *Options, record bool) (*Preview, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	current := snapshot.Peek
+	if record {
+		current = snapshot.Current
+	}
+	destPrevHash, _, err := current(ctx, s, dest)
+	if err != nil {
+		return nil, fmt.Errorf("failure generating snapshot of destination %q prior to merging: %v", dest, err)
+	}
+	if destPrevHash == nil {
+		return &Preview{Source: src, Merged: src}, nil
+	}
+	opts := &mergeOptions{
+		recordConflicts: true,
+		strategies:      options,
+		forceKeepMode:   options != nil && options.KeepSourceMode,
+	}
+	mergedHash, mergeBase, err := mergeSnapshots(ctx, s, dest, src, destPrevHash, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to automatically merge the two snapshots: %v", err)
+	}
+	return &Preview{
+		Base:        mergeBase,
+		Source:      src,
+		Destination: destPrevHash,
+		Merged:      mergedHash,
+		Conflicts:   relativeConflicts(dest, opts.conflicts),
+		Resolutions: relativeResolutions(dest, opts.resolutions),
+	}, nil
+}
+
+// DryRun computes the result of merging the given snapshot into the local
+// filesystem at the specified destination path, without modifying the
+// destination.
+//
+// The merged snapshot is stored, so it can be inspected or published, but
+// no path is mapped to it and no merge state is recorded. Likewise, the
+// current contents of the destination are snapshotted without mapping the
+// destination to that snapshot.
+func DryRun(ctx context.Context, s *storage.LocalFiles, src *snapshot.Hash, dest snapshot.Path, opts *Options) (*Preview, error) {
+	p, err := computeMerge(ctx, s, src, dest, opts, false)
+	if err != nil {
+		return nil, err
+	}
+	p.Changes, err = diff.Snapshots(ctx, s, p.Destination, p.Merged, false)
+	if err != nil {
+		return nil, fmt.Errorf("failure comparing the merged snapshot %q to the destination %q: %v", p.Merged, dest, err)
+	}
+	return p, nil
+}
@@ -0,0 +1,303 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/recursive-version-control-system/diff"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const (
+	// renameThreshold is the minimum fraction of lines that a deleted
+	// file and an added file must have in common for the added file to
+	// be considered a rename of the deleted one.
+	renameThreshold = 0.5
+
+	// maxRenameComparisons limits the number of pairs of deleted and
+	// added files whose contents are compared when detecting renames.
+	//
+	// If there are more pairs than this, then only renames with
+	// identical contents are detected.
+	maxRenameComparisons = 1000
+)
+
+// rename is a file that was moved on one side of a merge, and changed
+// at its previous path on the other side.
+type rename struct {
+	// from is the relative path of the file before it was renamed.
+	from snapshot.Path
+
+	// renamedIn is the name of the side that renamed the file.
+	renamedIn string
+
+	// base, src, and dest are the versions of the file to merge at
+	// the path it was renamed to.
+	base, src, dest *snapshot.Hash
+}
+
+// similarity returns the fraction of lines that the two files have in
+// common, or zero if either of them is not a regular text file.
+func similarity(ctx context.Context, s *storage.LocalFiles, a, b *snapshot.File) (float64, error) {
+	if a.IsDir() || a.IsLink() || b.IsDir() || b.IsLink() {
+		return 0, nil
+	}
+	aContents, aBinary, err := diff.ReadContents(ctx, s, a)
+	if err != nil {
+		return 0, err
+	}
+	bContents, bBinary, err := diff.ReadContents(ctx, s, b)
+	if err != nil {
+		return 0, err
+	}
+	aLines, bLines := diff.SplitLines(string(aContents)), diff.SplitLines(string(bContents))
+	if aBinary || bBinary || len(aLines)+len(bLines) == 0 {
+		return 0, nil
+	}
+	common := 0
+	for _, e := range diff.Lines(aLines, bLines) {
+		if e.Op == diff.Equal {
+			common++
+		}
+	}
+	return float64(2*common) / float64(len(aLines)+len(bLines)), nil
+}
+
+// findRenames returns the files that were renamed between the two given
+// snapshots, either with identical contents or with enough lines in common.
+func findRenames(ctx context.Context, s *storage.LocalFiles, base, h *snapshot.Hash) ([]*diff.Change, error) {
+	changes, err := diff.Snapshots(ctx, s, base, h, true)
+	if err != nil {
+		return nil, fmt.Errorf("failure comparing %q to the merge base %q: %v", h, base, err)
+	}
+	var renamed, deleted, added []*diff.Change
+	for _, c := range changes {
+		switch {
+		case c.IsRenamed():
+			renamed = append(renamed, c)
+		case c.IsDeleted() && !c.IsAdded():
+			deleted = append(deleted, c)
+		case c.IsAdded() && !c.IsDeleted():
+			added = append(added, c)
+		}
+	}
+	if len(deleted)*len(added) > maxRenameComparisons {
+		return renamed, nil
+	}
+	used := make(map[*diff.Change]struct{})
+	for _, a := range added {
+		var best *diff.Change
+		bestScore := renameThreshold
+		for _, d := range deleted {
+			if _, ok := used[d]; ok {
+				continue
+			}
+			score, err := similarity(ctx, s, d.OldFile, a.NewFile)
+			if err != nil {
+				return nil, fmt.Errorf("failure comparing %q to %q: %v", d.OldPath, a.NewPath, err)
+			}
+			if score >= bestScore {
+				best, bestScore = d, score
+			}
+		}
+		if best != nil {
+			used[best] = struct{}{}
+			renamed = append(renamed, &diff.Change{
+				OldPath: best.OldPath,
+				NewPath: a.NewPath,
+				Old:     best.Old,
+				OldFile: best.OldFile,
+				New:     a.New,
+				NewFile: a.NewFile,
+			})
+		}
+	}
+	return renamed, nil
+}
+
+// splitPath splits the given relative path into its components.
+func splitPath(p snapshot.Path) []snapshot.Path {
+	var result []snapshot.Path
+	for _, part := range strings.Split(string(p), string(filepath.Separator)) {
+		result = append(result, snapshot.Path(part))
+	}
+	return result
+}
+
+// lookupPath returns the snapshot of the given relative path nested under
+// the snapshot `h`, or nil if there is no such nested path.
+//
+// The returned boolean reports whether or not the path could be added to
+// `h`, which is only the case if the path does not exist and each of its
+// parents either does not exist or is a directory.
+func lookupPath(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, p snapshot.Path) (nested *snapshot.Hash, addable bool, err error) {
+	for _, part := range splitPath(p) {
+		if h == nil {
+			return nil, true, nil
+		}
+		f, err := s.ReadSnapshot(ctx, h)
+		if err != nil {
+			return nil, false, fmt.Errorf("failure reading the file snapshot for %q: %v", h, err)
+		}
+		if !f.IsDir() {
+			return nil, false, nil
+		}
+		h, err = snapshot.LookupTree(ctx, s, f.Contents, part)
+		if err != nil {
+			return nil, false, fmt.Errorf("failure looking up %q in the tree %q: %v", part, f.Contents, err)
+		}
+	}
+	return h, h == nil, nil
+}
+
+// detectRenames finds the files that were renamed on one side of the
+// merge and changed at their previous path on the other side, so that
+// those changes can be merged into the renamed file.
+//
+// The renames are recorded in `opts`, keyed by the absolute paths under
+// `root` that they were renamed from and to.
+func detectRenames(ctx context.Context, s *storage.LocalFiles, root snapshot.Path, base, src, dest *snapshot.Hash, opts *mergeOptions) error {
+	if base == nil || src == nil || dest == nil {
+		return nil
+	}
+	opts.renamedFrom = make(map[snapshot.Path]*rename)
+	opts.renamedTo = make(map[snapshot.Path]*rename)
+	sides := []struct {
+		name           string
+		renamer, other *snapshot.Hash
+	}{
+		{"source", src, dest},
+		{"destination", dest, src},
+	}
+	for _, side := range sides {
+		changes, err := findRenames(ctx, s, base, side.renamer)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			otherOld, _, err := lookupPath(ctx, s, side.other, c.OldPath)
+			if err != nil {
+				return err
+			}
+			if otherOld == nil || otherOld.Equal(c.Old) {
+				// The other side either deleted the file too, or did
+				// not change it, so the rename merges as usual.
+				continue
+			}
+			if _, addable, err := lookupPath(ctx, s, side.other, c.NewPath); err != nil {
+				return err
+			} else if !addable {
+				continue
+			}
+			from, to := root.Join(c.OldPath), root.Join(c.NewPath)
+			if opts.renamedFrom[from] != nil || opts.renamedTo[to] != nil {
+				continue
+			}
+			r := &rename{
+				from:      c.OldPath,
+				renamedIn: side.name,
+				base:      c.Old,
+				src:       c.New,
+				dest:      otherOld,
+			}
+			if side.name == "destination" {
+				r.src, r.dest = otherOld, c.New
+			}
+			opts.renamedFrom[from] = r
+			opts.renamedTo[to] = r
+		}
+	}
+	return nil
+}
+
+// hasRenameUnder reports whether or not a file was renamed to a path
+// nested under `subPath` that has not yet been merged.
+func (opts *mergeOptions) hasRenameUnder(subPath snapshot.Path) bool {
+	prefix := string(subPath) + string(filepath.Separator)
+	for to, _ := range opts.renamedTo {
+		if strings.HasPrefix(string(to), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeRenamed merges the changes made to a file on one side of the merge
+// into the path that the file was renamed to on the other side.
+func mergeRenamed(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, r *rename, opts *mergeOptions) (*snapshot.Hash, error) {
+	delete(opts.renamedTo, subPath)
+	otherSide := "destination"
+	if r.renamedIn == otherSide {
+		otherSide = "source"
+	}
+	opts.resolutions = append(opts.resolutions, &Resolution{
+		Path:        subPath,
+		Kind:        ResolutionRenamed,
+		Description: fmt.Sprintf("renamed from %s in the %s, so the changes to it in the %s were merged into it", r.from, r.renamedIn, otherSide),
+	})
+	if merged, resolved, err := mergeWithStrategy(ctx, s, subPath, r.base, r.src, r.dest, opts); err != nil || resolved {
+		return merged, err
+	}
+	baseFile, err := s.ReadSnapshot(ctx, r.base)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the file snapshot for %q: %v", r.base, err)
+	}
+	srcFile, err := s.ReadSnapshot(ctx, r.src)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the file snapshot for %q: %v", r.src, err)
+	}
+	destFile, err := s.ReadSnapshot(ctx, r.dest)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the file snapshot for %q: %v", r.dest, err)
+	}
+
+	// The renamed file has a new history, so the two sides are compared
+	// against the base by their contents rather than by their ancestry.
+	if merged, resolved, err := mergeUnchanged(ctx, s, baseFile, srcFile, destFile, r.src, r.dest, opts); err != nil || resolved {
+		return merged, err
+	}
+	if fileType(srcFile) != fileType(destFile) {
+		return mergeTypes(ctx, s, subPath, baseFile, srcFile, destFile, r.src, r.dest, opts)
+	}
+	if srcFile.IsLink() {
+		return mergeLinks(ctx, s, subPath, baseFile, srcFile, destFile, r.src, r.dest, opts)
+	}
+	return mergeFiles(ctx, s, subPath, destFile.Mode, r.base, r.src, r.dest, opts)
+}
+
+// mergeRenameParent merges a directory that a file was renamed into on one
+// side of the merge, without taking either side as a whole, so that the
+// renamed file is merged with the changes from the other side.
+func mergeRenameParent(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, base, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	baseFile, err := readSnapshotIfPresent(ctx, s, base)
+	if err != nil {
+		return nil, err
+	}
+	srcFile, err := readSnapshotIfPresent(ctx, s, src)
+	if err != nil {
+		return nil, err
+	}
+	destFile, err := readSnapshotIfPresent(ctx, s, dest)
+	if err != nil {
+		return nil, err
+	}
+	return mergeDirs(ctx, s, subPath, baseFile, srcFile, destFile, base, src, dest, opts)
+}
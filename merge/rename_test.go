@@ -0,0 +1,103 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func TestMergeRenames(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+
+	srcDir := filepath.Join(dir, "src")
+	destDir := filepath.Join(dir, "dest")
+	rename := func(root string, renames map[string]string) {
+		for from, to := range renames {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(root, to)), 0700); err != nil {
+				t.Fatalf("failure creating the parent of %q: %v", to, err)
+			}
+			if err := os.Rename(filepath.Join(root, from), filepath.Join(root, to)); err != nil {
+				t.Fatalf("failure renaming %q to %q: %v", from, to, err)
+			}
+		}
+	}
+	lines := []string{"one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten"}
+	withLine := func(i int, line string) string {
+		changed := append([]string(nil), lines...)
+		changed[i] = line
+		return strings.Join(changed, "\n") + "\n"
+	}
+	base := writeAndSnapshot(ctx, t, s, srcDir, map[string]string{
+		"a/foo.txt": withLine(0, "one"),
+		"x.txt":     "exact\n",
+		"z.txt":     withLine(0, "one"),
+		"keep.txt":  "keep\n",
+	})
+	if _, err := Merge(ctx, s, base, snapshot.Path(destDir), nil); err != nil {
+		t.Fatalf("failure checking out the base: %v", err)
+	}
+
+	// The source moves a file to a new directory while editing it, and
+	// renames another file without changing it, while the destination
+	// edits both of them at their old paths.
+	rename(srcDir, map[string]string{"a/foo.txt": "b/foo.txt", "x.txt": "y.txt"})
+	writeAndSnapshot(ctx, t, s, srcDir, map[string]string{"b/foo.txt": withLine(0, "ONE"), "z.txt": withLine(9, "TEN")})
+	if err := os.Remove(filepath.Join(srcDir, "a")); err != nil {
+		t.Fatalf("failure removing the emptied directory: %v", err)
+	}
+	src := writeAndSnapshot(ctx, t, s, srcDir, nil)
+	// The destination also moves a file that the source edits.
+	rename(destDir, map[string]string{"z.txt": "w/z.txt"})
+	writeAndSnapshot(ctx, t, s, destDir, map[string]string{"a/foo.txt": withLine(8, "NINE"), "x.txt": "exact, but edited\n"})
+
+	p, err := Merge(ctx, s, src, snapshot.Path(destDir), nil)
+	if err != nil {
+		t.Fatalf("failure merging the renamed files: %v", err)
+	}
+	wantRenames := map[snapshot.Path]bool{"b/foo.txt": true, "y.txt": true, "w/z.txt": true}
+	if len(p.Resolutions) != len(wantRenames) {
+		t.Errorf("unexpected resolutions: %+v", p.Resolutions)
+	}
+	for _, r := range p.Resolutions {
+		if !wantRenames[r.Path] || r.Kind != ResolutionRenamed {
+			t.Errorf("unexpected resolution: %+v", r)
+		}
+	}
+	for name, want := range map[string]string{
+		"b/foo.txt": strings.Replace(withLine(0, "ONE"), "nine", "NINE", 1),
+		"y.txt":     "exact, but edited\n",
+		"w/z.txt":   withLine(9, "TEN"),
+		"keep.txt":  "keep\n",
+	} {
+		if got, err := os.ReadFile(filepath.Join(destDir, name)); err != nil || string(got) != want {
+			t.Errorf("unexpected contents for %q: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"a", "x.txt", "z.txt"} {
+		if _, err := os.Lstat(filepath.Join(destDir, name)); !os.IsNotExist(err) {
+			t.Errorf("unexpected result for the renamed path %q: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const (
+	// ResolutionDeleted is the kind of resolution where a path deleted
+	// on one side was also deleted from the merge, because the other side
+	// had the same contents and permissions as the merge base.
+	ResolutionDeleted = "deleted"
+
+	// ResolutionRebased is the kind of resolution where the changes from
+	// the merge base were rolled back on one side, so a different merge
+	// base was computed for that path from the histories of both sides.
+	ResolutionRebased = "rebased"
+
+	// ResolutionRenamed is the kind of resolution where a file renamed
+	// on one side was merged with the changes made to it at its previous
+	// path on the other side.
+	ResolutionRenamed = "renamed"
+)
+
+// Resolution describes a nested path which was automatically merged even
+// though it would not have been a simple merge of the changes from the
+// merge base.
+type Resolution struct {
+	// Path is the nested path that was resolved.
+	Path snapshot.Path
+
+	// Kind is one of `ResolutionDeleted`, `ResolutionRebased`, or
+	// `ResolutionRenamed`.
+	Kind string
+
+	// Description explains how the path was resolved.
+	Description string
+}
+
+// mergeDeleted merges a nested path that was deleted on exactly one side
+// of the merge, and changed on the other side.
+//
+// If the version that was kept has the same contents and permissions as
+// the base, then it only differs in its history and the path is deleted.
+// If it is a directory, then the deletion is merged with every nested path
+// of it separately. Otherwise, the path conflicts.
+func mergeDeleted(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, base, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	deletedIn, keptIn, kept := "destination", "source", src
+	if src == nil {
+		deletedIn, keptIn, kept = "source", "destination", dest
+	}
+	keptFile, err := s.ReadSnapshot(ctx, kept)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the file snapshot for %q: %v", kept, err)
+	}
+	baseFile, err := readSnapshotIfPresent(ctx, s, base)
+	if err != nil {
+		return nil, err
+	}
+	if unchanged, err := sameContents(ctx, s, baseFile, keptFile); err != nil {
+		return nil, err
+	} else if unchanged {
+		opts.resolutions = append(opts.resolutions, &Resolution{
+			Path:        subPath,
+			Kind:        ResolutionDeleted,
+			Description: fmt.Sprintf("deleted in the %s, and unchanged in the %s", deletedIn, keptIn),
+		})
+		return nil, nil
+	}
+	if baseFile.IsDir() && keptFile.IsDir() {
+		srcFile, destFile := keptFile, (*snapshot.File)(nil)
+		if src == nil {
+			srcFile, destFile = nil, keptFile
+		}
+		return mergeDirs(ctx, s, subPath, baseFile, srcFile, destFile, base, src, dest, opts)
+	}
+	return nil, &conflictError{
+		kind:   ConflictVersions,
+		reason: fmt.Sprintf("the path %q was deleted in the %s snapshot, but modified in the %s snapshot", subPath, deletedIn, keptIn),
+	}
+}
+
+// recomputeBase checks whether the changes from the given merge base were
+// rolled back on either side of the merge, and if so computes a new merge
+// base from the histories of the source and destination.
+func recomputeBase(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, base, src, dest *snapshot.Hash, opts *mergeOptions) (newBase *snapshot.Hash, rolledBack bool, err error) {
+	var sides []string
+	if isAncestor, err := isBaseAncestor(ctx, s, base, src, opts); err != nil {
+		return nil, false, err
+	} else if !isAncestor {
+		sides = append(sides, "source")
+	}
+	if isAncestor, err := isBaseAncestor(ctx, s, base, dest, opts); err != nil {
+		return nil, false, err
+	} else if !isAncestor {
+		sides = append(sides, "destination")
+	}
+	if len(sides) == 0 {
+		return base, false, nil
+	}
+	bases, err := Bases(ctx, s, src, dest)
+	if err != nil {
+		return nil, false, fmt.Errorf("failure determining the merge bases for %q and %q: %v", src, dest, err)
+	}
+	if len(bases) > 1 {
+		newBase, err = virtualBase(ctx, s, subPath, bases, opts)
+		if err != nil {
+			return nil, false, err
+		}
+	} else if len(bases) == 1 {
+		newBase = bases[0]
+	}
+	rolledBackIn := strings.Join(sides, " and ")
+	if newBase.Equal(base) {
+		return nil, false, &conflictError{
+			kind:   ConflictVersions,
+			reason: fmt.Sprintf("nested changes under the path %q were rolled back in the %s snapshot, and no other merge base could be found, so the two snapshots have to be manually merged", subPath, rolledBackIn),
+		}
+	}
+	description := fmt.Sprintf("the changes from %s were rolled back in the %s, so they were merged without a common base", base, rolledBackIn)
+	if newBase != nil {
+		description = fmt.Sprintf("the changes from %s were rolled back in the %s, so %s was used as the merge base instead", base, rolledBackIn, newBase)
+	}
+	opts.resolutions = append(opts.resolutions, &Resolution{
+		Path:        subPath,
+		Kind:        ResolutionRebased,
+		Description: description,
+	})
+	return newBase, true, nil
+}
+
+// relativeResolutions converts the paths of the given resolutions to be
+// relative to the merge destination.
+func relativeResolutions(dest snapshot.Path, resolutions []*Resolution) []*Resolution {
+	for _, r := range resolutions {
+		if rel, err := filepath.Rel(string(dest), string(r.Path)); err == nil {
+			r.Path = snapshot.Path(rel)
+		}
+	}
+	return resolutions
+}
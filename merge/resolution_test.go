@@ -0,0 +1,115 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func TestMergeDeletionsAndRollbacks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+
+	srcDir := filepath.Join(dir, "src")
+	destDir := filepath.Join(dir, "dest")
+	writeAndSnapshot(ctx, t, s, srcDir, map[string]string{"r.txt": "a\nb\nc\nd\ne\n"})
+	rolledBackTo, _, err := snapshot.Current(ctx, s, snapshot.Path(filepath.Join(srcDir, "r.txt")))
+	if err != nil {
+		t.Fatalf("failure snapshotting the original version of the rolled back file: %v", err)
+	}
+	base := writeAndSnapshot(ctx, t, s, srcDir, map[string]string{
+		"r.txt":       "a\nb\nc\nd\ne\nf\n",
+		"same.txt":    "same\n",
+		"gone.txt":    "gone\n",
+		"sub/a.txt":   "a\n",
+		"sub/b.txt":   "b\n",
+		"whole/a.txt": "a\n",
+	})
+	if _, err := Merge(ctx, s, base, snapshot.Path(destDir), nil); err != nil {
+		t.Fatalf("failure checking out the base: %v", err)
+	}
+
+	src := writeAndSnapshot(ctx, t, s, srcDir, map[string]string{
+		"r.txt":    "A\nb\nc\nd\ne\nf\n",
+		"same.txt": "",
+		"gone.txt": "changed in the source\n",
+		"sub":      "",
+		"whole":    "",
+	})
+
+	// The destination changes `same.txt` and then changes it back, so
+	// that only its history differs from the base, and rolls back the
+	// last change to `r.txt` before changing it further.
+	writeAndSnapshot(ctx, t, s, destDir, map[string]string{"same.txt": "temporary\n", "gone.txt": ""})
+	writeAndSnapshot(ctx, t, s, destDir, map[string]string{"same.txt": "same\n", "sub/b.txt": "changed in the destination\n"})
+	if err := Checkout(ctx, s, rolledBackTo, snapshot.Path(filepath.Join(destDir, "r.txt"))); err != nil {
+		t.Fatalf("failure rolling back %q: %v", "r.txt", err)
+	}
+	writeAndSnapshot(ctx, t, s, destDir, map[string]string{"r.txt": "a\nb\nC\nd\ne\n"})
+
+	p, err := Merge(ctx, s, src, snapshot.Path(destDir), nil)
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("unexpected result merging the deletions: %v", err)
+	}
+	wantConflicts := map[snapshot.Path]snapshot.Path{
+		"gone.txt":  "gone.txt" + SourceSuffix,
+		"sub/b.txt": "sub/b.txt" + DestinationSuffix,
+	}
+	if len(conflictErr.Conflicts) != len(wantConflicts) {
+		t.Errorf("unexpected conflicts: %+v", conflictErr.Conflicts)
+	}
+	for _, c := range conflictErr.Conflicts {
+		if want, ok := wantConflicts[c.Path]; !ok || c.Kind != ConflictVersions || c.Sidecar != want {
+			t.Errorf("unexpected conflict: %+v", c)
+		}
+	}
+	wantResolutions := map[snapshot.Path]string{
+		"same.txt": ResolutionDeleted,
+		"r.txt":    ResolutionRebased,
+	}
+	if len(p.Resolutions) != len(wantResolutions) {
+		t.Errorf("unexpected resolutions: %+v", p.Resolutions)
+	}
+	for _, r := range p.Resolutions {
+		if want := wantResolutions[r.Path]; r.Kind != want {
+			t.Errorf("unexpected resolution for %q: got %q, want %q (%s)", r.Path, r.Kind, want, r.Description)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"r.txt":                         "A\nb\nC\nd\ne\nf\n",
+		"gone.txt" + SourceSuffix:       "changed in the source\n",
+		"sub/b.txt" + DestinationSuffix: "changed in the destination\n",
+	} {
+		if got, err := os.ReadFile(filepath.Join(destDir, name)); err != nil || string(got) != want {
+			t.Errorf("unexpected contents for %q: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"same.txt", "gone.txt", "sub/a.txt", "sub/b.txt", "whole"} {
+		if _, err := os.Lstat(filepath.Join(destDir, name)); !os.IsNotExist(err) {
+			t.Errorf("unexpected result for the deleted path %q: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/recursive-version-control-system/diff"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const (
+	// StrategyHelper merges the lines of text files using the built-in
+	// three-way merge, or merges files using the merge helper command if
+	// one is configured.
+	//
+	// This is the default strategy.
+	StrategyHelper = "helper"
+
+	// StrategyOurs resolves files changed on both sides by keeping the
+	// destination version.
+	StrategyOurs = "ours"
+
+	// StrategyTheirs resolves files changed on both sides by taking the
+	// source version.
+	StrategyTheirs = "theirs"
+
+	// StrategyUnion merges the lines of text files, keeping the lines
+	// from both sides (destination first) wherever they conflict.
+	StrategyUnion = "union"
+
+	// StrategyNewest resolves files changed on both sides by taking the
+	// more recent version.
+	//
+	// The more recent version is the one with the later annotation time
+	// if both versions are annotated, and otherwise the one with the
+	// longer history. Ties are resolved in favor of the destination.
+	StrategyNewest = "newest"
+)
+
+// Driver is a custom command for merging files.
+//
+// It is invoked in the same way as the merge helper.
+type Driver struct {
+	// Command is the command to run.
+	Command string
+
+	// Args are extra arguments passed to the command before the paths
+	// of the files to merge.
+	Args []string
+}
+
+// Rule assigns a merge strategy to the nested paths matching a pattern.
+type Rule struct {
+	// Pattern is a glob pattern, as supported by `path.Match`.
+	//
+	// Patterns containing a "/" are matched against the whole path
+	// relative to the merge destination, while other patterns are
+	// matched against just the last element of the path.
+	Pattern string
+
+	// Strategy is the name of either a built-in strategy or a driver.
+	Strategy string
+}
+
+// Options configure how a merge resolves nested paths that were changed
+// on both sides.
+//
+// Strategies only apply to paths that are not directories on both sides,
+// since directories are always merged by recursively merging their
+// contents.
+type Options struct {
+	// Strategy is the strategy used for paths that do not match any of
+	// the rules. If empty, then `StrategyHelper` is used.
+	Strategy string
+
+	// Rules assign strategies to specific paths. If more than one rule
+	// matches a path, then the last one is used.
+	Rules []*Rule
+
+	// Drivers are custom merge commands that can be used as strategies,
+	// keyed by their names.
+	Drivers map[string]*Driver
+
+	// KeepSourceMode specifies that the source permissions should be
+	// used for directories whose permissions differ between the two
+	// sides, rather than reporting a conflict.
+	KeepSourceMode bool
+}
+
+func (o *Options) validStrategy(strategy string) bool {
+	switch strategy {
+	case StrategyHelper, StrategyOurs, StrategyTheirs, StrategyUnion, StrategyNewest:
+		return true
+	}
+	_, ok := o.Drivers[strategy]
+	return ok
+}
+
+// Validate reports an error if the options refer to an unknown strategy,
+// or have a malformed pattern.
+func (o *Options) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if len(o.Strategy) > 0 && !o.validStrategy(o.Strategy) {
+		return fmt.Errorf("unknown merge strategy %q", o.Strategy)
+	}
+	for _, r := range o.Rules {
+		if _, err := path.Match(r.Pattern, ""); err != nil {
+			return fmt.Errorf("malformed pattern %q: %v", r.Pattern, err)
+		}
+		if !o.validStrategy(r.Strategy) {
+			return fmt.Errorf("unknown merge strategy %q for the pattern %q", r.Strategy, r.Pattern)
+		}
+	}
+	return nil
+}
+
+// strategyFor returns the strategy for the given path, relative to the
+// merge destination.
+func (o *Options) strategyFor(rel string) string {
+	if o == nil {
+		return StrategyHelper
+	}
+	strategy := o.Strategy
+	for _, r := range o.Rules {
+		name := rel
+		if !strings.Contains(r.Pattern, "/") {
+			name = path.Base(rel)
+		}
+		if matched, _ := path.Match(r.Pattern, name); matched {
+			strategy = r.Strategy
+		}
+	}
+	if len(strategy) == 0 {
+		return StrategyHelper
+	}
+	return strategy
+}
+
+// relativePath returns the given nested path relative to the merge
+// destination, using the name of the destination itself for the root.
+func (opts *mergeOptions) relativePath(subPath snapshot.Path) string {
+	rel, err := filepath.Rel(string(opts.root), string(subPath))
+	if err != nil || len(opts.root) == 0 {
+		return filepath.ToSlash(string(subPath))
+	}
+	if rel == "." {
+		return filepath.Base(string(subPath))
+	}
+	return filepath.ToSlash(rel)
+}
+
+// newest returns whichever of the two snapshots is more recent.
+func newest(ctx context.Context, s *storage.LocalFiles, src, dest *snapshot.Hash) (*snapshot.Hash, error) {
+	_, srcAnnotation, err := s.FindAnnotation(ctx, src)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the annotation for %q: %v", src, err)
+	}
+	_, destAnnotation, err := s.FindAnnotation(ctx, dest)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the annotation for %q: %v", dest, err)
+	}
+	if srcAnnotation != nil && destAnnotation != nil && !srcAnnotation.Time.Equal(destAnnotation.Time) {
+		if srcAnnotation.Time.After(destAnnotation.Time) {
+			return src, nil
+		}
+		return dest, nil
+	}
+	srcAncestry, err := s.Ancestry(ctx, src)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the ancestry of %q: %v", src, err)
+	}
+	destAncestry, err := s.Ancestry(ctx, dest)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the ancestry of %q: %v", dest, err)
+	}
+	if srcAncestry.Generation > destAncestry.Generation {
+		return src, nil
+	}
+	return dest, nil
+}
+
+// takeSide stores a merged snapshot with the contents and permissions of
+// the chosen side, and both sides as its parents.
+//
+// If the chosen side deleted the path, then nil is returned.
+func takeSide(ctx context.Context, s *storage.LocalFiles, chosen *snapshot.File, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	if chosen == nil {
+		return nil, nil
+	}
+	var parents []*snapshot.Hash
+	for _, p := range []*snapshot.Hash{src, dest} {
+		if p != nil {
+			parents = append(parents, p)
+		}
+	}
+	return storeMerged(ctx, s, &snapshot.File{
+		Mode:     chosen.Mode,
+		Contents: chosen.Contents,
+		Parents:  parents,
+	}, opts)
+}
+
+// readLines reads the lines of the given file snapshot, reporting false
+// if it is not a text file.
+func readLines(ctx context.Context, s *storage.LocalFiles, f *snapshot.File) ([]string, bool, error) {
+	if f == nil || f.IsDir() || f.IsLink() {
+		return nil, false, nil
+	}
+	contents, binary, err := diff.ReadContents(ctx, s, f)
+	if err != nil || binary {
+		return nil, false, err
+	}
+	return diff.SplitLines(string(contents)), true, nil
+}
+
+// union merges the lines of the two text file snapshots, keeping the lines
+// from both sides wherever they conflict.
+//
+// If either side is not a text file, then nil is returned.
+func union(ctx context.Context, s *storage.LocalFiles, baseFile, srcFile, destFile *snapshot.File, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	srcLines, ok, err := readLines(ctx, s, srcFile)
+	if err != nil || !ok {
+		return nil, err
+	}
+	destLines, ok, err := readLines(ctx, s, destFile)
+	if err != nil || !ok {
+		return nil, err
+	}
+	// A base that is missing or not a text file is treated as empty.
+	baseLines, _, err := readLines(ctx, s, baseFile)
+	if err != nil {
+		return nil, err
+	}
+	var merged []string
+	for _, chunk := range mergeLines(baseLines, srcLines, destLines) {
+		if chunk.conflict {
+			merged = append(merged, chunk.dest...)
+			merged = append(merged, chunk.src...)
+		} else {
+			merged = append(merged, chunk.lines...)
+		}
+	}
+	contents := []byte(strings.Join(merged, ""))
+	contentsHash, err := s.StoreObject(ctx, int64(len(contents)), bytes.NewReader(contents))
+	if err != nil {
+		return nil, fmt.Errorf("failure storing the merged contents: %v", err)
+	}
+	return storeMerged(ctx, s, &snapshot.File{
+		Mode:     destFile.Mode,
+		Contents: contentsHash,
+		Parents:  []*snapshot.Hash{src, dest},
+	}, opts)
+}
+
+// mergeWithStrategy tries to merge the given nested path, which was changed
+// on both sides, using the strategy configured for it.
+//
+// The returned `resolved` value is false if the strategy does not apply to
+// the path, in which case it should be merged as usual.
+func mergeWithStrategy(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, base, src, dest *snapshot.Hash, opts *mergeOptions) (merged *snapshot.Hash, resolved bool, err error) {
+	strategy := opts.strategies.strategyFor(opts.relativePath(subPath))
+	if strategy == StrategyHelper {
+		return nil, false, nil
+	}
+	srcFile, err := readSnapshotIfPresent(ctx, s, src)
+	if err != nil {
+		return nil, false, err
+	}
+	destFile, err := readSnapshotIfPresent(ctx, s, dest)
+	if err != nil {
+		return nil, false, err
+	}
+	if srcFile.IsDir() && destFile.IsDir() {
+		return nil, false, nil
+	}
+	switch strategy {
+	case StrategyOurs:
+		merged, err := takeSide(ctx, s, destFile, src, dest, opts)
+		return merged, err == nil, err
+	case StrategyTheirs:
+		merged, err := takeSide(ctx, s, srcFile, src, dest, opts)
+		return merged, err == nil, err
+	case StrategyNewest:
+		if src == nil || dest == nil {
+			return nil, false, nil
+		}
+		chosen, err := newest(ctx, s, src, dest)
+		if err != nil {
+			return nil, false, err
+		}
+		chosenFile := destFile
+		if chosen.Equal(src) {
+			chosenFile = srcFile
+		}
+		merged, err := takeSide(ctx, s, chosenFile, src, dest, opts)
+		return merged, err == nil, err
+	case StrategyUnion:
+		baseFile, err := readSnapshotIfPresent(ctx, s, base)
+		if err != nil {
+			return nil, false, err
+		}
+		merged, err := union(ctx, s, baseFile, srcFile, destFile, src, dest, opts)
+		return merged, merged != nil, err
+	}
+	driver := opts.strategies.Drivers[strategy]
+	if driver == nil || srcFile == nil || destFile == nil || srcFile.IsDir() || destFile.IsDir() || srcFile.IsLink() || destFile.IsLink() {
+		return nil, false, nil
+	}
+	merged, err = runHelper(ctx, s, subPath, destFile.Mode, base, src, dest, driver.Command, driver.Args, opts)
+	return merged, true, err
+}
@@ -0,0 +1,203 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/recursive-version-control-system/diff"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func TestMergeLines(t *testing.T) {
+	testCases := []struct {
+		Description       string
+		Base, Src, Dest   string
+		Want              []string
+		WantConflictCount int
+	}{
+		{
+			Description: "Changes on different lines",
+			Base:        "a\nb\nc\nd\n",
+			Src:         "A\nb\nc\nd\n",
+			Dest:        "a\nb\nc\nD\n",
+			Want:        []string{"A\nb\nc\nD\n"},
+		},
+		{
+			Description: "Identical changes",
+			Base:        "a\nb\n",
+			Src:         "a\nB\n",
+			Dest:        "a\nB\n",
+			Want:        []string{"a\nB\n"},
+		},
+		{
+			Description:       "Conflicting changes",
+			Base:              "a\nb\nc\n",
+			Src:               "a\nsource\nc\n",
+			Dest:              "a\ndestination\nc\n",
+			Want:              []string{"a\n", "<conflict>", "c\n"},
+			WantConflictCount: 1,
+		},
+		{
+			Description:       "Conflicting additions to an empty base",
+			Src:               "source\n",
+			Dest:              "destination\n",
+			Want:              []string{"<conflict>"},
+			WantConflictCount: 1,
+		},
+	}
+	for _, testCase := range testCases {
+		chunks := mergeLines(diff.SplitLines(testCase.Base), diff.SplitLines(testCase.Src), diff.SplitLines(testCase.Dest))
+		var got []string
+		var conflicts int
+		for _, c := range chunks {
+			if c.conflict {
+				conflicts++
+				got = append(got, "<conflict>")
+				continue
+			}
+			var joined string
+			for _, line := range c.lines {
+				joined += line
+			}
+			got = append(got, joined)
+		}
+		if diff := cmp.Diff(testCase.Want, got); len(diff) > 0 {
+			t.Errorf("unexpected merged chunks for %q: %s", testCase.Description, diff)
+		}
+		if conflicts != testCase.WantConflictCount {
+			t.Errorf("unexpected number of conflicts for %q: got %d, want %d", testCase.Description, conflicts, testCase.WantConflictCount)
+		}
+	}
+}
+
+func TestMergeStrategies(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+
+	srcDir := filepath.Join(dir, "src")
+	destDir := filepath.Join(dir, "dest")
+	writeFiles := func(root string, files map[string]string) {
+		for name, contents := range files {
+			p := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+				t.Fatalf("failure creating the parent directory of %q: %v", p, err)
+			}
+			if err := os.WriteFile(p, []byte(contents), 0700); err != nil {
+				t.Fatalf("failure writing %q: %v", p, err)
+			}
+		}
+	}
+	takeSnapshot := func(root string) *snapshot.Hash {
+		h, _, err := snapshot.Current(ctx, s, snapshot.Path(root))
+		if err != nil {
+			t.Fatalf("failure snapshotting %q: %v", root, err)
+		}
+		return h
+	}
+	writeFiles(srcDir, map[string]string{
+		"app.lock":       "base\n",
+		"CHANGELOG":      "base entry\n",
+		"gen/output.txt": "base\n",
+		"notes.txt":      "base\n",
+		"custom.txt":     "base\n",
+		"other.txt":      "base\n",
+	})
+	if _, err := Merge(ctx, s, takeSnapshot(srcDir), snapshot.Path(destDir), nil); err != nil {
+		t.Fatalf("failure checking out the base: %v", err)
+	}
+	writeFiles(srcDir, map[string]string{
+		"app.lock":       "source\n",
+		"CHANGELOG":      "source entry\nbase entry\n",
+		"gen/output.txt": "source\n",
+		"notes.txt":      "source\n",
+		"custom.txt":     "source\n",
+		"other.txt":      "source\n",
+	})
+	writeFiles(destDir, map[string]string{
+		"app.lock":       "destination\n",
+		"CHANGELOG":      "destination entry\nbase entry\n",
+		"gen/output.txt": "destination\n",
+		"custom.txt":     "destination\n",
+		"other.txt":      "destination\n",
+	})
+	takeSnapshot(destDir)
+	// Change the source version of the notes twice so that it is newer.
+	src := takeSnapshot(srcDir)
+	writeFiles(srcDir, map[string]string{"notes.txt": "newest source\n"})
+	writeFiles(destDir, map[string]string{"notes.txt": "destination\n"})
+	src = takeSnapshot(srcDir)
+
+	// Drivers are usually configured with the absolute path of their command.
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Fatalf("failure finding the shell: %v", err)
+	}
+	opts := &Options{
+		Strategy: StrategyOurs,
+		Rules: []*Rule{
+			{Pattern: "*.lock", Strategy: StrategyTheirs},
+			{Pattern: "CHANGELOG", Strategy: StrategyUnion},
+			{Pattern: "gen/*", Strategy: StrategyTheirs},
+			{Pattern: "gen/output.txt", Strategy: StrategyOurs},
+			{Pattern: "notes.txt", Strategy: StrategyNewest},
+			{Pattern: "custom.txt", Strategy: "concat"},
+		},
+		Drivers: map[string]*Driver{
+			"concat": {Command: sh, Args: []string{"-c", `cat "$3" "$1"`, "concat"}},
+		},
+	}
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("unexpected error validating the options: %v", err)
+	}
+	if err := (&Options{Strategy: "unknown"}).Validate(); err == nil {
+		t.Error("unexpected success validating an unknown strategy")
+	}
+	if _, err := Merge(ctx, s, src, snapshot.Path(destDir), opts); err != nil {
+		t.Fatalf("failure merging with strategies: %v", err)
+	}
+	for name, want := range map[string]string{
+		"app.lock":       "source\n",
+		"CHANGELOG":      "destination entry\nsource entry\nbase entry\n",
+		"gen/output.txt": "destination\n",
+		"notes.txt":      "newest source\n",
+		"custom.txt":     "destination\nsource\n",
+		"other.txt":      "destination\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(destDir, name))
+		if err != nil {
+			t.Errorf("failure reading the merged %q: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("unexpected merged contents for %q: got %q, want %q", name, got, want)
+		}
+	}
+	// The sides chosen by a strategy are recorded as merges of both sides.
+	for _, name := range []string{"app.lock", "gen/output.txt", "notes.txt"} {
+		p := snapshot.Path(filepath.Join(destDir, name))
+		if _, f, err := s.FindSnapshot(ctx, p); err != nil {
+			t.Errorf("failure reading the merged snapshot of %q: %v", p, err)
+		} else if len(f.Parents) != 2 {
+			t.Errorf("unexpected parents for the merged snapshot of %q: %v", p, f.Parents)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+const (
+	sourceMarker      = "<<<<<<< source\n"
+	baseMarker        = "||||||| base\n"
+	separatorMarker   = "=======\n"
+	destinationMarker = ">>>>>>> destination\n"
+)
+
+// writeSection writes the given lines, followed by a newline if the
+// last of them does not already end with one, so that the next conflict
+// marker starts on its own line.
+func writeSection(buf *strings.Builder, lines []string) {
+	for _, line := range lines {
+		buf.WriteString(line)
+	}
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		buf.WriteString("\n")
+	}
+}
+
+// mergeText performs a three-way merge of the lines of the given text
+// files, returning the merged contents and whether or not there were any
+// conflicts.
+//
+// Conflicting changes are marked in the merged contents in the same
+// format as `diff3 -m`, with the sections labeled "source", "base", and
+// "destination".
+func mergeText(base, src, dest []string) (merged []byte, conflicts bool) {
+	var buf strings.Builder
+	for _, chunk := range mergeLines(base, src, dest) {
+		if !chunk.conflict {
+			for _, line := range chunk.lines {
+				buf.WriteString(line)
+			}
+			continue
+		}
+		conflicts = true
+		buf.WriteString(sourceMarker)
+		writeSection(&buf, chunk.src)
+		buf.WriteString(baseMarker)
+		writeSection(&buf, chunk.base)
+		buf.WriteString(separatorMarker)
+		writeSection(&buf, chunk.dest)
+		buf.WriteString(destinationMarker)
+	}
+	return []byte(buf.String()), conflicts
+}
+
+// mergeWithText merges the given file snapshots using the built-in
+// three-way merge of their lines.
+//
+// A missing base, or a base that is not a text file, is treated as empty.
+func mergeWithText(ctx context.Context, s *storage.LocalFiles, p snapshot.Path, mode string, base, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	srcFile, err := readSnapshotIfPresent(ctx, s, src)
+	if err != nil {
+		return nil, err
+	}
+	destFile, err := readSnapshotIfPresent(ctx, s, dest)
+	if err != nil {
+		return nil, err
+	}
+	baseFile, err := readSnapshotIfPresent(ctx, s, base)
+	if err != nil {
+		return nil, err
+	}
+	srcLines, srcText, err := readLines(ctx, s, srcFile)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the source version of %q: %v", p, err)
+	}
+	destLines, destText, err := readLines(ctx, s, destFile)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the destination version of %q: %v", p, err)
+	}
+	if !srcText || !destText {
+		return nil, &conflictError{
+			kind:   ConflictVersions,
+			reason: fmt.Sprintf("one or both versions of %q are not text files, so the two snapshots for that path have to be manually merged", p),
+		}
+	}
+	baseLines, _, err := readLines(ctx, s, baseFile)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the base version of %q: %v", p, err)
+	}
+	merged, conflicts := mergeText(baseLines, srcLines, destLines)
+	if conflicts {
+		return nil, &conflictError{
+			kind:    ConflictMarkers,
+			reason:  fmt.Sprintf("both versions of %q have conflicting changes", p),
+			markers: merged,
+		}
+	}
+	contentsHash, err := s.StoreObject(ctx, int64(len(merged)), bytes.NewReader(merged))
+	if err != nil {
+		return nil, fmt.Errorf("failure storing the merged contents: %v", err)
+	}
+	mergedFile := &snapshot.File{
+		Mode:     mode,
+		Contents: contentsHash,
+		Parents:  []*snapshot.Hash{src, dest},
+	}
+	return storeMerged(ctx, s, mergedFile, opts)
+}
+
+// mergeFiles merges the given snapshots, at least one of which is not a
+// directory.
+//
+// If a merge helper command is configured, then that is used. Otherwise,
+// the built-in three-way merge of lines is used.
+func mergeFiles(ctx context.Context, s *storage.LocalFiles, p snapshot.Path, mode string, base, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	if len(os.Getenv(HelperEnvironmentVariable)) > 0 {
+		return mergeWithHelper(ctx, s, p, mode, base, src, dest, opts)
+	}
+	return mergeWithText(ctx, s, p, mode, base, src, dest, opts)
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/recursive-version-control-system/diff"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func TestMergeText(t *testing.T) {
+	testCases := []struct {
+		Description   string
+		Base, Src     string
+		Dest          string
+		Want          string
+		WantConflicts bool
+	}{
+		{
+			Description: "Non-conflicting changes",
+			Base:        "A\nB\nC\nD\nE\n",
+			Src:         "A\nX\nB\nY\nC\nD\nE\n",
+			Dest:        "A\nB\nC\nZ\nD\nE\n",
+			Want:        "A\nX\nB\nY\nC\nZ\nD\nE\n",
+		},
+		{
+			Description:   "Conflicting changes",
+			Base:          "A\nB\nC\n",
+			Src:           "A\nsource\nC\n",
+			Dest:          "A\ndestination\nC\n",
+			Want:          "A\n<<<<<<< source\nsource\n||||||| base\nB\n=======\ndestination\n>>>>>>> destination\nC\n",
+			WantConflicts: true,
+		},
+		{
+			Description:   "Conflicting changes without trailing newlines",
+			Base:          "A\nB",
+			Src:           "A\nsource",
+			Dest:          "A\ndestination",
+			Want:          "A\n<<<<<<< source\nsource\n||||||| base\nB\n=======\ndestination\n>>>>>>> destination\n",
+			WantConflicts: true,
+		},
+	}
+	for _, testCase := range testCases {
+		merged, conflicts := mergeText(diff.SplitLines(testCase.Base), diff.SplitLines(testCase.Src), diff.SplitLines(testCase.Dest))
+		if got, want := string(merged), testCase.Want; got != want {
+			t.Errorf("unexpected merged contents for %q: got %q, want %q", testCase.Description, got, want)
+		}
+		if got, want := conflicts, testCase.WantConflicts; got != want {
+			t.Errorf("unexpected conflicts result for %q: got %v, want %v", testCase.Description, got, want)
+		}
+	}
+}
+
+func TestMergeWithText(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+	original := snapshot.Path(filepath.Join(dir, "original.txt"))
+
+	versions := make(map[string]*snapshot.Hash)
+	for name, contents := range map[string]string{
+		"base":     "A\nB\nC\n",
+		"source":   "A\nsource\nC\n",
+		"dest":     "A\nB\nC\ndestination\n",
+		"conflict": "A\nconflict\nC\n",
+		"binary":   "\x00binary",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(contents), 0700); err != nil {
+			t.Fatalf("failure writing the %q version: %v", name, err)
+		}
+		h, _, err := snapshot.Current(ctx, s, snapshot.Path(p))
+		if err != nil {
+			t.Fatalf("failure snapshotting the %q version: %v", name, err)
+		}
+		versions[name] = h
+	}
+
+	merged, err := mergeWithText(ctx, s, original, "-rwx------", versions["base"], versions["source"], versions["dest"], &mergeOptions{})
+	if err != nil {
+		t.Fatalf("failure merging non-conflicting changes: %v", err)
+	}
+	mergedFile, err := s.ReadSnapshot(ctx, merged)
+	if err != nil {
+		t.Fatalf("failure reading the merged snapshot: %v", err)
+	}
+	if contents, _, err := diff.ReadContents(ctx, s, mergedFile); err != nil {
+		t.Errorf("failure reading the merged contents: %v", err)
+	} else if got, want := string(contents), "A\nsource\nC\ndestination\n"; got != want {
+		t.Errorf("unexpected merged contents: got %q, want %q", got, want)
+	}
+	if len(mergedFile.Parents) != 2 || !mergedFile.Parents[0].Equal(versions["source"]) || !mergedFile.Parents[1].Equal(versions["dest"]) {
+		t.Errorf("unexpected parents for the merged snapshot: %v", mergedFile.Parents)
+	}
+
+	var conflict *conflictError
+	_, err = mergeWithText(ctx, s, original, "-rwx------", versions["base"], versions["source"], versions["conflict"], &mergeOptions{})
+	if !errors.As(err, &conflict) || conflict.kind != ConflictMarkers || len(conflict.markers) == 0 {
+		t.Errorf("unexpected result merging conflicting changes: %v", err)
+	}
+	_, err = mergeWithText(ctx, s, original, "-rwx------", versions["base"], versions["source"], versions["binary"], &mergeOptions{})
+	if !errors.As(err, &conflict) || conflict.kind != ConflictVersions {
+		t.Errorf("unexpected result merging a binary file: %v", err)
+	}
+}
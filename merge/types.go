@@ -0,0 +1,131 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// fileType returns the type of the given file snapshot; either a regular
+// file, a directory, or a symlink.
+func fileType(f *snapshot.File) string {
+	if f.IsLink() {
+		return "symlink"
+	} else if f.IsDir() {
+		return "directory"
+	}
+	return "regular file"
+}
+
+// sameContents reports whether or not the two file snapshots have the same
+// contents and permissions, regardless of their histories.
+//
+// Directories are compared recursively, since the histories of their
+// nested files are part of their contents.
+func sameContents(ctx context.Context, s *storage.LocalFiles, a, b *snapshot.File) (bool, error) {
+	if a == nil || b == nil || a.Mode != b.Mode {
+		return false, nil
+	}
+	if a.Contents.Equal(b.Contents) {
+		return true, nil
+	}
+	if !a.IsDir() || !b.IsDir() {
+		return false, nil
+	}
+	aTree, bTree, err := snapshot.DiffTrees(ctx, s, a.Contents, b.Contents)
+	if err != nil {
+		return false, fmt.Errorf("failure comparing the trees %q and %q: %v", a.Contents, b.Contents, err)
+	}
+	for p, aChild := range aTree {
+		bChild := bTree[p]
+		if aChild == nil || bChild == nil {
+			return false, nil
+		}
+		aChildFile, err := s.ReadSnapshot(ctx, aChild)
+		if err != nil {
+			return false, fmt.Errorf("failure reading the file snapshot for %q: %v", aChild, err)
+		}
+		bChildFile, err := s.ReadSnapshot(ctx, bChild)
+		if err != nil {
+			return false, fmt.Errorf("failure reading the file snapshot for %q: %v", bChild, err)
+		}
+		if same, err := sameContents(ctx, s, aChildFile, bChildFile); err != nil || !same {
+			return false, err
+		}
+	}
+	for p, _ := range bTree {
+		if aTree[p] == nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// mergeUnchanged merges the given snapshots when one of them has the same
+// contents and permissions as the base, by taking the other one.
+//
+// The returned boolean reports whether or not that was the case.
+func mergeUnchanged(ctx context.Context, s *storage.LocalFiles, baseFile, srcFile, destFile *snapshot.File, src, dest *snapshot.Hash, opts *mergeOptions) (merged *snapshot.Hash, resolved bool, err error) {
+	var kept *snapshot.File
+	if same, err := sameContents(ctx, s, baseFile, srcFile); err != nil {
+		return nil, false, err
+	} else if same {
+		kept = destFile
+	} else if same, err := sameContents(ctx, s, baseFile, destFile); err != nil {
+		return nil, false, err
+	} else if same {
+		kept = srcFile
+	}
+	if kept == nil {
+		return nil, false, nil
+	}
+	merged, err = storeMerged(ctx, s, &snapshot.File{
+		Mode:     kept.Mode,
+		Contents: kept.Contents,
+		Parents:  []*snapshot.Hash{src, dest},
+	}, opts)
+	return merged, err == nil, err
+}
+
+// mergeTypes merges the given snapshots, which are different types of files.
+//
+// If one side has the same contents and permissions as the base, then only
+// the other side changed the path, so that side is taken. Otherwise both
+// sides changed it, and the path has a type conflict.
+func mergeTypes(ctx context.Context, s *storage.LocalFiles, subPath snapshot.Path, baseFile, srcFile, destFile *snapshot.File, src, dest *snapshot.Hash, opts *mergeOptions) (*snapshot.Hash, error) {
+	if merged, resolved, err := mergeUnchanged(ctx, s, baseFile, srcFile, destFile, src, dest, opts); err != nil || resolved {
+		return merged, err
+	}
+	var reason string
+	switch {
+	case baseFile == nil:
+		reason = fmt.Sprintf("the path %q was added as a %s in the source and as a %s in the destination", subPath, fileType(srcFile), fileType(destFile))
+	case fileType(baseFile) == fileType(srcFile):
+		reason = fmt.Sprintf("the %s at %q was changed into a %s in the destination, and modified in the source", fileType(baseFile), subPath, fileType(destFile))
+	case fileType(baseFile) == fileType(destFile):
+		reason = fmt.Sprintf("the %s at %q was changed into a %s in the source, and modified in the destination", fileType(baseFile), subPath, fileType(srcFile))
+	default:
+		reason = fmt.Sprintf("the %s at %q was changed into a %s in the source and into a %s in the destination", fileType(baseFile), subPath, fileType(srcFile), fileType(destFile))
+	}
+	return nil, &conflictError{
+		kind:   ConflictType,
+		reason: reason + ", so the two snapshots for that path have to be manually merged",
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func TestMergeTypes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+
+	srcDir := filepath.Join(dir, "src")
+	destDir := filepath.Join(dir, "dest")
+	base := writeAndSnapshot(ctx, t, s, srcDir, map[string]string{
+		"config":    "file\n",
+		"only-src":  "file\n",
+		"only-dest": "dir:nested\n",
+	})
+	if _, err := Merge(ctx, s, base, snapshot.Path(destDir), nil); err != nil {
+		t.Fatalf("failure checking out the base: %v", err)
+	}
+
+	// Each side changes the paths that only the other side changes the
+	// type of and then changes them back, so that they only differ from
+	// the base in their history.
+	writeAndSnapshot(ctx, t, s, srcDir, map[string]string{"only-dest": "dir:temporary\n"})
+	src := writeAndSnapshot(ctx, t, s, srcDir, map[string]string{
+		"config":    "dir:source\n",
+		"only-src":  "dir:source\n",
+		"only-dest": "dir:nested\n",
+		"added":     "source\n",
+	})
+	writeAndSnapshot(ctx, t, s, destDir, map[string]string{"only-src": "temporary\n"})
+	writeAndSnapshot(ctx, t, s, destDir, map[string]string{
+		"config":    "changed in the destination\n",
+		"only-src":  "file\n",
+		"only-dest": "destination\n",
+		"added":     "dir:destination\n",
+	})
+
+	_, err := Merge(ctx, s, src, snapshot.Path(destDir), nil)
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("unexpected result merging the type changes: %v", err)
+	}
+	wantConflicts := map[snapshot.Path]bool{"config": true, "added": true}
+	if len(conflictErr.Conflicts) != len(wantConflicts) {
+		t.Errorf("unexpected conflicts: %+v", conflictErr.Conflicts)
+	}
+	for _, c := range conflictErr.Conflicts {
+		if !wantConflicts[c.Path] || c.Kind != ConflictType || c.Sidecar != c.Path+SourceSuffix {
+			t.Errorf("unexpected conflict: %+v", c)
+		}
+	}
+	for name, want := range map[string]string{
+		"config": "changed in the destination\n",
+		filepath.Join("config"+SourceSuffix, "nested.txt"): "source\n",
+		filepath.Join("only-src", "nested.txt"):            "source\n",
+		"only-dest":                                        "destination\n",
+		filepath.Join("added", "nested.txt"):               "destination\n",
+		"added" + SourceSuffix:                             "source\n",
+	} {
+		if got, err := os.ReadFile(filepath.Join(destDir, name)); err != nil || string(got) != want {
+			t.Errorf("unexpected contents for %q: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package merge defines methods for merging two snapshots together.
+package merge
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// writeAndSnapshot replaces the given nested paths of `root` and then
+// snapshots it.
+//
+// Each path is written as a regular file with the given contents, unless
+// the contents start with "link:", in which case the path is written as
+// a symbolic link to the rest of the contents, or "dir:", in which case
+// the path is written as a directory holding a single "nested.txt" file
+// with the rest of the contents. Paths with empty contents are removed.
+func writeAndSnapshot(ctx context.Context, t *testing.T, s *storage.LocalFiles, root string, files map[string]string) *snapshot.Hash {
+	for name, contents := range files {
+		p := filepath.Join(root, name)
+		if err := os.RemoveAll(p); err != nil {
+			t.Fatalf("failure removing %q: %v", p, err)
+		}
+		if len(contents) == 0 {
+			continue
+		}
+		if strings.HasPrefix(contents, "dir:") {
+			p = filepath.Join(p, "nested.txt")
+			contents = strings.TrimPrefix(contents, "dir:")
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatalf("failure creating the parent of %q: %v", p, err)
+		}
+		if strings.HasPrefix(contents, "link:") {
+			if err := os.Symlink(strings.TrimPrefix(contents, "link:"), p); err != nil {
+				t.Fatalf("failure creating the link %q: %v", p, err)
+			}
+		} else if err := os.WriteFile(p, []byte(contents), 0700); err != nil {
+			t.Fatalf("failure writing %q: %v", p, err)
+		}
+	}
+	h, _, err := snapshot.Current(ctx, s, snapshot.Path(root))
+	if err != nil {
+		t.Fatalf("failure snapshotting %q: %v", root, err)
+	}
+	return h
+}
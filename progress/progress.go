@@ -0,0 +1,183 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package progress defines methods for reporting the progress of long-running operations.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reporter receives progress updates from long-running operations.
+//
+// Implementations must be safe for concurrent use.
+type Reporter interface {
+	// SetTotal reports the total amount of work, if it is known ahead of time.
+	SetTotal(files, bytes int64)
+
+	// Add reports that the given number of files and bytes were processed.
+	Add(files, bytes int64)
+}
+
+type reporterKey struct{}
+
+// WithReporter returns a copy of the given context that carries the given reporter.
+//
+// Long-running operations such as `snapshot.Current`, `merge.Checkout`,
+// `bundle.Export`, and `bundle.Import` report their progress to the
+// reporter carried by the context they are passed.
+func WithReporter(ctx context.Context, r Reporter) context.Context {
+	return context.WithValue(ctx, reporterKey{}, r)
+}
+
+// SetTotal reports the total amount of work to the reporter carried by the given context, if any.
+func SetTotal(ctx context.Context, files, bytes int64) {
+	if r, ok := ctx.Value(reporterKey{}).(Reporter); ok && r != nil {
+		r.SetTotal(files, bytes)
+	}
+}
+
+// Add reports processed work to the reporter carried by the given context, if any.
+func Add(ctx context.Context, files, bytes int64) {
+	if r, ok := ctx.Value(reporterKey{}).(Reporter); ok && r != nil {
+		r.Add(files, bytes)
+	}
+}
+
+// Reader wraps the given reader so that it stops with the context's error
+// once the context is cancelled.
+//
+// This lets copies of large files be interrupted part way through.
+func Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, nested: r}
+}
+
+type contextReader struct {
+	ctx    context.Context
+	nested io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.nested.Read(p)
+}
+
+// Bar is a `Reporter` that renders a single, continually updated line of
+// progress to a terminal.
+type Bar struct {
+	w           io.Writer
+	label       string
+	minInterval time.Duration
+
+	mu                     sync.Mutex
+	files, bytes           int64
+	totalFiles, totalBytes int64
+	lastRender             time.Time
+	rendered               bool
+}
+
+// NewBar returns a new progress bar that writes to the given writer.
+func NewBar(w io.Writer, label string) *Bar {
+	return &Bar{
+		w:           w,
+		label:       label,
+		minInterval: 100 * time.Millisecond,
+	}
+}
+
+// SetTotal implements the `Reporter` interface.
+func (b *Bar) SetTotal(files, bytes int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.totalFiles, b.totalBytes = files, bytes
+	b.render()
+}
+
+// Add implements the `Reporter` interface.
+func (b *Bar) Add(files, bytes int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files += files
+	b.bytes += bytes
+	b.render()
+}
+
+// Done clears the progress line, if one was rendered.
+func (b *Bar) Done() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rendered {
+		fmt.Fprint(b.w, "\r\033[K")
+		b.rendered = false
+	}
+}
+
+// String returns the current progress line.
+func (b *Bar) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.line()
+}
+
+func (b *Bar) line() string {
+	var sb strings.Builder
+	sb.WriteString(b.label)
+	sb.WriteString(": ")
+	if b.totalFiles > 0 {
+		const width = 30
+		done := int(b.files * width / b.totalFiles)
+		if done > width {
+			done = width
+		}
+		fmt.Fprintf(&sb, "[%s%s] %d/%d files", strings.Repeat("=", done), strings.Repeat(" ", width-done), b.files, b.totalFiles)
+	} else {
+		fmt.Fprintf(&sb, "%d files", b.files)
+	}
+	fmt.Fprintf(&sb, ", %s", FormatBytes(b.bytes))
+	if b.totalBytes > 0 {
+		fmt.Fprintf(&sb, "/%s", FormatBytes(b.totalBytes))
+	}
+	return sb.String()
+}
+
+func (b *Bar) render() {
+	now := time.Now()
+	if now.Sub(b.lastRender) < b.minInterval {
+		return
+	}
+	b.lastRender = now
+	b.rendered = true
+	fmt.Fprintf(b.w, "\r\033[K%s", b.line())
+}
+
+// FormatBytes returns a human readable representation of the given number of bytes.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
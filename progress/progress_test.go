@@ -0,0 +1,100 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progress
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+type counter struct {
+	files, bytes           int64
+	totalFiles, totalBytes int64
+}
+
+func (c *counter) SetTotal(files, bytes int64) {
+	c.totalFiles, c.totalBytes = files, bytes
+}
+
+func (c *counter) Add(files, bytes int64) {
+	c.files += files
+	c.bytes += bytes
+}
+
+func TestContextReporter(t *testing.T) {
+	// Reporting without a reporter must be a no-op.
+	SetTotal(context.Background(), 1, 1)
+	Add(context.Background(), 1, 1)
+
+	c := &counter{}
+	ctx := WithReporter(context.Background(), c)
+	SetTotal(ctx, 3, 30)
+	Add(ctx, 1, 10)
+	Add(ctx, 2, 20)
+	if got, want := *c, (counter{files: 3, bytes: 30, totalFiles: 3, totalBytes: 30}); got != want {
+		t.Errorf("unexpected reported progress: got %+v, want %+v", got, want)
+	}
+}
+
+func TestReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := Reader(ctx, strings.NewReader("Hello, World!"))
+	buf := make([]byte, 5)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatalf("failure reading before cancellation: %v", err)
+	}
+	cancel()
+	if _, err := io.ReadAll(r); err != context.Canceled {
+		t.Errorf("unexpected error reading after cancellation: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestBar(t *testing.T) {
+	var out bytes.Buffer
+	b := NewBar(&out, "Testing")
+	b.Add(2, 2048)
+	if got, want := b.String(), "Testing: 2 files, 2.0 KiB"; got != want {
+		t.Errorf("unexpected progress line without a total: got %q, want %q", got, want)
+	}
+	b.SetTotal(4, 4096)
+	if got, want := b.String(), "Testing: [===============               ] 2/4 files, 2.0 KiB/4.0 KiB"; got != want {
+		t.Errorf("unexpected progress line with a total: got %q, want %q", got, want)
+	}
+	if !strings.Contains(out.String(), "Testing: 2 files") {
+		t.Errorf("progress was not rendered: got %q", out.String())
+	}
+	b.Done()
+	if !strings.HasSuffix(out.String(), "\r\033[K") {
+		t.Errorf("progress line was not cleared: got %q", out.String())
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	testCases := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KiB",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+	}
+	for n, want := range testCases {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("unexpected formatting of %d: got %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package revision defines a syntax for expressions that refer to snapshots.
+//
+// An expression starts with a base reference, which is either the hash of
+// a snapshot, an identity, or a local path that has been snapshotted.
+//
+// The base can then be followed by any of these suffixes, in order:
+//
+//	@{N}       The Nth previous snapshot mapped to a path or identity.
+//	@{DATE}    The snapshot mapped to a path or identity as of the given date.
+//	^N         The Nth parent of a snapshot (`^` is short for `^1`).
+//	~N         The Nth generation ancestor following only first parents
+//	           (`~` is short for `~1`).
+//	:SUBPATH   The nested file at the given subpath of a directory snapshot.
+//
+// The `^N` and `~N` suffixes can be repeated, and everything after the
+// `:` is treated as the subpath.
+package revision
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// Resolver looks up the snapshots referenced by the base of an expression.
+type Resolver interface {
+	// Current returns the snapshot currently referenced by the base.
+	Current(ctx context.Context, base string) (*snapshot.Hash, error)
+
+	// Previous returns the snapshot that the base referenced `n` updates ago.
+	Previous(ctx context.Context, base string, n int) (*snapshot.Hash, error)
+
+	// AsOf returns the snapshot that the base referenced at the given time.
+	AsOf(ctx context.Context, base string, t time.Time) (*snapshot.Hash, error)
+}
+
+// Step is a single parent navigation step in an expression.
+type Step struct {
+	// Op is either '^' to select the Nth parent, or '~' to follow the
+	// first parent N times.
+	Op byte
+
+	// N is the numeric argument of the step.
+	N int
+}
+
+// Expression is a parsed revision expression.
+type Expression struct {
+	// Base is the reference the expression starts from.
+	Base string
+
+	// HistoryIndex is the N in a `@{N}` suffix, or -1 if there is none.
+	HistoryIndex int
+
+	// HistoryTime is the date in a `@{DATE}` suffix, or the zero time if there is none.
+	HistoryTime time.Time
+
+	// Steps are the parent navigation steps, in order.
+	Steps []Step
+
+	// Subpath is the nested path selected by a `:SUBPATH` suffix.
+	Subpath snapshot.Path
+
+	// HasSubpath reports whether or not the expression has a `:SUBPATH` suffix.
+	HasSubpath bool
+}
+
+var hashPrefix = regexp.MustCompile(`^[a-z0-9]+:[0-9a-f]+`)
+
+// dateFormats are the supported formats for `@{DATE}` suffixes.
+//
+// Formats without a time zone are interpreted in local time.
+var dateFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseDate(str string) (time.Time, error) {
+	for _, format := range dateFormats {
+		if t, err := time.ParseInLocation(format, str, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported date %q", str)
+}
+
+// baseLengths returns the possible lengths of the base reference at the
+// start of `expr`, shortest first.
+//
+// Local paths can themselves contain the `^`, `~`, and `:` characters, so
+// the base might end at any of those. A `@{` always ends the base, but it
+// only follows the base directly if the base has none of those characters.
+func baseLengths(expr string) []int {
+	if prefix := hashPrefix.FindString(expr); len(prefix) > 0 {
+		if _, err := snapshot.ParseHash(prefix); err == nil {
+			return []int{len(prefix)}
+		}
+	}
+	start := 0
+	if i := strings.Index(expr, "::"); i >= 0 && strings.IndexAny(expr[:i], "^~:") < 0 && !strings.Contains(expr[:i], "@{") {
+		// The base is an identity, so the separator is part of it.
+		start = i + 2
+	}
+	end := len(expr)
+	if i := strings.Index(expr[start:], "@{"); i >= 0 {
+		end = start + i
+	}
+	var lengths []int
+	for i := start; i < end; i++ {
+		if strings.IndexByte("^~:", expr[i]) >= 0 {
+			lengths = append(lengths, i)
+		}
+	}
+	if len(lengths) == 0 {
+		lengths = append(lengths, end)
+	}
+	return lengths
+}
+
+func parseCount(expr string) (int, string) {
+	i := 0
+	for i < len(expr) && expr[i] >= '0' && expr[i] <= '9' {
+		i++
+	}
+	if i == 0 {
+		return 1, expr
+	}
+	n, err := strconv.Atoi(expr[:i])
+	if err != nil {
+		return -1, expr
+	}
+	return n, expr[i:]
+}
+
+// Parse parses the given revision expression.
+//
+// If the base reference could end at more than one place, then the
+// shortest base that is followed by valid suffixes is used.
+func Parse(expr string) (*Expression, error) {
+	var firstErr error
+	for _, n := range baseLengths(expr) {
+		e, err := parseWithBase(expr, n)
+		if err == nil {
+			return e, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// parseWithBase parses the given revision expression, using the first `n`
+// bytes of it as the base reference.
+func parseWithBase(expr string, n int) (*Expression, error) {
+	e := &Expression{
+		Base:         expr[:n],
+		HistoryIndex: -1,
+	}
+	if len(e.Base) == 0 {
+		return nil, fmt.Errorf("missing the base reference in %q", expr)
+	}
+	rest := expr[n:]
+	for len(rest) > 0 {
+		switch {
+		case strings.HasPrefix(rest, "@{"):
+			if len(e.Steps) > 0 || e.HistoryIndex >= 0 || !e.HistoryTime.IsZero() {
+				return nil, fmt.Errorf("a history suffix must immediately follow the base reference in %q", expr)
+			}
+			end := strings.Index(rest, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated history suffix in %q", expr)
+			}
+			arg := rest[2:end]
+			rest = rest[end+1:]
+			if index, err := strconv.Atoi(arg); err == nil && index >= 0 {
+				e.HistoryIndex = index
+			} else if t, err := parseDate(arg); err == nil {
+				e.HistoryTime = t
+			} else {
+				return nil, fmt.Errorf("malformed history suffix %q in %q: %v", arg, expr, err)
+			}
+		case rest[0] == '^' || rest[0] == '~':
+			op := rest[0]
+			count, remaining := parseCount(rest[1:])
+			if count < 0 {
+				return nil, fmt.Errorf("malformed count in %q", expr)
+			}
+			e.Steps = append(e.Steps, Step{Op: op, N: count})
+			rest = remaining
+		case rest[0] == ':':
+			e.Subpath = snapshot.Path(rest[1:])
+			e.HasSubpath = true
+			rest = ""
+		default:
+			return nil, fmt.Errorf("unexpected suffix %q in %q", rest, expr)
+		}
+	}
+	return e, nil
+}
+
+// IsSimple reports whether or not the expression consists of just a base reference.
+func (e *Expression) IsSimple() bool {
+	return e.HistoryIndex < 0 && e.HistoryTime.IsZero() && len(e.Steps) == 0 && !e.HasSubpath
+}
+
+func nthParent(ctx context.Context, s *storage.LocalFiles, h *snapshot.Hash, n int) (*snapshot.Hash, error) {
+	if n == 0 {
+		return h, nil
+	}
+	f, err := s.ReadSnapshot(ctx, h)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the snapshot %q: %v", h, err)
+	}
+	if len(f.Parents) < n {
+		return nil, fmt.Errorf("the snapshot %q has %d parents", h, len(f.Parents))
+	}
+	return f.Parents[n-1], nil
+}
+
+// Resolve returns the hash of the snapshot referenced by the expression.
+func (e *Expression) Resolve(ctx context.Context, s *storage.LocalFiles, r Resolver) (*snapshot.Hash, error) {
+	var h *snapshot.Hash
+	var err error
+	switch {
+	case e.HistoryIndex >= 0:
+		h, err = r.Previous(ctx, e.Base, e.HistoryIndex)
+	case !e.HistoryTime.IsZero():
+		h, err = r.AsOf(ctx, e.Base, e.HistoryTime)
+	default:
+		h, err = r.Current(ctx, e.Base)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, fmt.Errorf("%q does not reference a snapshot", e.Base)
+	}
+	for _, step := range e.Steps {
+		switch step.Op {
+		case '^':
+			h, err = nthParent(ctx, s, h, step.N)
+			if err != nil {
+				return nil, err
+			}
+		case '~':
+			for i := 0; i < step.N; i++ {
+				h, err = nthParent(ctx, s, h, 1)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if !e.HasSubpath {
+		return h, nil
+	}
+	nested, _, err := s.ReadSubpath(ctx, h, e.Subpath)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the subpath %q of %q: %v", e.Subpath, h, err)
+	}
+	if nested == nil {
+		return nil, fmt.Errorf("there is no file at the subpath %q of %q", e.Subpath, h)
+	}
+	return nested, nil
+}
+
+// FromHistory returns the entry from the given history (ordered newest
+// first) that corresponds to either `n` updates ago (if `n` is non-negative),
+// or the given time.
+//
+// This is a helper for implementations of the `Resolver` interface.
+func FromHistory(history []*storage.HistoryEntry, n int, t time.Time) (*snapshot.Hash, error) {
+	if n >= 0 {
+		if n >= len(history) {
+			return nil, fmt.Errorf("the recorded history only has %d entries", len(history))
+		}
+		return history[n].Hash, nil
+	}
+	for _, entry := range history {
+		if !entry.Time.After(t) {
+			return entry.Hash, nil
+		}
+	}
+	return nil, fmt.Errorf("no values are known as of %s", t.Format(time.RFC3339))
+}
@@ -0,0 +1,157 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package revision
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func TestParse(t *testing.T) {
+	hash := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	testCases := []struct {
+		Expr    string
+		Base    string
+		Index   int
+		Steps   []Step
+		Subpath string
+	}{
+		{Expr: "dir", Base: "dir", Index: -1},
+		{Expr: hash, Base: hash, Index: -1},
+		{Expr: hash + "^2~3", Base: hash, Index: -1, Steps: []Step{{'^', 2}, {'~', 3}}},
+		{Expr: hash + ":a/b", Base: hash, Index: -1, Subpath: "a/b"},
+		{Expr: "ssh::AAAA^", Base: "ssh::AAAA", Index: -1, Steps: []Step{{'^', 1}}},
+		{Expr: "ssh::AAAA@{2}~:c", Base: "ssh::AAAA", Index: 2, Steps: []Step{{'~', 1}}, Subpath: "c"},
+		{Expr: "some/dir@{0}^0", Base: "some/dir", Index: 0, Steps: []Step{{'^', 0}}},
+		{Expr: "dir:x^1", Base: "dir", Index: -1, Subpath: "x^1"},
+		{Expr: "my~dir^2", Base: "my~dir", Index: -1, Steps: []Step{{'^', 2}}},
+		{Expr: "a~b^c~", Base: "a~b^c", Index: -1, Steps: []Step{{'~', 1}}},
+	}
+	for _, tc := range testCases {
+		e, err := Parse(tc.Expr)
+		if err != nil {
+			t.Errorf("failure parsing %q: %v", tc.Expr, err)
+			continue
+		}
+		if e.Base != tc.Base || e.HistoryIndex != tc.Index || string(e.Subpath) != tc.Subpath || e.HasSubpath != (len(tc.Subpath) > 0) {
+			t.Errorf("unexpected result parsing %q: %+v", tc.Expr, e)
+		}
+		if got, want := fmt.Sprintf("%v", e.Steps), fmt.Sprintf("%v", tc.Steps); got != want {
+			t.Errorf("unexpected steps parsing %q: got %s, want %s", tc.Expr, got, want)
+		}
+	}
+
+	if e, err := Parse("dir@{2022-03-04}"); err != nil {
+		t.Errorf("failure parsing a date expression: %v", err)
+	} else if want := time.Date(2022, 3, 4, 0, 0, 0, 0, time.Local); !e.HistoryTime.Equal(want) {
+		t.Errorf("unexpected date: got %v, want %v", e.HistoryTime, want)
+	}
+
+	for _, expr := range []string{"^1", "dir@{", "dir@{yesterday}", "dir^1@{1}", "dir@{1}@{2}"} {
+		if e, err := Parse(expr); err == nil {
+			t.Errorf("unexpected success parsing the malformed expression %q: %+v", expr, e)
+		}
+	}
+}
+
+type resolverForTest struct {
+	history []*storage.HistoryEntry
+}
+
+func (r *resolverForTest) Current(ctx context.Context, base string) (*snapshot.Hash, error) {
+	return r.history[0].Hash, nil
+}
+
+func (r *resolverForTest) Previous(ctx context.Context, base string, n int) (*snapshot.Hash, error) {
+	return FromHistory(r.history, n, time.Time{})
+}
+
+func (r *resolverForTest) AsOf(ctx context.Context, base string, t time.Time) (*snapshot.Hash, error) {
+	return FromHistory(r.history, -1, t)
+}
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storage.LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+	workDir := filepath.Join(dir, "work")
+	if err := os.MkdirAll(workDir, 0700); err != nil {
+		t.Fatalf("failure creating the work dir: %v", err)
+	}
+	file := filepath.Join(workDir, "example.txt")
+
+	// Build a history of three snapshots, each with the previous as its parent.
+	r := &resolverForTest{}
+	var hashes []*snapshot.Hash
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, contents := range []string{"one", "two", "three"} {
+		if err := os.WriteFile(file, []byte(contents), 0700); err != nil {
+			t.Fatalf("failure writing the example file: %v", err)
+		}
+		h, _, err := snapshot.Current(ctx, s, snapshot.Path(workDir))
+		if err != nil {
+			t.Fatalf("failure snapshotting the work dir: %v", err)
+		}
+		hashes = append(hashes, h)
+		entry := &storage.HistoryEntry{Time: start.AddDate(0, 0, i), Hash: h}
+		r.history = append([]*storage.HistoryEntry{entry}, r.history...)
+	}
+	fileHash, _, err := s.FindSnapshot(ctx, snapshot.Path(file))
+	if err != nil {
+		t.Fatalf("failure looking up the snapshot of the example file: %v", err)
+	}
+
+	testCases := map[string]*snapshot.Hash{
+		"work":              hashes[2],
+		"work^":             hashes[1],
+		"work~2":            hashes[0],
+		"work^1^1":          hashes[0],
+		"work@{1}":          hashes[1],
+		"work@{1}~":         hashes[0],
+		"work@{2022-01-02}": hashes[1],
+		"work@{2030-01-01}": hashes[2],
+		"work:example.txt":  fileHash,
+	}
+	for expr, want := range testCases {
+		e, err := Parse(expr)
+		if err != nil {
+			t.Errorf("failure parsing %q: %v", expr, err)
+			continue
+		}
+		if got, err := e.Resolve(ctx, s, r); err != nil {
+			t.Errorf("failure resolving %q: %v", expr, err)
+		} else if !got.Equal(want) {
+			t.Errorf("unexpected result resolving %q: got %q, want %q", expr, got, want)
+		}
+	}
+
+	for _, expr := range []string{"work~3", "work^2", "work@{3}", "work@{2021-12-31}", "work:missing.txt"} {
+		e, err := Parse(expr)
+		if err != nil {
+			t.Errorf("failure parsing %q: %v", expr, err)
+			continue
+		}
+		if got, err := e.Resolve(ctx, s, r); err == nil {
+			t.Errorf("unexpected success resolving %q: %q", expr, got)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package search defines methods for searching the contents of snapshots.
+package search
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/recursive-version-control-system/diff"
+	"github.com/google/recursive-version-control-system/log"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+// Match is a single line of a file that matches the search pattern.
+type Match struct {
+	// Path is the path of the nested file, relative to the searched snapshot.
+	//
+	// For snapshots that are not directories, this is ".".
+	Path string
+
+	// Line is the one-based number of the matching line, or 0 for
+	// binary files.
+	Line int
+
+	// Text is the matching line, without any trailing newline.
+	//
+	// This is empty for binary files.
+	Text string
+
+	// Binary reports whether or not the file is binary, in which case
+	// a single match is reported for the whole file.
+	Binary bool
+}
+
+// result is the outcome of searching a single file or directory.
+type result struct {
+	// matches holds the matching lines, in order of their paths.
+	matches []*Match
+
+	// counts holds the number of occurrences of the pattern in each
+	// nested file with at least one occurrence.
+	counts map[string]int
+}
+
+// Searcher searches for a pattern in snapshots.
+//
+// The result for each file contents and directory tree is cached by its
+// hash, so searching many snapshots that share most of their contents
+// (e.g. every snapshot in a history) only reads each distinct object once.
+type Searcher struct {
+	s       *storage.LocalFiles
+	pattern *regexp.Regexp
+
+	// files caches the results for file contents, and trees caches
+	// them for directory trees.
+	files map[snapshot.Hash]*result
+	trees map[snapshot.Hash]*result
+}
+
+// NewSearcher returns a `Searcher` for the given pattern.
+func NewSearcher(s *storage.LocalFiles, pattern *regexp.Regexp) *Searcher {
+	return &Searcher{
+		s:       s,
+		pattern: pattern,
+		files:   make(map[snapshot.Hash]*result),
+		trees:   make(map[snapshot.Hash]*result),
+	}
+}
+
+func (sr *Searcher) searchContents(ctx context.Context, f *snapshot.File) (*result, error) {
+	contents, binary, err := diff.ReadContents(ctx, sr.s, f)
+	if err != nil {
+		return nil, err
+	}
+	r := &result{counts: make(map[string]int)}
+	count := len(sr.pattern.FindAllIndex(contents, -1))
+	if count == 0 {
+		return r, nil
+	}
+	r.counts["."] = count
+	if binary {
+		r.matches = []*Match{{Path: ".", Binary: true}}
+		return r, nil
+	}
+	for i, line := range diff.SplitLines(string(contents)) {
+		line = strings.TrimSuffix(line, "\n")
+		if sr.pattern.MatchString(line) {
+			r.matches = append(r.matches, &Match{Path: ".", Line: i + 1, Text: line})
+		}
+	}
+	return r, nil
+}
+
+func (sr *Searcher) searchTree(ctx context.Context, h *snapshot.Hash, f *snapshot.File) (*result, error) {
+	tree, err := sr.s.ListDirectorySnapshotContents(ctx, h, f)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the tree for the snapshot %q: %v", h, err)
+	}
+	var children []string
+	for child, _ := range tree {
+		children = append(children, string(child))
+	}
+	sort.Strings(children)
+	r := &result{counts: make(map[string]int)}
+	for _, child := range children {
+		nested, err := sr.search(ctx, tree[snapshot.Path(child)])
+		if err != nil {
+			return nil, fmt.Errorf("failure searching the nested path %q: %v", child, err)
+		}
+		for _, m := range nested.matches {
+			r.matches = append(r.matches, &Match{
+				Path:   path.Join(child, m.Path),
+				Line:   m.Line,
+				Text:   m.Text,
+				Binary: m.Binary,
+			})
+		}
+		for p, count := range nested.counts {
+			r.counts[path.Join(child, p)] = count
+		}
+	}
+	return r, nil
+}
+
+func (sr *Searcher) search(ctx context.Context, h *snapshot.Hash) (*result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := sr.s.ReadSnapshot(ctx, h)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the snapshot %q: %v", h, err)
+	}
+	if f.IsLink() || f.Contents == nil {
+		return &result{}, nil
+	}
+	cache, searchFunc := sr.files, sr.searchContents
+	if f.IsDir() {
+		cache = sr.trees
+		searchFunc = func(ctx context.Context, f *snapshot.File) (*result, error) {
+			return sr.searchTree(ctx, h, f)
+		}
+	}
+	if r, ok := cache[*f.Contents]; ok {
+		return r, nil
+	}
+	r, err := searchFunc(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	cache[*f.Contents] = r
+	return r, nil
+}
+
+// Search returns every line matching the pattern in the given snapshot,
+// including every file nested within it if it is a directory.
+//
+// Symbolic links are not searched.
+func (sr *Searcher) Search(ctx context.Context, h *snapshot.Hash) ([]*Match, error) {
+	r, err := sr.search(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	return r.matches, nil
+}
+
+// Counts returns the number of occurrences of the pattern in each nested
+// file of the given snapshot, omitting files without any occurrences.
+func (sr *Searcher) Counts(ctx context.Context, h *snapshot.Hash) (map[string]int, error) {
+	r, err := sr.search(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	return r.counts, nil
+}
+
+// PickaxeChange describes a change in the number of occurrences of the
+// pattern in a single nested file.
+type PickaxeChange struct {
+	// Path is the path of the nested file, relative to the log entry's file.
+	Path string
+
+	// Before is the number of occurrences in the parent.
+	Before int
+
+	// After is the number of occurrences in the log entry.
+	After int
+}
+
+// PickaxeEntry is a log entry in which the number of occurrences of the
+// pattern changed.
+type PickaxeEntry struct {
+	*log.LogEntry
+
+	// Parent is the parent that the entry is compared against, or nil
+	// for entries without any parents.
+	Parent *snapshot.Hash
+
+	// Changes holds the nested files whose number of occurrences
+	// changed, sorted by path.
+	Changes []*PickaxeChange
+}
+
+// Pickaxe returns the log entries in which the number of occurrences of
+// the pattern changed, in the same order as the given entries.
+//
+// Each entry is compared against all of its parents, and a nested file is
+// only reported as changed if its number of occurrences differs from every
+// parent. That means merges are only reported when they change the
+// number of occurrences themselves, rather than just bringing in a
+// change from one side of the merge.
+func (sr *Searcher) Pickaxe(ctx context.Context, entries []*log.LogEntry) ([]*PickaxeEntry, error) {
+	var result []*PickaxeEntry
+	for _, e := range entries {
+		counts, err := sr.Counts(ctx, e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		var parentCounts []map[string]int
+		for _, p := range e.File.Parents {
+			pc, err := sr.Counts(ctx, p)
+			if err != nil {
+				return nil, fmt.Errorf("failure searching the parent %q of %q: %v", p, e.Hash, err)
+			}
+			parentCounts = append(parentCounts, pc)
+		}
+		if len(parentCounts) == 0 {
+			parentCounts = append(parentCounts, nil)
+		}
+		paths := make(map[string]struct{})
+		for p, _ := range counts {
+			paths[p] = struct{}{}
+		}
+		for _, pc := range parentCounts {
+			for p, _ := range pc {
+				paths[p] = struct{}{}
+			}
+		}
+		var changes []*PickaxeChange
+		for p, _ := range paths {
+			unchanged := false
+			for _, pc := range parentCounts {
+				if pc[p] == counts[p] {
+					unchanged = true
+				}
+			}
+			if !unchanged {
+				changes = append(changes, &PickaxeChange{
+					Path:   p,
+					Before: parentCounts[0][p],
+					After:  counts[p],
+				})
+			}
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].Path < changes[j].Path
+		})
+		entry := &PickaxeEntry{LogEntry: e, Changes: changes}
+		if len(e.File.Parents) > 0 {
+			entry.Parent = e.File.Parents[0]
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
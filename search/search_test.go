@@ -0,0 +1,106 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/recursive-version-control-system/log"
+	"github.com/google/recursive-version-control-system/snapshot"
+	"github.com/google/recursive-version-control-system/storage"
+)
+
+func TestSearch(t *testing.T) {
+	dir := t.TempDir()
+	s := &storage.LocalFiles{
+		ArchiveDir: filepath.Join(dir, "archive"),
+	}
+	workingDir := filepath.Join(dir, "working-dir")
+	if err := os.MkdirAll(filepath.Join(workingDir, "nested"), os.FileMode(0700)); err != nil {
+		t.Fatalf("failure creating the temporary working dir: %v", err)
+	}
+	ctx := context.Background()
+	takeSnapshot := func(files map[string]string) *snapshot.Hash {
+		for name, contents := range files {
+			p := filepath.Join(workingDir, name)
+			if err := os.WriteFile(p, []byte(contents), 0700); err != nil {
+				t.Fatalf("failure writing %q: %v", p, err)
+			}
+		}
+		h, _, err := snapshot.Current(ctx, s, snapshot.Path(workingDir))
+		if err != nil {
+			t.Fatalf("failure snapshotting the working dir: %v", err)
+		}
+		return h
+	}
+	h1 := takeSnapshot(map[string]string{
+		"a.txt":          "first\nsecret-token secret-token\nlast",
+		"nested/b.bin":   "\x00secret-token",
+		"nested/c.txt":   "nothing here\n",
+		"unrelated.text": "unrelated\n",
+	})
+	h2 := takeSnapshot(map[string]string{"a.txt": "first\nlast"})
+	h3 := takeSnapshot(map[string]string{"nested/c.txt": "secret-token\n"})
+
+	sr := NewSearcher(s, regexp.MustCompile("secret-token"))
+	matches, err := sr.Search(ctx, h1)
+	if err != nil {
+		t.Fatalf("failure searching the initial snapshot: %v", err)
+	}
+	want := []*Match{
+		{Path: "a.txt", Line: 2, Text: "secret-token secret-token"},
+		{Path: "nested/b.bin", Binary: true},
+	}
+	if diff := cmp.Diff(want, matches); len(diff) > 0 {
+		t.Errorf("unexpected matches in the initial snapshot: %s", diff)
+	}
+
+	entries, err := log.ReadLog(ctx, s, h3, -1)
+	if err != nil {
+		t.Fatalf("failure reading the log: %v", err)
+	}
+	pickaxe, err := sr.Pickaxe(ctx, entries)
+	if err != nil {
+		t.Fatalf("failure running the pickaxe search: %v", err)
+	}
+	if len(pickaxe) != 3 {
+		t.Fatalf("unexpected pickaxe entries: %+v", pickaxe)
+	}
+	wantChanges := []struct {
+		h       *snapshot.Hash
+		changes []*PickaxeChange
+	}{
+		{h3, []*PickaxeChange{{Path: "nested/c.txt", Before: 0, After: 1}}},
+		{h2, []*PickaxeChange{{Path: "a.txt", Before: 2, After: 0}}},
+		{h1, []*PickaxeChange{
+			{Path: "a.txt", Before: 0, After: 2},
+			{Path: "nested/b.bin", Before: 0, After: 1},
+		}},
+	}
+	for i, w := range wantChanges {
+		got := pickaxe[i]
+		if !got.Hash.Equal(w.h) {
+			t.Errorf("unexpected hash for pickaxe entry %d: got %q, want %q", i, got.Hash, w.h)
+		}
+		if diff := cmp.Diff(w.changes, got.Changes); len(diff) > 0 {
+			t.Errorf("unexpected changes for pickaxe entry %d: %s", i, diff)
+		}
+	}
+}
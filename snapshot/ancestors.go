@@ -0,0 +1,83 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// UpdateAncestors propagates the latest snapshot of a path up through the
+// snapshots of its ancestor directories.
+//
+// The passed in path must be an absolute path, and `h` must be the hash of
+// its latest snapshot (or nil if the path was removed).
+//
+// Each ancestor directory that already has a snapshot is updated by
+// replacing the entry for the corresponding child in its existing `Tree`,
+// so the siblings of that child are never rescanned. This stops at the
+// first ancestor that has no previous snapshot, is excluded, or whose
+// tree already references the updated child.
+//
+// The returned value lists the ancestor directories whose snapshots were
+// updated, ordered from the nearest ancestor to the furthest.
+func UpdateAncestors(ctx context.Context, s Storage, p Path, h *Hash) ([]Path, error) {
+	var updated []Path
+	for {
+		parent := Path(filepath.Dir(string(p)))
+		if parent == p {
+			return updated, nil
+		}
+		if s.Exclude(parent) {
+			return updated, nil
+		}
+		_, prev, err := s.FindSnapshot(ctx, parent)
+		if os.IsNotExist(err) {
+			return updated, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failure looking up the previous snapshot of %q: %v", parent, err)
+		}
+		if prev == nil {
+			return updated, nil
+		}
+		if !prev.IsDir() {
+			return updated, nil
+		}
+		child := Path(filepath.Base(string(p)))
+		prevChild, err := LookupTree(ctx, s, prev.Contents, child)
+		if err != nil {
+			return nil, fmt.Errorf("failure looking up %q in the previous snapshot of %q: %v", child, parent, err)
+		}
+		if prevChild.Equal(h) {
+			return updated, nil
+		}
+		info, err := os.Lstat(string(parent))
+		if err != nil {
+			return nil, fmt.Errorf("failure reading the file stat for %q: %v", parent, err)
+		}
+		contentsHash, err := UpdateTree(ctx, s, prev.Contents, Tree{child: h})
+		if err != nil {
+			return nil, fmt.Errorf("failure storing the updated contents of %q: %v", parent, err)
+		}
+		h, _, err = snapshotFileMetadata(ctx, s, parent, info, contentsHash)
+		if err != nil {
+			return nil, fmt.Errorf("failure updating the snapshot of %q: %v", parent, err)
+		}
+		updated = append(updated, parent)
+		p = parent
+	}
+}
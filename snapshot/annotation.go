@@ -0,0 +1,122 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Annotation holds human-readable context about a snapshot.
+//
+// Annotations are stored as separate objects that reference the snapshot
+// they describe, rather than as part of the `File` object. That way
+// adding, changing, or removing an annotation never changes the hash
+// of the annotated snapshot.
+type Annotation struct {
+	// Snapshot is the hash of the annotated `File` object.
+	Snapshot *Hash
+
+	// Author is a free-form description of who created the snapshot.
+	Author string
+
+	// Time is when the annotated snapshot was created.
+	Time time.Time
+
+	// Message is a free-form, possibly multi-line, description of the snapshot.
+	Message string
+}
+
+const (
+	annotationSnapshotKey = "snapshot"
+	annotationAuthorKey   = "author"
+	annotationTimeKey     = "time"
+)
+
+// Validate reports an error if the annotation cannot be serialized and
+// then parsed back unchanged.
+//
+// This is the case if any of the values in the header, such as the author,
+// span multiple lines.
+func (a *Annotation) Validate() error {
+	if strings.ContainsAny(a.Author, "\r\n") {
+		return fmt.Errorf("the annotation author %q must not contain line breaks", a.Author)
+	}
+	return nil
+}
+
+// String implements the `fmt.Stringer` interface.
+//
+// The resulting value is suitable for serialization. It consists of a
+// header of `<key> <value>` lines, followed by an empty line and then
+// the message.
+func (a *Annotation) String() string {
+	if a == nil {
+		return ""
+	}
+	lines := []string{annotationSnapshotKey + " " + a.Snapshot.String()}
+	if len(a.Author) > 0 {
+		lines = append(lines, annotationAuthorKey+" "+a.Author)
+	}
+	if !a.Time.IsZero() {
+		lines = append(lines, annotationTimeKey+" "+a.Time.UTC().Format(time.RFC3339))
+	}
+	lines = append(lines, "", a.Message)
+	return strings.Join(lines, "\n")
+}
+
+// ParseAnnotation parses an `Annotation` object from its encoded form.
+//
+// The input string must match the form returned by the `Annotation.String` method.
+func ParseAnnotation(encoded string) (*Annotation, error) {
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	parts := strings.SplitN(encoded, "\n\n", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed annotation %q: missing the message separator", encoded)
+	}
+	a := &Annotation{Message: parts[1]}
+	for _, line := range strings.Split(parts[0], "\n") {
+		kv := strings.SplitN(line, " ", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed annotation header line %q", line)
+		}
+		switch kv[0] {
+		case annotationSnapshotKey:
+			h, err := ParseHash(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("failure parsing the annotated snapshot hash %q: %v", kv[1], err)
+			}
+			a.Snapshot = h
+		case annotationAuthorKey:
+			a.Author = kv[1]
+		case annotationTimeKey:
+			t, err := time.Parse(time.RFC3339, kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("failure parsing the annotation time %q: %v", kv[1], err)
+			}
+			a.Time = t
+		default:
+			// Ignore unknown keys so that new ones can be added
+			// without breaking older versions of the tool.
+		}
+	}
+	if a.Snapshot == nil {
+		return nil, fmt.Errorf("malformed annotation %q: missing the annotated snapshot", encoded)
+	}
+	return a, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import "testing"
+
+func TestParseAnnotationRoundTrip(t *testing.T) {
+	testCases := []struct {
+		Description string
+		Serialized  string
+		Want        string
+		WantError   bool
+	}{
+		{
+			Description: "empty annotation string",
+		},
+		{
+			Description: "missing message separator",
+			Serialized:  "snapshot sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			WantError:   true,
+		},
+		{
+			Description: "missing snapshot",
+			Serialized:  "author someone\n\nmessage",
+			WantError:   true,
+		},
+		{
+			Description: "malformed time",
+			Serialized:  "snapshot sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\ntime yesterday\n\nmessage",
+			WantError:   true,
+		},
+		{
+			Description: "snapshot only",
+			Serialized:  "snapshot sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n\n",
+			Want:        "snapshot sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n\n",
+		},
+		{
+			Description: "all fields with a multi-line message",
+			Serialized:  "snapshot sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\nauthor Some One <someone@example.com>\ntime 2022-01-02T03:04:05Z\n\nSummary\n\nDetails",
+			Want:        "snapshot sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\nauthor Some One <someone@example.com>\ntime 2022-01-02T03:04:05Z\n\nSummary\n\nDetails",
+		},
+		{
+			Description: "unknown keys are ignored",
+			Serialized:  "snapshot sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\nfuture value\n\nmessage",
+			Want:        "snapshot sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n\nmessage",
+		},
+	}
+	for _, testCase := range testCases {
+		parsed, err := ParseAnnotation(testCase.Serialized)
+		if testCase.WantError {
+			if err == nil {
+				t.Errorf("unexpected response for test case %q: %+v", testCase.Description, parsed)
+			}
+		} else if err != nil {
+			t.Errorf("unexpected failure parsing the serialized annotation %q for the test case %q: %v", testCase.Serialized, testCase.Description, err)
+		} else if got, want := parsed.String(), testCase.Want; got != want {
+			t.Errorf("unexpected result for annotation parsing roundtrip of %q; got %q, want %q", testCase.Description, got, want)
+		}
+	}
+}
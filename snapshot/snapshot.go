@@ -25,6 +25,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/google/recursive-version-control-system/progress"
 )
 
 // Storage defines persistent storage of snapshots.
@@ -43,6 +45,9 @@ type Storage interface {
 	// StoreSnapshot stores a mapping from the given path to the given snapshot.
 	StoreSnapshot(context.Context, Path, *File) (*Hash, error)
 
+	// ReadObject returns a reader for the contents of a previously stored object.
+	ReadObject(context.Context, *Hash) (io.ReadCloser, error)
+
 	// CachePathInfo caches the file information for the given path.
 	//
 	// This is used to avoid rehashing the contents of files that have
@@ -135,6 +140,9 @@ func snapshotDirectory(ctx context.Context, s Storage, p Path, info os.FileInfo,
 	}
 	childHashes := make(Tree)
 	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
 		childPath := Path(filepath.Join(string(p), entry.Name()))
 		childHash, _, err := Current(ctx, s, childPath)
 		if err != nil {
@@ -144,8 +152,10 @@ func snapshotDirectory(ctx context.Context, s Storage, p Path, info os.FileInfo,
 			childHashes[Path(entry.Name())] = childHash
 		}
 	}
-	contentsJson := []byte(childHashes.String())
-	contentsHash, err := s.StoreObject(ctx, int64(len(contentsJson)), bytes.NewReader(contentsJson))
+	contentsHash, err := StoreTree(ctx, s, childHashes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure storing the contents of the directory %q: %v", p, err)
+	}
 	return snapshotFileMetadata(ctx, s, p, info, contentsHash)
 }
 
@@ -167,6 +177,9 @@ func snapshotLink(ctx context.Context, s Storage, p Path, info os.FileInfo) (*Ha
 // The passed in path must be an absolute path.
 //
 // The returned value is the hash of the generated `snapshot.File` object.
+//
+// Progress is reported to any `progress.Reporter` carried by the context,
+// and the snapshot is abandoned with the context's error if it is cancelled.
 func Current(ctx context.Context, s Storage, p Path) (*Hash, *File, error) {
 	if s.Exclude(p) {
 		// We are not supposed to store snapshots for the given path, so pretend it does not exist.
@@ -182,7 +195,11 @@ func Current(ctx context.Context, s Storage, p Path) (*Hash, *File, error) {
 		return nil, nil, fmt.Errorf("failure reading the file stat for %q: %v", p, err)
 	}
 	if stat.Mode()&fs.ModeSymlink != 0 {
-		return snapshotLink(ctx, s, p, stat)
+		h, f, err := snapshotLink(ctx, s, p, stat)
+		if err == nil {
+			progress.Add(ctx, 1, 0)
+		}
+		return h, f, err
 	}
 	contents, err := os.Open(string(p))
 	if os.IsNotExist(err) {
@@ -204,7 +221,42 @@ func Current(ctx context.Context, s Storage, p Path) (*Hash, *File, error) {
 	}
 	if info.IsDir() {
 		return snapshotDirectory(ctx, s, p, info, contents)
-	} else {
-		return snapshotRegularFile(ctx, s, p, info, contents)
 	}
+	h, f, err := snapshotRegularFile(ctx, s, p, info, contents)
+	if err == nil {
+		progress.Add(ctx, 1, info.Size())
+	}
+	return h, f, err
+}
+
+// unrecordedStorage wraps a `Storage` so that snapshots are stored without
+// mapping any paths to them.
+type unrecordedStorage struct {
+	Storage
+}
+
+// StoreSnapshot stores the given snapshot without mapping the path to it.
+func (s *unrecordedStorage) StoreSnapshot(ctx context.Context, p Path, f *File) (*Hash, error) {
+	bs := []byte(f.String())
+	h, err := s.StoreObject(ctx, int64(len(bs)), bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("failure storing the file snapshot for %q: %v", p, err)
+	}
+	return h, nil
+}
+
+// CachePathInfo does nothing, since the cached information is only valid
+// along with the mapping for the path.
+func (s *unrecordedStorage) CachePathInfo(context.Context, Path, os.FileInfo) error {
+	return nil
+}
+
+// Peek generates a snapshot for the given path the same way as `Current`,
+// but without mapping the path, or any of its nested paths, to the result.
+//
+// The generated snapshot is still stored, so it can be read back, but the
+// previous snapshots of the path remain its latest ones. This is meant for
+// commands that inspect a path without recording anything about it.
+func Peek(ctx context.Context, s Storage, p Path) (*Hash, *File, error) {
+	return Current(ctx, &unrecordedStorage{Storage: s}, p)
 }
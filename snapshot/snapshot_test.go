@@ -104,6 +104,20 @@ func (s *storageForTest) StoreSnapshot(ctx context.Context, p Path, f *File) (*H
 	return h, nil
 }
 
+// ReadObject returns a reader for the contents of a previously stored object.
+func (s *storageForTest) ReadObject(ctx context.Context, h *Hash) (io.ReadCloser, error) {
+	if s == nil {
+		return nil, fmt.Errorf("storage is not set")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bs, ok := s.objects[*h]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(bs)), nil
+}
+
 // CachePathInfo caches the file information for the given path.
 //
 // This is used to avoid rehashing the contents of files that have
@@ -467,3 +481,136 @@ func TestDirSnapshot(t *testing.T) {
 		t.Errorf("failed to update the snapshot for a nested file removal; got %+v", containerFile4)
 	}
 }
+
+func TestPeek(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storageForTest{}
+
+	nestedDir := filepath.Join(dir, "nested")
+	if err := os.MkdirAll(nestedDir, 0700); err != nil {
+		t.Fatalf("failure creating the test directories: %v", err)
+	}
+	file := filepath.Join(nestedDir, "example.txt")
+	if err := os.WriteFile(file, []byte("first"), 0700); err != nil {
+		t.Fatalf("failure writing the example file: %v", err)
+	}
+	h1, _, err := Current(ctx, s, Path(dir))
+	if err != nil {
+		t.Fatalf("failure creating the initial snapshot: %v", err)
+	}
+	if h, _, err := Peek(ctx, s, Path(dir)); err != nil || !h.Equal(h1) {
+		t.Errorf("unexpected result peeking at an unchanged dir: got %q, %v, want %q", h, err, h1)
+	}
+
+	if err := os.WriteFile(file, []byte("second"), 0700); err != nil {
+		t.Fatalf("failure updating the example file: %v", err)
+	}
+	fileHash, _, err := s.FindSnapshot(ctx, Path(file))
+	if err != nil {
+		t.Fatalf("failure looking up the snapshot of the example file: %v", err)
+	}
+	h2, f2, err := Peek(ctx, s, Path(dir))
+	if err != nil {
+		t.Fatalf("failure peeking at the updated dir: %v", err)
+	} else if h2.Equal(h1) || len(f2.Parents) != 1 || !f2.Parents[0].Equal(h1) {
+		t.Errorf("unexpected snapshot from peeking at the updated dir: %q, %+v", h2, f2)
+	}
+	for p, want := range map[Path]*Hash{Path(dir): h1, Path(file): fileHash} {
+		if got, _, err := s.FindSnapshot(ctx, p); err != nil || !got.Equal(want) {
+			t.Errorf("unexpected mapping for %q after peeking: got %q, %v, want %q", p, got, err, want)
+		}
+	}
+	if h3, _, err := Current(ctx, s, Path(dir)); err != nil || !h3.Equal(h2) {
+		t.Errorf("unexpected snapshot of the updated dir: got %q, %v, want %q", h3, err, h2)
+	}
+}
+
+func TestUpdateAncestors(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &storageForTest{}
+
+	subDir := filepath.Join(dir, "sub")
+	if err := os.Mkdir(subDir, 0700); err != nil {
+		t.Fatalf("failure creating the nested directory: %v", err)
+	}
+	file := filepath.Join(subDir, "example.txt")
+	sibling := filepath.Join(dir, "sibling.txt")
+	if err := os.WriteFile(file, []byte("Hello, World!"), 0700); err != nil {
+		t.Fatalf("failure creating the example file to snapshot: %v", err)
+	}
+	if err := os.WriteFile(sibling, []byte("Hello, Sibling!"), 0700); err != nil {
+		t.Fatalf("failure creating the sibling file: %v", err)
+	}
+	h1, _, err := Current(ctx, s, Path(dir))
+	if err != nil {
+		t.Fatalf("failure creating the initial snapshot of the directory: %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte("Goodbye, World!"), 0700); err != nil {
+		t.Fatalf("failure updating the example file: %v", err)
+	}
+	fileHash, _, err := Current(ctx, s, Path(file))
+	if err != nil {
+		t.Fatalf("failure snapshotting the updated example file: %v", err)
+	}
+	updated, err := UpdateAncestors(ctx, s, Path(file), fileHash)
+	if err != nil {
+		t.Fatalf("failure updating the ancestors of the example file: %v", err)
+	}
+	if got, want := fmt.Sprintf("%v", updated), fmt.Sprintf("%v", []Path{Path(subDir), Path(dir)}); got != want {
+		t.Errorf("unexpected ancestors updated: got %s, want %s", got, want)
+	}
+	h2, f2, err := s.FindSnapshot(ctx, Path(dir))
+	if err != nil {
+		t.Fatalf("failure looking up the updated directory snapshot: %v", err)
+	} else if h2.Equal(h1) {
+		t.Errorf("the directory snapshot was not updated")
+	} else if len(f2.Parents) != 1 || !f2.Parents[0].Equal(h1) {
+		t.Errorf("unexpected parents for the updated directory snapshot: %v", f2.Parents)
+	}
+
+	// The incrementally updated snapshot should match a full rescan.
+	if h3, _, err := Current(ctx, s, Path(dir)); err != nil {
+		t.Errorf("failure rescanning the directory: %v", err)
+	} else if !h3.Equal(h2) {
+		t.Errorf("incremental snapshot %q does not match the full rescan %q", h2, h3)
+	}
+
+	// Updating the ancestors again should be a no-op.
+	if updated, err := UpdateAncestors(ctx, s, Path(file), fileHash); err != nil {
+		t.Errorf("failure repeating the ancestor update: %v", err)
+	} else if len(updated) != 0 {
+		t.Errorf("unexpected ancestors updated for an unchanged file: %v", updated)
+	}
+
+	// Removing the file should remove it from the ancestor trees.
+	if err := os.Remove(file); err != nil {
+		t.Fatalf("failure removing the example file: %v", err)
+	}
+	if _, err := UpdateAncestors(ctx, s, Path(file), nil); err != nil {
+		t.Fatalf("failure updating the ancestors of the removed file: %v", err)
+	}
+	_, subFile, err := s.FindSnapshot(ctx, Path(subDir))
+	if err != nil {
+		t.Fatalf("failure looking up the updated nested directory snapshot: %v", err)
+	}
+	if child, err := LookupTree(ctx, s, subFile.Contents, Path(filepath.Base(file))); err != nil {
+		t.Errorf("failure looking up the removed file in the updated nested directory snapshot: %v", err)
+	} else if child != nil {
+		t.Errorf("unexpected entry for the removed file in the nested directory: %v", child)
+	}
+}
+
+func TestCurrentCancelled(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "example.txt"), []byte("Hello, World!"), 0700); err != nil {
+		t.Fatalf("failure creating the example file: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := Current(ctx, &storageForTest{}, Path(dir)); err == nil {
+		t.Errorf("unexpected success snapshotting with a cancelled context")
+	}
+}
@@ -15,10 +15,16 @@
 package snapshot
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -48,11 +54,22 @@ func decodePath(encoded string) (Path, error) {
 //
 // The keys are relative paths of the directory children, and the values
 // are the hashes of each child's latest snapshot.
+//
+// Trees are stored in one of two formats. Small trees are stored as a
+// single, flat object listing every child. Trees with more than
+// `maxFlatTreeEntries` children are instead sharded by the hash of each
+// child's name into a hash array mapped trie, so that looking up,
+// updating, or comparing children only has to read and write the shards
+// along the way, rather than the entire listing.
+//
+// The choice of format depends only on the children of the tree, so a
+// given tree always has the same hash regardless of how it was built.
 type Tree map[Path]*Hash
 
 // String implements the `fmt.Stringer` interface.
 //
-// The resulting value is suitable for serialization.
+// The resulting value is the flat serialization of the tree. Use the
+// `StoreTree` function to store a tree in whichever format is appropriate.
 func (t Tree) String() string {
 	var lines []string
 	for p, h := range t {
@@ -65,10 +82,93 @@ func (t Tree) String() string {
 	return strings.Join(lines, "\n")
 }
 
-// ParseTree parses a `Tree` object from its encoded form.
+// ObjectReader reads previously stored objects.
+type ObjectReader interface {
+	// ReadObject returns a reader for the contents of the given object.
+	ReadObject(context.Context, *Hash) (io.ReadCloser, error)
+}
+
+const (
+	// shardedTreeMarker is the first line of every sharded tree object.
+	//
+	// This can never be confused with an entry in a flat tree, as the
+	// base64 encoding used for child names never includes a `#`.
+	shardedTreeMarker = "#sharded-tree"
+
+	// maxTreeDepth is the maximum depth of a sharded tree.
+	//
+	// Each level of the tree consumes one byte of the child name hashes,
+	// so this is the size of those hashes.
+	maxTreeDepth = sha256.Size
+)
+
+// maxFlatTreeEntries is the maximum number of children stored in a flat tree object.
 //
-// The input string must match the form returned by the `Tree.String` method.
-func ParseTree(encoded string) (Tree, error) {
+// This is a variable rather than a constant so that tests can lower it.
+var maxFlatTreeEntries = 1024
+
+// treeShard describes one shard of a sharded tree.
+type treeShard struct {
+	// count is the number of children in the shard.
+	count int
+
+	// hash is the hash of the tree object holding the shard's children.
+	hash *Hash
+}
+
+// treeNode is a single stored tree object, which is either flat or sharded.
+type treeNode struct {
+	// depth is the number of sharded levels above this node.
+	depth int
+
+	// entries are the children of a flat node.
+	entries Tree
+
+	// shards are the shards of a sharded node, keyed by the hex encoded
+	// byte of the child name hashes at this node's depth.
+	//
+	// This is nil for flat nodes.
+	shards map[string]*treeShard
+}
+
+func shardKey(name Path, depth int) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[depth : depth+1])
+}
+
+func (n *treeNode) isSharded() bool {
+	return n.shards != nil
+}
+
+func (n *treeNode) count() int {
+	if !n.isSharded() {
+		return len(n.entries)
+	}
+	var total int
+	for _, shard := range n.shards {
+		total += shard.count
+	}
+	return total
+}
+
+func (n *treeNode) String() string {
+	if !n.isSharded() {
+		return n.entries.String()
+	}
+	lines := []string{fmt.Sprintf("%s %d", shardedTreeMarker, n.depth)}
+	var keys []string
+	for key := range n.shards {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		shard := n.shards[key]
+		lines = append(lines, fmt.Sprintf("%s %d %s", key, shard.count, shard.hash))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseFlatTree(encoded string) (Tree, error) {
 	t := make(Tree)
 	lines := strings.Split(encoded, "\n")
 	for _, line := range lines {
@@ -91,3 +191,341 @@ func ParseTree(encoded string) (Tree, error) {
 	}
 	return t, nil
 }
+
+func parseTreeNode(encoded string, depth int) (*treeNode, error) {
+	if !strings.HasPrefix(encoded, shardedTreeMarker) {
+		entries, err := parseFlatTree(encoded)
+		if err != nil {
+			return nil, err
+		}
+		return &treeNode{depth: depth, entries: entries}, nil
+	}
+	lines := strings.Split(encoded, "\n")
+	header := strings.TrimSpace(strings.TrimPrefix(lines[0], shardedTreeMarker))
+	if encodedDepth, err := strconv.Atoi(header); err != nil {
+		return nil, fmt.Errorf("malformed sharded tree header %q: %v", lines[0], err)
+	} else if encodedDepth != depth {
+		return nil, fmt.Errorf("unexpected depth for a sharded tree: got %d, want %d", encodedDepth, depth)
+	}
+	n := &treeNode{depth: depth, shards: make(map[string]*treeShard)}
+	for _, line := range lines[1:] {
+		if len(line) == 0 {
+			continue
+		}
+		parts := strings.Split(line, " ")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("malformed shard %q in sharded tree", line)
+		}
+		count, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed shard count in %q: %v", line, err)
+		}
+		h, err := ParseHash(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("failure parsing the shard hash in %q: %v", line, err)
+		}
+		n.shards[parts[0]] = &treeShard{count: count, hash: h}
+	}
+	return n, nil
+}
+
+func readTreeNode(ctx context.Context, r ObjectReader, h *Hash, depth int) (*treeNode, error) {
+	if h == nil {
+		return &treeNode{depth: depth, entries: make(Tree)}, nil
+	}
+	reader, err := r.ReadObject(ctx, h)
+	if err != nil {
+		return nil, fmt.Errorf("failure opening the tree object %q: %v", h, err)
+	}
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the tree object %q: %v", h, err)
+	}
+	n, err := parseTreeNode(string(contents), depth)
+	if err != nil {
+		return nil, fmt.Errorf("failure parsing the tree object %q: %v", h, err)
+	}
+	return n, nil
+}
+
+// allEntries adds every child under the given node to `result`.
+func (n *treeNode) allEntries(ctx context.Context, r ObjectReader, result Tree) error {
+	if !n.isSharded() {
+		for p, h := range n.entries {
+			result[p] = h
+		}
+		return nil
+	}
+	for _, shard := range n.shards {
+		child, err := readTreeNode(ctx, r, shard.hash, n.depth+1)
+		if err != nil {
+			return err
+		}
+		if err := child.allEntries(ctx, r, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseTree parses a `Tree` object from its encoded form.
+//
+// The input string must be a tree object in either the flat or sharded
+// format. The shards of a sharded tree are read using the given reader,
+// which may be nil if the tree is known to be flat.
+func ParseTree(ctx context.Context, r ObjectReader, encoded string) (Tree, error) {
+	n, err := parseTreeNode(encoded, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isSharded() {
+		return n.entries, nil
+	}
+	if r == nil {
+		return nil, fmt.Errorf("no object reader provided for reading a sharded tree")
+	}
+	t := make(Tree)
+	if err := n.allEntries(ctx, r, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func storeTreeNode(ctx context.Context, s Storage, n *treeNode) (*Hash, error) {
+	contents := []byte(n.String())
+	h, err := s.StoreObject(ctx, int64(len(contents)), bytes.NewReader(contents))
+	if err != nil {
+		return nil, fmt.Errorf("failure storing a tree object: %v", err)
+	}
+	return h, nil
+}
+
+func buildTreeNode(ctx context.Context, s Storage, t Tree, depth int) (*Hash, error) {
+	if len(t) <= maxFlatTreeEntries || depth >= maxTreeDepth {
+		return storeTreeNode(ctx, s, &treeNode{depth: depth, entries: t})
+	}
+	partitions := make(map[string]Tree)
+	for p, h := range t {
+		key := shardKey(p, depth)
+		if partitions[key] == nil {
+			partitions[key] = make(Tree)
+		}
+		partitions[key][p] = h
+	}
+	n := &treeNode{depth: depth, shards: make(map[string]*treeShard)}
+	for key, partition := range partitions {
+		h, err := buildTreeNode(ctx, s, partition, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		n.shards[key] = &treeShard{count: len(partition), hash: h}
+	}
+	return storeTreeNode(ctx, s, n)
+}
+
+func withoutNilEntries(t Tree) Tree {
+	result := make(Tree)
+	for p, h := range t {
+		if h != nil {
+			result[p] = h
+		}
+	}
+	return result
+}
+
+// StoreTree stores the given tree, returning the hash of the resulting tree object.
+//
+// Large trees are stored in the sharded format, and children with a nil
+// hash are omitted.
+func StoreTree(ctx context.Context, s Storage, t Tree) (*Hash, error) {
+	return buildTreeNode(ctx, s, withoutNilEntries(t), 0)
+}
+
+// LookupTree returns the hash of the given child in the stored tree object `h`.
+//
+// If the tree does not contain the child, then the returned hash is nil.
+//
+// For sharded trees, this only reads the shards along the path to the child.
+func LookupTree(ctx context.Context, r ObjectReader, h *Hash, child Path) (*Hash, error) {
+	depth := 0
+	for {
+		n, err := readTreeNode(ctx, r, h, depth)
+		if err != nil {
+			return nil, err
+		}
+		if !n.isSharded() {
+			return n.entries[child], nil
+		}
+		shard, ok := n.shards[shardKey(child, depth)]
+		if !ok {
+			return nil, nil
+		}
+		h = shard.hash
+		depth++
+	}
+}
+
+func updateTreeNode(ctx context.Context, s Storage, h *Hash, depth int, updates Tree) (*Hash, int, error) {
+	n, err := readTreeNode(ctx, s, h, depth)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !n.isSharded() {
+		for p, childHash := range updates {
+			if childHash == nil {
+				delete(n.entries, p)
+			} else {
+				n.entries[p] = childHash
+			}
+		}
+		updated, err := buildTreeNode(ctx, s, n.entries, depth)
+		return updated, len(n.entries), err
+	}
+	partitions := make(map[string]Tree)
+	for p, childHash := range updates {
+		key := shardKey(p, depth)
+		if partitions[key] == nil {
+			partitions[key] = make(Tree)
+		}
+		partitions[key][p] = childHash
+	}
+	for key, partition := range partitions {
+		var shardHash *Hash
+		if shard, ok := n.shards[key]; ok {
+			shardHash = shard.hash
+		}
+		updated, count, err := updateTreeNode(ctx, s, shardHash, depth+1, partition)
+		if err != nil {
+			return nil, 0, err
+		}
+		if count == 0 {
+			delete(n.shards, key)
+		} else {
+			n.shards[key] = &treeShard{count: count, hash: updated}
+		}
+	}
+	count := n.count()
+	if count > maxFlatTreeEntries {
+		updated, err := storeTreeNode(ctx, s, n)
+		return updated, count, err
+	}
+	// The tree has shrunk enough that it must be stored as a flat tree.
+	all := make(Tree)
+	if err := n.allEntries(ctx, s, all); err != nil {
+		return nil, 0, err
+	}
+	updated, err := buildTreeNode(ctx, s, all, depth)
+	return updated, count, err
+}
+
+// UpdateTree applies the given updates to the stored tree object `h`,
+// and returns the hash of the updated tree object.
+//
+// Each entry in `updates` either adds or replaces a child, or removes
+// the child if its hash is nil. If `h` is nil, then the updates are
+// applied to an empty tree.
+//
+// For sharded trees, this only reads and rewrites the shards along the
+// paths to the updated children, and the result is identical to what
+// `StoreTree` would produce for the full, updated tree.
+func UpdateTree(ctx context.Context, s Storage, h *Hash, updates Tree) (*Hash, error) {
+	updated, _, err := updateTreeNode(ctx, s, h, 0, updates)
+	return updated, err
+}
+
+func diffTreeNodes(ctx context.Context, r ObjectReader, old, new *Hash, depth int, oldChanged, newChanged Tree) error {
+	if old.Equal(new) {
+		return nil
+	}
+	oldNode, err := readTreeNode(ctx, r, old, depth)
+	if err != nil {
+		return err
+	}
+	newNode, err := readTreeNode(ctx, r, new, depth)
+	if err != nil {
+		return err
+	}
+	if oldNode.isSharded() && newNode.isSharded() {
+		keys := make(map[string]struct{})
+		for key := range oldNode.shards {
+			keys[key] = struct{}{}
+		}
+		for key := range newNode.shards {
+			keys[key] = struct{}{}
+		}
+		for key := range keys {
+			var oldShard, newShard *Hash
+			if shard, ok := oldNode.shards[key]; ok {
+				oldShard = shard.hash
+			}
+			if shard, ok := newNode.shards[key]; ok {
+				newShard = shard.hash
+			}
+			if err := diffTreeNodes(ctx, r, oldShard, newShard, depth+1, oldChanged, newChanged); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	oldEntries, newEntries := make(Tree), make(Tree)
+	if err := oldNode.allEntries(ctx, r, oldEntries); err != nil {
+		return err
+	}
+	if err := newNode.allEntries(ctx, r, newEntries); err != nil {
+		return err
+	}
+	for p, h := range oldEntries {
+		if !newEntries[p].Equal(h) {
+			oldChanged[p] = h
+		}
+	}
+	for p, h := range newEntries {
+		if !oldEntries[p].Equal(h) {
+			newChanged[p] = h
+		}
+	}
+	return nil
+}
+
+// DiffTrees compares the two stored tree objects `old` and `new`.
+//
+// The returned trees hold the old and new hashes, respectively, of only
+// the children that differ between the two. Either of the two input
+// hashes may be nil, in which case it is treated as an empty tree.
+//
+// For sharded trees, shards that are identical in both trees are skipped
+// without being read.
+func DiffTrees(ctx context.Context, r ObjectReader, old, new *Hash) (oldChanged, newChanged Tree, err error) {
+	oldChanged, newChanged = make(Tree), make(Tree)
+	if err := diffTreeNodes(ctx, r, old, new, 0, oldChanged, newChanged); err != nil {
+		return nil, nil, err
+	}
+	return oldChanged, newChanged, nil
+}
+
+// TreeObjects returns the hashes of every shard object that the stored
+// tree object `h` is composed of, not including `h` itself.
+//
+// This is empty for flat trees.
+func TreeObjects(ctx context.Context, r ObjectReader, h *Hash) ([]*Hash, error) {
+	var result []*Hash
+	var visit func(*Hash, int) error
+	visit = func(h *Hash, depth int) error {
+		n, err := readTreeNode(ctx, r, h, depth)
+		if err != nil {
+			return err
+		}
+		for _, shard := range n.shards {
+			result = append(result, shard.hash)
+			if err := visit(shard.hash, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(h, 0); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -14,7 +14,13 @@
 
 package snapshot
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
 
 func TestParseTreeRoundTrip(t *testing.T) {
 	testCases := []struct {
@@ -58,7 +64,7 @@ func TestParseTreeRoundTrip(t *testing.T) {
 		},
 	}
 	for _, testCase := range testCases {
-		parsed, err := ParseTree(testCase.Serialized)
+		parsed, err := ParseTree(context.Background(), nil, testCase.Serialized)
 		if testCase.WantError {
 			if err == nil {
 				t.Errorf("unexpected response for test case %q: %+v", testCase.Description, parsed)
@@ -70,3 +76,125 @@ func TestParseTreeRoundTrip(t *testing.T) {
 		}
 	}
 }
+
+// countingReader counts the number of objects read from the wrapped storage.
+type countingReader struct {
+	s     *storageForTest
+	reads int
+}
+
+func (r *countingReader) ReadObject(ctx context.Context, h *Hash) (io.ReadCloser, error) {
+	r.reads++
+	return r.s.ReadObject(ctx, h)
+}
+
+func testTree(t *testing.T, s *storageForTest, size int) Tree {
+	tree := make(Tree)
+	for i := 0; i < size; i++ {
+		contents := fmt.Sprintf("child %d", i)
+		h, err := s.StoreObject(context.Background(), int64(len(contents)), strings.NewReader(contents))
+		if err != nil {
+			t.Fatalf("failure storing a child object: %v", err)
+		}
+		tree[Path(fmt.Sprintf("child-%d", i))] = h
+	}
+	return tree
+}
+
+func TestShardedTree(t *testing.T) {
+	defer func(prev int) { maxFlatTreeEntries = prev }(maxFlatTreeEntries)
+	maxFlatTreeEntries = 4
+
+	ctx := context.Background()
+	s := &storageForTest{}
+	tree := testTree(t, s, 200)
+	h, err := StoreTree(ctx, s, tree)
+	if err != nil {
+		t.Fatalf("failure storing the tree: %v", err)
+	}
+	encoded, err := s.ReadObject(ctx, h)
+	if err != nil {
+		t.Fatalf("failure reading the stored tree: %v", err)
+	}
+	bs, err := io.ReadAll(encoded)
+	if err != nil {
+		t.Fatalf("failure reading the stored tree: %v", err)
+	}
+	if !strings.HasPrefix(string(bs), shardedTreeMarker) {
+		t.Errorf("unexpected encoding for a large tree: %q", bs)
+	}
+	if _, err := ParseTree(ctx, nil, string(bs)); err == nil {
+		t.Errorf("unexpected success parsing a sharded tree without a reader")
+	}
+	parsed, err := ParseTree(ctx, s, string(bs))
+	if err != nil {
+		t.Fatalf("failure parsing the sharded tree: %v", err)
+	}
+	if got, want := parsed.String(), tree.String(); got != want {
+		t.Errorf("unexpected result for the sharded tree roundtrip: got %q, want %q", got, want)
+	}
+	if objs, err := TreeObjects(ctx, s, h); err != nil {
+		t.Errorf("failure listing the tree objects: %v", err)
+	} else if len(objs) == 0 {
+		t.Errorf("unexpected empty list of shard objects for a sharded tree")
+	}
+
+	r := &countingReader{s: s}
+	if got, err := LookupTree(ctx, r, h, "child-17"); err != nil {
+		t.Errorf("failure looking up a child: %v", err)
+	} else if want := tree["child-17"]; !got.Equal(want) {
+		t.Errorf("unexpected result looking up a child: got %q, want %q", got, want)
+	}
+	if r.reads > 4 {
+		t.Errorf("looking up a single child read %d objects", r.reads)
+	}
+	if got, err := LookupTree(ctx, s, h, "missing"); err != nil || got != nil {
+		t.Errorf("unexpected result looking up a missing child: %q, %v", got, err)
+	}
+
+	// Incremental updates must produce the same hash as storing the whole tree.
+	added := testTree(t, s, 201)["child-200"]
+	updated, err := UpdateTree(ctx, s, h, Tree{"child-200": added, "child-3": nil})
+	if err != nil {
+		t.Fatalf("failure updating the tree: %v", err)
+	}
+	tree["child-200"] = added
+	delete(tree, "child-3")
+	if want, err := StoreTree(ctx, s, tree); err != nil {
+		t.Fatalf("failure storing the updated tree: %v", err)
+	} else if !updated.Equal(want) {
+		t.Errorf("unexpected hash for the updated tree: got %q, want %q", updated, want)
+	}
+
+	r.reads = 0
+	oldChanged, newChanged, err := DiffTrees(ctx, r, h, updated)
+	if err != nil {
+		t.Fatalf("failure diffing the trees: %v", err)
+	}
+	if len(oldChanged) != 1 || oldChanged["child-3"] == nil {
+		t.Errorf("unexpected old changes: got %q", oldChanged)
+	}
+	if len(newChanged) != 1 || !newChanged["child-200"].Equal(added) {
+		t.Errorf("unexpected new changes: got %q", newChanged)
+	}
+	if r.reads > 16 {
+		t.Errorf("diffing two trees with two changes read %d objects", r.reads)
+	}
+
+	// Shrinking the tree must switch it back to the flat format.
+	removals := make(Tree)
+	for p := range tree {
+		if p != "child-0" {
+			removals[p] = nil
+		}
+	}
+	shrunk, err := UpdateTree(ctx, s, updated, removals)
+	if err != nil {
+		t.Fatalf("failure shrinking the tree: %v", err)
+	}
+	if want, err := StoreTree(ctx, s, Tree{"child-0": tree["child-0"]}); err != nil {
+		t.Fatalf("failure storing the shrunk tree: %v", err)
+	} else if !shrunk.Equal(want) {
+		t.Errorf("unexpected hash for the shrunk tree: got %q, want %q", shrunk, want)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage defines the persistent storage of snapshots.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+)
+
+// AncestryEntry is the entry in the ancestry index for a single snapshot.
+//
+// The ancestry index records the parents of every indexed snapshot along
+// with its generation number, so that history queries do not have to read
+// and parse every snapshot along the way.
+type AncestryEntry struct {
+	// Generation is one more than the maximum generation of the
+	// snapshot's parents, or 1 if it has no parents.
+	//
+	// This means a snapshot can only be an ancestor of another if it has
+	// a strictly lower generation, which lets history queries stop as
+	// soon as they reach a generation lower than what they are looking for.
+	Generation int
+
+	// Parents are the parents of the snapshot.
+	Parents []*snapshot.Hash
+
+	// complete reports whether or not every ancestor of the snapshot
+	// was available when the entry was computed.
+	//
+	// Only complete entries are persisted, so that importing missing
+	// history later on will update the generations that depend on it.
+	complete bool
+}
+
+// incompleteAncestry caches the ancestry entries that are not persisted
+// because some of their history is missing.
+//
+// Without this, every history query over such a snapshot would have to
+// recompute the entries for all of its ancestors that were not persisted.
+type incompleteAncestry struct {
+	mu sync.Mutex
+
+	// entries are the cached entries for snapshots with missing history.
+	entries map[snapshot.Hash]*AncestryEntry
+
+	// missing are the ancestors that could not be read when computing
+	// the cached entries.
+	missing map[snapshot.Hash]struct{}
+}
+
+func (c *incompleteAncestry) lookup(h *snapshot.Hash) *AncestryEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[*h]
+}
+
+// add caches the entry for the given snapshot, or records it as missing.
+func (c *incompleteAncestry) add(h *snapshot.Hash, e *AncestryEntry, missing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[snapshot.Hash]*AncestryEntry)
+		c.missing = make(map[snapshot.Hash]struct{})
+	}
+	if missing {
+		c.missing[*h] = struct{}{}
+	} else {
+		c.entries[*h] = e
+	}
+}
+
+// stored invalidates the cached entries if the given object is one of the
+// ancestors that was missing when they were computed.
+func (c *incompleteAncestry) stored(h *snapshot.Hash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.missing[*h]; ok {
+		c.entries = nil
+		c.missing = nil
+	}
+}
+
+func (e *AncestryEntry) String() string {
+	lines := []string{strconv.Itoa(e.Generation)}
+	for _, p := range e.Parents {
+		lines = append(lines, p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseAncestryEntry(encoded string) (*AncestryEntry, error) {
+	lines := strings.Split(strings.TrimSpace(encoded), "\n")
+	generation, err := strconv.Atoi(lines[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed generation %q: %v", lines[0], err)
+	}
+	e := &AncestryEntry{Generation: generation, complete: true}
+	for _, line := range lines[1:] {
+		p, err := snapshot.ParseHash(line)
+		if err != nil {
+			return nil, fmt.Errorf("failure parsing the parent hash %q: %v", line, err)
+		}
+		e.Parents = append(e.Parents, p)
+	}
+	return e, nil
+}
+
+func (s *LocalFiles) ancestryFile(h *snapshot.Hash) (dir string, name string) {
+	return objectName(h, filepath.Join(s.ArchiveDir, "ancestry"), false)
+}
+
+func (s *LocalFiles) readAncestryEntry(h *snapshot.Hash) (*AncestryEntry, error) {
+	dir, name := s.ancestryFile(h)
+	bs, err := os.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the ancestry index entry for %q: %v", h, err)
+	}
+	e, err := parseAncestryEntry(string(bs))
+	if err != nil {
+		return nil, fmt.Errorf("failure parsing the ancestry index entry for %q: %v", h, err)
+	}
+	return e, nil
+}
+
+func (s *LocalFiles) writeAncestryEntry(h *snapshot.Hash, e *AncestryEntry) error {
+	dir, name := s.ancestryFile(h)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failure creating the ancestry index dir %q: %v", dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(e.String()), 0600); err != nil {
+		return fmt.Errorf("failure writing the ancestry index entry for %q: %v", h, err)
+	}
+	return nil
+}
+
+// Ancestry returns the ancestry index entry for the given snapshot.
+//
+// If the snapshot, or any of its ancestors, have not been indexed yet,
+// then they are read and added to the index.
+//
+// Missing ancestors are tolerated, and treated as if they had a generation
+// of 0. However, the snapshot itself must be available. Entries that depend
+// on missing ancestors are not persisted, but are cached until one of those
+// ancestors is stored.
+func (s *LocalFiles) Ancestry(ctx context.Context, h *snapshot.Hash) (*AncestryEntry, error) {
+	computed := make(map[snapshot.Hash]*AncestryEntry)
+	stack := []*snapshot.Hash{h}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		if _, ok := computed[*top]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if e := s.incomplete.lookup(top); e != nil {
+			computed[*top] = e
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if e, err := s.readAncestryEntry(top); err != nil {
+			return nil, err
+		} else if e != nil {
+			computed[*top] = e
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		f, err := s.ReadSnapshot(ctx, top)
+		if err != nil {
+			if top.Equal(h) {
+				return nil, err
+			}
+			// The history is incomplete.
+			computed[*top] = &AncestryEntry{}
+			s.incomplete.add(top, nil, true)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		pending := false
+		for _, p := range f.Parents {
+			if _, ok := computed[*p]; !ok {
+				stack = append(stack, p)
+				pending = true
+			}
+		}
+		if pending {
+			// Revisit this snapshot once its parents are computed.
+			continue
+		}
+		e := &AncestryEntry{Generation: 1, Parents: f.Parents, complete: true}
+		for _, p := range f.Parents {
+			parent := computed[*p]
+			if parent.Generation+1 > e.Generation {
+				e.Generation = parent.Generation + 1
+			}
+			e.complete = e.complete && parent.complete
+		}
+		if e.complete {
+			if err := s.writeAncestryEntry(top, e); err != nil {
+				return nil, err
+			}
+		} else {
+			s.incomplete.add(top, e, false)
+		}
+		computed[*top] = e
+		stack = stack[:len(stack)-1]
+	}
+	return computed[*h], nil
+}
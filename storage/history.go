@@ -0,0 +1,145 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage defines the persistent storage of snapshots.
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+)
+
+// HistoryEntry records a single update to the snapshot mapped to a path,
+// or to the latest signature of an identity.
+type HistoryEntry struct {
+	// Time is when the update was made.
+	Time time.Time
+
+	// Hash is the value after the update.
+	Hash *snapshot.Hash
+}
+
+func (s *LocalFiles) pathHistoryFile(p snapshot.Path) (dir string, name string, err error) {
+	pathHash, err := snapshot.NewHash(strings.NewReader(string(p)))
+	if err != nil {
+		return "", "", fmt.Errorf("failure hashing the path name %q: %v", p, err)
+	}
+	dir, name = objectName(pathHash, filepath.Join(s.ArchiveDir, "pathHistory"), false)
+	return dir, name, nil
+}
+
+func (s *LocalFiles) identityHistoryFile(id *snapshot.Identity) (dir string, name string, err error) {
+	idHash, err := snapshot.NewHash(strings.NewReader(id.String()))
+	if err != nil {
+		return "", "", fmt.Errorf("failure hashing the identity %q: %v", id, err)
+	}
+	dir, name = objectName(idHash, filepath.Join(s.ArchiveDir, "identityHistory"), false)
+	return dir, name, nil
+}
+
+// appendHistory records that the value tracked by the given history file
+// was updated to `h`.
+func appendHistory(dir, name string, h *snapshot.Hash) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failure creating the history dir %q: %v", dir, err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failure opening the history file: %v", err)
+	}
+	if _, err := fmt.Fprintf(f, "%s %s\n", time.Now().UTC().Format(time.RFC3339Nano), h); err != nil {
+		f.Close()
+		return fmt.Errorf("failure writing to the history file: %v", err)
+	}
+	return f.Close()
+}
+
+// readHistory reads the entries of the given history file, newest first.
+func readHistory(dir, name string) ([]*HistoryEntry, error) {
+	f, err := os.Open(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failure opening the history file: %v", err)
+	}
+	defer f.Close()
+	var entries []*HistoryEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed history entry %q", line)
+		}
+		t, err := time.Parse(time.RFC3339Nano, parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("failure parsing the time of the history entry %q: %v", line, err)
+		}
+		h, err := snapshot.ParseHash(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("failure parsing the hash of the history entry %q: %v", line, err)
+		}
+		entries = append(entries, &HistoryEntry{Time: t, Hash: h})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failure reading the history file: %v", err)
+	}
+	// The file is ordered oldest first, but the result is newest first.
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// PathHistory returns every snapshot that has been mapped to the given path, newest first.
+//
+// Only mappings made since history tracking was added are included, so
+// the result may be empty even if the path has a snapshot.
+func (s *LocalFiles) PathHistory(ctx context.Context, p snapshot.Path) ([]*HistoryEntry, error) {
+	dir, name, err := s.pathHistoryFile(p)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readHistory(dir, name)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the history of %q: %v", p, err)
+	}
+	return entries, nil
+}
+
+// IdentityHistory returns every signature that has been recorded for the given identity, newest first.
+//
+// Only signatures recorded since history tracking was added are included.
+func (s *LocalFiles) IdentityHistory(ctx context.Context, id *snapshot.Identity) ([]*HistoryEntry, error) {
+	dir, name, err := s.identityHistoryFile(id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readHistory(dir, name)
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the history of %q: %v", id, err)
+	}
+	return entries, nil
+}
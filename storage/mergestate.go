@@ -0,0 +1,196 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage defines the persistent storage of snapshots.
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/recursive-version-control-system/snapshot"
+)
+
+// MergeConflict is a nested path that could not be automatically merged.
+type MergeConflict struct {
+	// Path is the path of the conflict, relative to the merge destination.
+	Path snapshot.Path
+
+	// Kind describes how the conflict was written into the destination.
+	Kind string
+
+	// Sidecar is the path, relative to the merge destination, of an
+	// extra file written alongside `Path` to hold another version of it.
+	//
+	// This is empty if no such file was written.
+	Sidecar snapshot.Path
+
+	// Reason is a human readable description of the conflict.
+	Reason string
+}
+
+// MergeState records a merge that is in progress because it had conflicts.
+type MergeState struct {
+	// Base is the merge base, or nil if the two sides had no common ancestor.
+	Base *snapshot.Hash
+
+	// Source is the snapshot being merged in.
+	Source *snapshot.Hash
+
+	// Destination is the snapshot of the destination prior to the merge.
+	Destination *snapshot.Hash
+
+	// Conflicts are the nested paths that could not be automatically merged.
+	Conflicts []*MergeConflict
+}
+
+func encodeMergeField(field string) string {
+	if len(field) == 0 {
+		return "-"
+	}
+	return base64.RawStdEncoding.EncodeToString([]byte(field))
+}
+
+func decodeMergeField(encoded string) (string, error) {
+	if encoded == "-" {
+		return "", nil
+	}
+	decoded, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failure decoding the field %q: %v", encoded, err)
+	}
+	return string(decoded), nil
+}
+
+func (m *MergeState) String() string {
+	var lines []string
+	if m.Base != nil {
+		lines = append(lines, "base "+m.Base.String())
+	}
+	lines = append(lines, "source "+m.Source.String(), "destination "+m.Destination.String())
+	for _, c := range m.Conflicts {
+		lines = append(lines, strings.Join([]string{
+			"conflict",
+			c.Kind,
+			encodeMergeField(string(c.Path)),
+			encodeMergeField(string(c.Sidecar)),
+			encodeMergeField(c.Reason),
+		}, " "))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func parseMergeState(encoded string) (*MergeState, error) {
+	m := &MergeState{}
+	for _, line := range strings.Split(strings.TrimSpace(encoded), "\n") {
+		parts := strings.Split(line, " ")
+		switch {
+		case len(parts) == 2 && (parts[0] == "base" || parts[0] == "source" || parts[0] == "destination"):
+			h, err := snapshot.ParseHash(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("failure parsing the hash in %q: %v", line, err)
+			}
+			switch parts[0] {
+			case "base":
+				m.Base = h
+			case "source":
+				m.Source = h
+			case "destination":
+				m.Destination = h
+			}
+		case len(parts) == 5 && parts[0] == "conflict":
+			var fields []string
+			for _, part := range parts[2:] {
+				field, err := decodeMergeField(part)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, field)
+			}
+			m.Conflicts = append(m.Conflicts, &MergeConflict{
+				Path:    snapshot.Path(fields[0]),
+				Kind:    parts[1],
+				Sidecar: snapshot.Path(fields[1]),
+				Reason:  fields[2],
+			})
+		default:
+			return nil, fmt.Errorf("malformed merge state line %q", line)
+		}
+	}
+	if m.Source == nil || m.Destination == nil {
+		return nil, fmt.Errorf("merge state is missing either the source or destination")
+	}
+	return m, nil
+}
+
+func (s *LocalFiles) mergeStateFile(p snapshot.Path) (dir string, name string, err error) {
+	pathHash, err := snapshot.NewHash(strings.NewReader(string(p)))
+	if err != nil {
+		return "", "", fmt.Errorf("failure hashing the path name %q: %v", p, err)
+	}
+	dir, name = objectName(pathHash, filepath.Join(s.ArchiveDir, "mergeState"), false)
+	return dir, name, nil
+}
+
+// ReadMergeState reads the state of the in-progress merge into the given
+// path, or returns nil if there is no such merge.
+func (s *LocalFiles) ReadMergeState(ctx context.Context, p snapshot.Path) (*MergeState, error) {
+	dir, name, err := s.mergeStateFile(p)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := os.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failure reading the merge state for %q: %v", p, err)
+	}
+	m, err := parseMergeState(string(bs))
+	if err != nil {
+		return nil, fmt.Errorf("failure parsing the merge state for %q: %v", p, err)
+	}
+	return m, nil
+}
+
+// WriteMergeState records the state of an in-progress merge into the given path.
+func (s *LocalFiles) WriteMergeState(ctx context.Context, p snapshot.Path, m *MergeState) error {
+	dir, name, err := s.mergeStateFile(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failure creating the merge state dir %q: %v", dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(m.String()), 0600); err != nil {
+		return fmt.Errorf("failure writing the merge state for %q: %v", p, err)
+	}
+	return nil
+}
+
+// RemoveMergeState removes the record of an in-progress merge into the given path.
+func (s *LocalFiles) RemoveMergeState(ctx context.Context, p snapshot.Path) error {
+	dir, name, err := s.mergeStateFile(p)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failure removing the merge state for %q: %v", p, err)
+	}
+	return nil
+}
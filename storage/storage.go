@@ -29,6 +29,7 @@ import (
 
 	"filippo.io/age"
 
+	"github.com/google/recursive-version-control-system/progress"
 	"github.com/google/recursive-version-control-system/snapshot"
 )
 
@@ -43,6 +44,8 @@ const (
 // It is used to write and read snapshots to persistent storage.
 type LocalFiles struct {
 	ArchiveDir string
+
+	incomplete incompleteAncestry
 }
 
 // Exclude reports whether or not the given path should be excluded from snapshotting.
@@ -132,7 +135,7 @@ func (s *LocalFiles) StoreObject(ctx context.Context, size int64, reader io.Read
 			os.Remove(tmp.Name())
 		}
 	}()
-	reader = io.TeeReader(reader, dest)
+	reader = io.TeeReader(progress.Reader(ctx, reader), dest)
 	h, err = snapshot.NewHash(reader)
 	if err != nil {
 		return nil, fmt.Errorf("failure hashing an object: %v", err)
@@ -147,6 +150,7 @@ func (s *LocalFiles) StoreObject(ctx context.Context, size int64, reader io.Read
 	if err := os.Rename(tmp.Name(), storageLocation); err != nil {
 		return nil, fmt.Errorf("failure writing the object file for %q: %v", h, err)
 	}
+	s.incomplete.stored(h)
 	return h, nil
 }
 
@@ -250,9 +254,22 @@ func (s *LocalFiles) StoreSnapshot(ctx context.Context, p snapshot.Path, f *snap
 	if err := os.MkdirAll(pathHashDir, 0700); err != nil {
 		return nil, fmt.Errorf("failure creating the paths dir for %q: %v", p, err)
 	}
+	prevMapping, _ := os.ReadFile(filepath.Join(pathHashDir, pathHashFile))
 	if err := os.WriteFile(filepath.Join(pathHashDir, pathHashFile), []byte(h.String()), 0600); err != nil {
 		return nil, fmt.Errorf("failure writing the hash for path %q: %v", p, err)
 	}
+	if string(prevMapping) != h.String() {
+		historyDir, historyFile, err := s.pathHistoryFile(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := appendHistory(historyDir, historyFile, h); err != nil {
+			return nil, fmt.Errorf("failure recording the history of %q: %v", p, err)
+		}
+	}
+	if _, err := s.Ancestry(ctx, h); err != nil {
+		return nil, fmt.Errorf("failure indexing the ancestry of %q: %v", h, err)
+	}
 	var currTree snapshot.Tree
 	if f.IsDir() {
 		currTree, err = s.ListDirectorySnapshotContents(ctx, h, f)
@@ -333,13 +350,49 @@ func (s *LocalFiles) ListDirectorySnapshotContents(ctx context.Context, h *snaps
 	if err != nil {
 		return nil, fmt.Errorf("failure reading the contents of %q: %v", h, err)
 	}
-	tree, err := snapshot.ParseTree(string(contents))
+	tree, err := snapshot.ParseTree(ctx, s, string(contents))
 	if err != nil {
 		return nil, fmt.Errorf("failure parsing the directory contents of the snapshot %q: %v", h, err)
 	}
 	return tree, nil
 }
 
+// ReadSubpath returns the snapshot of the file nested at the given subpath
+// within the directory snapshot `h`.
+//
+// The subpath is relative to the root of `h`, and an empty subpath (or ".")
+// refers to `h` itself.
+//
+// If there is no file at the given subpath, then the returned hash and
+// file are both nil.
+func (s *LocalFiles) ReadSubpath(ctx context.Context, h *snapshot.Hash, subpath snapshot.Path) (*snapshot.Hash, *snapshot.File, error) {
+	f, err := s.ReadSnapshot(ctx, h)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleaned := filepath.Clean(string(subpath))
+	if cleaned == "." || cleaned == string(filepath.Separator) {
+		return h, f, nil
+	}
+	for _, name := range strings.Split(strings.Trim(cleaned, string(filepath.Separator)), string(filepath.Separator)) {
+		if !f.IsDir() {
+			return nil, nil, nil
+		}
+		h, err = snapshot.LookupTree(ctx, s, f.Contents, snapshot.Path(name))
+		if err != nil {
+			return nil, nil, err
+		}
+		if h == nil {
+			return nil, nil, nil
+		}
+		f, err = s.ReadSnapshot(ctx, h)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return h, f, nil
+}
+
 func (s *LocalFiles) RemoveMappingForPath(ctx context.Context, p snapshot.Path) error {
 	if err := os.RemoveAll(s.mappedPathsDir(p)); err != nil {
 		return fmt.Errorf("failure removing the mapped paths entry for %q: %v", p, err)
@@ -501,5 +554,86 @@ func (s *LocalFiles) UpdateSignatureForIdentity(ctx context.Context, id *snapsho
 	if err := os.MkdirAll(idDir, 0700); err != nil {
 		return fmt.Errorf("failure creating the id dir for %q: %v", id, err)
 	}
-	return os.WriteFile(idPath, []byte(h.String()), 0700)
+	prevSignature, _ := os.ReadFile(idPath)
+	if err := os.WriteFile(idPath, []byte(h.String()), 0700); err != nil {
+		return err
+	}
+	if string(prevSignature) == h.String() {
+		return nil
+	}
+	historyDir, historyFile, err := s.identityHistoryFile(id)
+	if err != nil {
+		return err
+	}
+	if err := appendHistory(historyDir, historyFile, h); err != nil {
+		return fmt.Errorf("failure recording the history of %q: %v", id, err)
+	}
+	return nil
+}
+
+func (s *LocalFiles) annotationFile(h *snapshot.Hash) (dir string, name string) {
+	return objectName(h, filepath.Join(s.ArchiveDir, "annotations"), false)
+}
+
+// StoreAnnotation persists the given annotation and links it to the snapshot it annotates.
+//
+// Any annotation previously linked to the same snapshot is replaced.
+func (s *LocalFiles) StoreAnnotation(ctx context.Context, a *snapshot.Annotation) (*snapshot.Hash, error) {
+	if a == nil || a.Snapshot == nil {
+		return nil, errors.New("an annotation must reference a snapshot")
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	bs := []byte(a.String())
+	h, err := s.StoreObject(ctx, int64(len(bs)), bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("failure saving the annotation for %q: %v", a.Snapshot, err)
+	}
+	annotationDir, annotationFile := s.annotationFile(a.Snapshot)
+	if err := os.MkdirAll(annotationDir, 0700); err != nil {
+		return nil, fmt.Errorf("failure creating the annotations dir for %q: %v", a.Snapshot, err)
+	}
+	if err := os.WriteFile(filepath.Join(annotationDir, annotationFile), []byte(h.String()), 0600); err != nil {
+		return nil, fmt.Errorf("failure writing the annotation link for %q: %v", a.Snapshot, err)
+	}
+	return h, nil
+}
+
+// FindAnnotation reads the annotation (if any) linked to the given snapshot.
+//
+// If there is no annotation for the snapshot, then the returned values are all nil.
+func (s *LocalFiles) FindAnnotation(ctx context.Context, h *snapshot.Hash) (*snapshot.Hash, *snapshot.Annotation, error) {
+	if h == nil {
+		return nil, nil, nil
+	}
+	annotationDir, annotationFile := s.annotationFile(h)
+	bs, err := os.ReadFile(filepath.Join(annotationDir, annotationFile))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure reading the annotation link for %q: %v", h, err)
+	}
+	annotationHash, err := snapshot.ParseHash(string(bs))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure parsing the annotation hash %q: %v", string(bs), err)
+	}
+	reader, err := s.ReadObject(ctx, annotationHash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure opening the annotation %q: %v", annotationHash, err)
+	}
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure reading the annotation %q: %v", annotationHash, err)
+	}
+	a, err := snapshot.ParseAnnotation(string(contents))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failure parsing the annotation %q: %v", annotationHash, err)
+	}
+	if !a.Snapshot.Equal(h) {
+		return nil, nil, fmt.Errorf("the annotation %q linked to %q is for a different snapshot, %q", annotationHash, h, a.Snapshot)
+	}
+	return annotationHash, a, nil
 }
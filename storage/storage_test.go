@@ -364,3 +364,274 @@ func TestDirSnapshot(t *testing.T) {
 		t.Errorf("unexpected hash and/or snapshot for a removed file: hash %q, snapshot %+v", file2Hash3, file2Snapshot3)
 	}
 }
+
+func TestAnnotations(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	s := &LocalFiles{ArchiveDir: archive}
+	ctx := context.Background()
+
+	file := filepath.Join(dir, "example.txt")
+	if err := os.WriteFile(file, []byte("Hello, World!"), 0700); err != nil {
+		t.Fatalf("failure creating the example file to snapshot: %v", err)
+	}
+	h, _, err := snapshot.Current(ctx, s, snapshot.Path(file))
+	if err != nil {
+		t.Fatalf("failure creating the snapshot for the file: %v", err)
+	}
+
+	if annotationHash, a, err := s.FindAnnotation(ctx, h); err != nil {
+		t.Errorf("failure looking up a missing annotation: %v", err)
+	} else if annotationHash != nil || a != nil {
+		t.Errorf("unexpected annotation for an unannotated snapshot: %q, %+v", annotationHash, a)
+	}
+
+	want := &snapshot.Annotation{
+		Snapshot: h,
+		Author:   "someone",
+		Message:  "first version",
+	}
+	wantHash, err := s.StoreAnnotation(ctx, want)
+	if err != nil {
+		t.Fatalf("failure storing the annotation: %v", err)
+	}
+	if annotationHash, a, err := s.FindAnnotation(ctx, h); err != nil {
+		t.Errorf("failure looking up the stored annotation: %v", err)
+	} else if !annotationHash.Equal(wantHash) {
+		t.Errorf("unexpected annotation hash: got %q, want %q", annotationHash, wantHash)
+	} else if got, want := a.String(), want.String(); got != want {
+		t.Errorf("unexpected annotation: got %q, want %q", got, want)
+	}
+
+	// An author spanning multiple lines would corrupt the annotation header.
+	if _, err := s.StoreAnnotation(ctx, &snapshot.Annotation{Snapshot: h, Author: "someone\ntime 2000-01-01T00:00:00Z"}); err == nil {
+		t.Error("unexpected success storing an annotation with a multi-line author")
+	}
+	if annotationHash, _, err := s.FindAnnotation(ctx, h); err != nil || !annotationHash.Equal(wantHash) {
+		t.Errorf("unexpected annotation after rejecting a multi-line author: %q, %v", annotationHash, err)
+	}
+
+	// Verify that the snapshot itself is unchanged by the annotation.
+	if h2, _, err := snapshot.Current(ctx, s, snapshot.Path(file)); err != nil {
+		t.Errorf("failure re-snapshotting the file: %v", err)
+	} else if !h2.Equal(h) {
+		t.Errorf("unexpected change in the snapshot hash after annotating it: got %q, want %q", h2, h)
+	}
+}
+
+func TestReadSubpath(t *testing.T) {
+	dir := t.TempDir()
+	s := &LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+	ctx := context.Background()
+
+	workDir := filepath.Join(dir, "work")
+	nestedDir := filepath.Join(workDir, "nested")
+	if err := os.MkdirAll(nestedDir, 0700); err != nil {
+		t.Fatalf("failure creating the nested dir: %v", err)
+	}
+	file := filepath.Join(nestedDir, "example.txt")
+	if err := os.WriteFile(file, []byte("Hello, World!"), 0700); err != nil {
+		t.Fatalf("failure creating the example file to snapshot: %v", err)
+	}
+	h, _, err := snapshot.Current(ctx, s, snapshot.Path(workDir))
+	if err != nil {
+		t.Fatalf("failure creating the snapshot for the dir: %v", err)
+	}
+	nestedHash, _, err := s.FindSnapshot(ctx, snapshot.Path(nestedDir))
+	if err != nil {
+		t.Fatalf("failure looking up the snapshot of the nested dir: %v", err)
+	}
+	fileHash, _, err := s.FindSnapshot(ctx, snapshot.Path(file))
+	if err != nil {
+		t.Fatalf("failure looking up the snapshot of the nested file: %v", err)
+	}
+
+	testCases := map[string]*snapshot.Hash{
+		"":                         h,
+		".":                        h,
+		"nested":                   nestedHash,
+		"nested/":                  nestedHash,
+		"nested/example.txt":       fileHash,
+		"/nested/example.txt":      fileHash,
+		"missing":                  nil,
+		"nested/example.txt/child": nil,
+	}
+	for subpath, want := range testCases {
+		got, f, err := s.ReadSubpath(ctx, h, snapshot.Path(subpath))
+		if err != nil {
+			t.Errorf("failure reading the subpath %q: %v", subpath, err)
+		} else if !got.Equal(want) {
+			t.Errorf("unexpected snapshot for the subpath %q: got %q, want %q", subpath, got, want)
+		} else if (f == nil) != (want == nil) {
+			t.Errorf("unexpected file snapshot for the subpath %q: %+v", subpath, f)
+		}
+	}
+}
+
+func TestPathHistory(t *testing.T) {
+	dir := t.TempDir()
+	s := &LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+	ctx := context.Background()
+
+	file := filepath.Join(dir, "example.txt")
+	var want []*snapshot.Hash
+	for _, contents := range []string{"one", "two", "two", "three"} {
+		if err := os.WriteFile(file, []byte(contents), 0700); err != nil {
+			t.Fatalf("failure writing the example file: %v", err)
+		}
+		h, _, err := snapshot.Current(ctx, s, snapshot.Path(file))
+		if err != nil {
+			t.Fatalf("failure snapshotting the example file: %v", err)
+		}
+		if len(want) == 0 || !want[0].Equal(h) {
+			want = append([]*snapshot.Hash{h}, want...)
+		}
+	}
+	history, err := s.PathHistory(ctx, snapshot.Path(file))
+	if err != nil {
+		t.Fatalf("failure reading the path history: %v", err)
+	}
+	if len(history) != len(want) {
+		t.Fatalf("unexpected path history: got %+v, want %v", history, want)
+	}
+	for i, entry := range history {
+		if !entry.Hash.Equal(want[i]) {
+			t.Errorf("unexpected history entry %d: got %q, want %q", i, entry.Hash, want[i])
+		}
+		if i > 0 && entry.Time.After(history[i-1].Time) {
+			t.Errorf("history entries are out of order: %+v", history)
+		}
+	}
+
+	id, err := snapshot.ParseIdentity("example::user")
+	if err != nil {
+		t.Fatalf("failure parsing the example identity: %v", err)
+	}
+	for _, h := range []*snapshot.Hash{want[1], want[0], want[0]} {
+		if err := s.UpdateSignatureForIdentity(ctx, id, h); err != nil {
+			t.Fatalf("failure updating the signature for %q: %v", id, err)
+		}
+	}
+	if history, err := s.IdentityHistory(ctx, id); err != nil {
+		t.Errorf("failure reading the identity history: %v", err)
+	} else if len(history) != 2 || !history[0].Hash.Equal(want[0]) || !history[1].Hash.Equal(want[1]) {
+		t.Errorf("unexpected identity history: %+v", history)
+	}
+}
+
+func TestAncestry(t *testing.T) {
+	ctx := context.Background()
+	s := &LocalFiles{ArchiveDir: filepath.Join(t.TempDir(), "archive")}
+	storeFile := func(f *snapshot.File) *snapshot.Hash {
+		bs := []byte(f.String())
+		h, err := s.StoreObject(ctx, int64(len(bs)), bytes.NewReader(bs))
+		if err != nil {
+			t.Fatalf("failure storing the snapshot %+v: %v", f, err)
+		}
+		return h
+	}
+	contents, err := s.StoreObject(ctx, 5, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("failure storing the snapshot contents: %v", err)
+	}
+	missingFile := &snapshot.File{Mode: "-r--------", Contents: contents}
+	missing, err := snapshot.NewHash(strings.NewReader(missingFile.String()))
+	if err != nil {
+		t.Fatalf("failure hashing a missing snapshot: %v", err)
+	}
+	root := storeFile(&snapshot.File{Mode: "-rw-------", Contents: contents})
+	left := storeFile(&snapshot.File{Mode: "-rw-------", Contents: contents, Parents: []*snapshot.Hash{root}})
+	right := storeFile(&snapshot.File{Mode: "-rwx------", Contents: contents, Parents: []*snapshot.Hash{root}})
+	next := storeFile(&snapshot.File{Mode: "-rwxr-----", Contents: contents, Parents: []*snapshot.Hash{right}})
+	merged := storeFile(&snapshot.File{Mode: "-rw-r-----", Contents: contents, Parents: []*snapshot.Hash{left, next}})
+	incomplete := storeFile(&snapshot.File{Mode: "-rw-r--r--", Contents: contents, Parents: []*snapshot.Hash{missing, merged}})
+
+	e, err := s.Ancestry(ctx, merged)
+	if err != nil {
+		t.Fatalf("failure reading the ancestry of the merged snapshot: %v", err)
+	}
+	if got, want := e.Generation, 4; got != want {
+		t.Errorf("unexpected generation for the merged snapshot: got %d, want %d", got, want)
+	}
+	if len(e.Parents) != 2 || !e.Parents[0].Equal(left) || !e.Parents[1].Equal(next) {
+		t.Errorf("unexpected parents for the merged snapshot: %v", e.Parents)
+	}
+	for _, h := range []*snapshot.Hash{root, left, right, next, merged} {
+		if e, err := s.readAncestryEntry(h); err != nil || e == nil {
+			t.Errorf("missing ancestry index entry for %q: %+v, %v", h, e, err)
+		}
+	}
+
+	if e, err := s.Ancestry(ctx, incomplete); err != nil {
+		t.Errorf("failure reading the ancestry of a snapshot with missing history: %v", err)
+	} else if got, want := e.Generation, 5; got != want {
+		t.Errorf("unexpected generation for the snapshot with missing history: got %d, want %d", got, want)
+	}
+	if e, err := s.readAncestryEntry(incomplete); err != nil || e != nil {
+		t.Errorf("unexpected ancestry index entry for a snapshot with missing history: %+v, %v", e, err)
+	}
+	if e := s.incomplete.lookup(incomplete); e == nil || e.Generation != 5 {
+		t.Errorf("unexpected cached ancestry for a snapshot with missing history: %+v", e)
+	}
+	if _, err := s.Ancestry(ctx, missing); err == nil {
+		t.Errorf("unexpected success reading the ancestry of a missing snapshot")
+	}
+
+	// Storing the missing snapshot invalidates the cached entries.
+	if h := storeFile(missingFile); !h.Equal(missing) {
+		t.Fatalf("unexpected hash for the missing snapshot: got %q, want %q", h, missing)
+	}
+	if e := s.incomplete.lookup(incomplete); e != nil {
+		t.Errorf("unexpected cached ancestry after storing the missing history: %+v", e)
+	}
+	if _, err := s.Ancestry(ctx, incomplete); err != nil {
+		t.Errorf("failure reading the ancestry of a snapshot with imported history: %v", err)
+	} else if e, err := s.readAncestryEntry(incomplete); err != nil || e == nil || e.Generation != 5 {
+		t.Errorf("unexpected ancestry index entry after importing the missing history: %+v, %v", e, err)
+	}
+}
+
+func TestMergeState(t *testing.T) {
+	dir := t.TempDir()
+	s := &LocalFiles{ArchiveDir: filepath.Join(dir, "archive")}
+	ctx := context.Background()
+	dest := snapshot.Path(filepath.Join(dir, "dest"))
+
+	if state, err := s.ReadMergeState(ctx, dest); err != nil || state != nil {
+		t.Fatalf("unexpected merge state before any merge: %+v, %v", state, err)
+	}
+	hashOf := func(contents string) *snapshot.Hash {
+		h, err := snapshot.NewHash(strings.NewReader(contents))
+		if err != nil {
+			t.Fatalf("failure hashing %q: %v", contents, err)
+		}
+		return h
+	}
+	want := &MergeState{
+		Source:      hashOf("source"),
+		Destination: hashOf("destination"),
+		Conflicts: []*MergeConflict{
+			{Path: "a.txt", Kind: "markers", Reason: "conflicting changes\nin a.txt"},
+			{Path: "nested/b.bin", Kind: "versions", Sidecar: "nested/b.bin.extra"},
+		},
+	}
+	if err := s.WriteMergeState(ctx, dest, want); err != nil {
+		t.Fatalf("failure writing the merge state: %v", err)
+	}
+	got, err := s.ReadMergeState(ctx, dest)
+	if err != nil {
+		t.Fatalf("failure reading the merge state: %v", err)
+	}
+	if diff := cmp.Diff(want.String(), got.String()); len(diff) > 0 {
+		t.Errorf("unexpected merge state read back: %s", diff)
+	}
+	if len(got.Conflicts) != 2 || got.Conflicts[0].Reason != want.Conflicts[0].Reason || got.Conflicts[1].Sidecar != want.Conflicts[1].Sidecar {
+		t.Errorf("unexpected conflicts read back: %+v", got.Conflicts)
+	}
+	if err := s.RemoveMergeState(ctx, dest); err != nil {
+		t.Fatalf("failure removing the merge state: %v", err)
+	}
+	if state, err := s.ReadMergeState(ctx, dest); err != nil || state != nil {
+		t.Errorf("unexpected merge state after removing it: %+v, %v", state, err)
+	}
+}